@@ -0,0 +1,92 @@
+package apu
+
+// CPU clock rates in Hz, which the APU is clocked at
+const (
+	ClockNTSC = 1789773
+	ClockPAL  = 1662607
+)
+
+// DefaultSampleRate is the audio sample rate used when none is given
+const DefaultSampleRate = 44100
+
+// APU emulates the audio processing unit on the 2A03. Its registers are
+// kept, but the pulse, triangle, noise and DMC channels aren't emulated yet,
+// so its output is only the cartridge's expansion audio.
+type APU struct {
+	// Expansion returns the cartridge's expansion audio level, or is nil if
+	// the cartridge has none
+	Expansion func() float32
+
+	registers [0x18]uint8
+
+	clockRate  int
+	sampleRate int
+
+	// sampleClock counts up by the sample rate every cycle, and a sample is
+	// taken each time it passes the clock rate
+	sampleClock int
+	samples     []float32
+}
+
+// Init sets the APU to its power-up state, to be clocked at clockRate and
+// produce samples at sampleRate.
+func (a *APU) Init(clockRate int, sampleRate int) {
+	if sampleRate <= 0 {
+		sampleRate = DefaultSampleRate
+	}
+	a.registers = [0x18]uint8{}
+	a.clockRate = clockRate
+	a.sampleRate = sampleRate
+	a.sampleClock = 0
+	a.samples = nil
+}
+
+// Reset silences the channels, as resetting the console does
+func (a *APU) Reset() {
+	a.WriteRegister(0x4015, 0)
+}
+
+// WriteRegister writes one of the registers at $4000-$4013, $4015 and $4017
+func (a *APU) WriteRegister(address uint16, value uint8) {
+	if address >= 0x4000 && address < 0x4018 {
+		a.registers[address-0x4000] = value
+	}
+}
+
+// ReadStatus returns $4015. With no channels emulated, no length counters
+// are running and no interrupts are pending.
+func (a *APU) ReadStatus() uint8 {
+	return 0
+}
+
+// Clock advances the APU by one CPU cycle
+func (a *APU) Clock() {
+	a.sampleClock += a.sampleRate
+	if a.sampleClock < a.clockRate {
+		return
+	}
+	a.sampleClock -= a.clockRate
+
+	var sample float32
+	if a.Expansion != nil {
+		sample += a.Expansion()
+	}
+	a.samples = append(a.samples, sample)
+}
+
+// Samples returns the samples produced since it was last called
+func (a *APU) Samples() []float32 {
+	samples := a.samples
+	a.samples = nil
+	return samples
+}
+
+// ClockRate is the rate the APU is clocked at in Hz
+func (a *APU) ClockRate() int {
+	return a.clockRate
+}
+
+// SampleRate is the rate samples are produced at in Hz
+func (a *APU) SampleRate() int {
+	return a.sampleRate
+}
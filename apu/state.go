@@ -0,0 +1,29 @@
+package apu
+
+import (
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// stateVersion is the version of the APU's save state section
+const stateVersion = 1
+
+// SaveState writes the APU's registers and sample timing. Samples not yet
+// taken by Samples are dropped.
+func (a *APU) SaveState(w io.Writer) error {
+	return state.Save(w, stateVersion, a.serialize)
+}
+
+// LoadState restores the APU from a state written by SaveState
+func (a *APU) LoadState(r io.Reader) error {
+	return state.Load(r, stateVersion, a.serialize)
+}
+
+func (a *APU) serialize(s *state.Stream) {
+	s.Bytes(a.registers[:])
+	s.Int(&a.sampleClock)
+	if s.Loading() {
+		a.samples = nil
+	}
+}
@@ -0,0 +1,96 @@
+package cartridge
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// romExtensions are the file names recognized as ROM images inside a zip
+// archive
+var romExtensions = map[string]bool{
+	".nes":  true,
+	".fds":  true,
+	".qd":   true,
+	".unf":  true,
+	".unif": true,
+}
+
+// maxImageSize is the most an archived ROM image may decompress to: room for
+// the largest PRG and CHR ROM a header can give, with a megabyte to spare
+// for the header, trainer and anything after the ROM
+const maxImageSize = 2*maxROMSize + 1<<20
+
+// unpack returns the ROM image compressed in a zip or gzip archive, or data
+// itself when it isn't an archive.
+func unpack(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return unzip(data)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return readImage(reader)
+	case bytes.HasPrefix(data, []byte("7z\xbc\xaf\x27\x1c")):
+		return nil, ErrUnsupportedArchive
+	}
+	return data, nil
+}
+
+// unzip extracts the one ROM image in a zip archive, ignoring anything else
+// such as readme files.
+func unzip(data []byte) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var roms []*zip.File
+	for _, file := range archive.File {
+		if romExtensions[strings.ToLower(path.Ext(file.Name))] {
+			roms = append(roms, file)
+		}
+	}
+
+	switch len(roms) {
+	case 0:
+		return nil, ErrNoROMInArchive
+	case 1:
+	default:
+		names := make([]string, len(roms))
+		for i, file := range roms {
+			names[i] = file.Name
+		}
+		return nil, &MultipleROMsError{names}
+	}
+
+	if roms[0].UncompressedSize64 > maxImageSize {
+		return nil, ErrImageTooLarge
+	}
+	reader, err := roms[0].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readImage(reader)
+}
+
+// readImage decompresses a ROM image, stopping at maxImageSize so that a
+// small archive can't fill memory
+func readImage(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, ErrImageTooLarge
+	}
+	return data, nil
+}
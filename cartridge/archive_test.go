@@ -0,0 +1,21 @@
+package cartridge
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func TestUnpackRejectsGzipBomb(t *testing.T) {
+	var archive bytes.Buffer
+	w := gzip.NewWriter(&archive)
+	chunk := make([]byte, 1<<20)
+	for written := 0; written <= maxImageSize; written += len(chunk) {
+		w.Write(chunk)
+	}
+	w.Close()
+
+	if _, err := unpack(archive.Bytes()); err != ErrImageTooLarge {
+		t.Errorf("unpack returned %v, want ErrImageTooLarge", err)
+	}
+}
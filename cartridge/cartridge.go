@@ -1,13 +1,28 @@
 package cartridge
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"runtime/debug"
 
 	"github.com/makononov/NESGo/cartridge/mappers"
+	"github.com/makononov/NESGo/cartridge/romdb"
 )
 
 const prgRomBlockSize int = 16384
 const chrRomBlockSize int = 8192
+
+// prgRAMSize is the PRG RAM at $6000-$7FFF on boards whose mapper doesn't
+// decode that range itself. Boards without any still get it, as some
+// hacked dumps rely on it being there.
+const prgRAMSize int = 8192
+
+// trainerAddress is where a trainer is loaded in PRG RAM
+const trainerAddress = 0x7000
+
 const (
 	// MirrorHorizontal is used to indicate that the cartridge uses
 	// horizontal mirroring
@@ -24,14 +39,25 @@ const (
 
 	// PAL indicates the cartridge uses the PAL TV system format
 	PAL = iota
+
+	// MultiRegion indicates the cartridge runs on both NTSC and PAL
+	// consoles
+	MultiRegion = iota
+
+	// Dendy indicates the cartridge is for the Dendy and other famiclones
+	// with PAL video and NTSC-like timing
+	Dendy = iota
 )
 
 // A Cartridge represents a game cartridge loaded into the system. It is
-// generally created from a ROM file by calling cartridge.ParseROM().
+// generally created from a ROM file by calling cartridge.ParseROM(), or from
+// a ROM image in memory with cartridge.Parse() or cartridge.ParseBytes().
 type Cartridge struct {
 	PrgRomSize        int
 	ChrRomSize        int
 	MapperID          int
+	Submapper         int
+	NES2              bool
 	FourScreen        bool
 	TrainerPresent    bool
 	BatteryBackedSRAM bool
@@ -40,33 +66,192 @@ type Cartridge struct {
 	VsUnisystem       bool
 	TVSystemFormat    int
 
+	// RAM sizes in bytes from a NES 2.0 header or the ROM database. NVRAM is
+	// battery-backed.
+	PrgRamSize   int
+	PrgNvramSize int
+	ChrRamSize   int
+	ChrNvramSize int
+
+	// CRC32 and SHA1 are hashes of the PRG ROM followed by the CHR ROM, or
+	// of the disk sides for disk images, which identify the dump.
+	CRC32 uint32
+	SHA1  [20]byte
+
+	// MD5 is a hash of the same data, which FCEUX movies identify ROMs by
+	MD5 [16]byte
+
+	// DatabaseEntry is the known dump the hashes matched in the ROM
+	// database, whose header fields take precedence over the file's.
+	DatabaseEntry *romdb.Entry
+
+	// Board is the circuit board name UNIF images give in place of a mapper
+	// number.
+	Board string
+
+	// Controllers is the UNIF bitmask of input devices the game supports:
+	// standard controllers, Zapper, R.O.B., Arkanoid controller, Power Pad
+	// and Four Score, from bit 0 up.
+	Controllers int
+
 	Mapper mapper.Mapper
 
+	// newMapper creates the mapper in its power-up state and hands it its
+	// ROM, for PowerCycle to replace it
+	newMapper func() (mapper.Mapper, error)
+
 	Trainer []byte
-	CHR     []byte
 	RAM     []byte
+
+	// CHR is the CHR ROM followed by any CHR RAM, which is writable from
+	// the PPU
+	CHR []byte
+
+	// VRAM is the console's nametable RAM. The cartridge controls how it is
+	// mapped into the PPU address space, and may extend it to 4KB.
+	VRAM []byte
+
+	// SavePath is where state that outlives a power cycle, like saved games
+	// on a disk, is persisted.
+	SavePath string
+
+	// Patches lists the patch files ParseROM applied to the ROM image, in
+	// order.
+	Patches []string
+
+	// Warnings lists problems found in the ROM image that didn't stop it
+	// from loading.
+	Warnings []string
 }
 
-// Init initializes necessary values in the cartridge struct
+// Init powers the cartridge up, clearing PRG RAM and loading the trainer if
+// there is one. ParseROM does this itself.
 func (cartridge *Cartridge) Init() error {
-	cartridge.RAM = make([]byte, 2048)
+	cartridge.RAM = make([]byte, prgRAMSize)
+	return cartridge.loadTrainer()
+}
+
+// Reset loads the trainer into PRG RAM again, since the hacks and
+// translations that patch games through one expect it there after a reset
+// as well as at power-up.
+func (cartridge *Cartridge) Reset() {
+	cartridge.loadTrainer()
+}
+
+// PowerCycle turns the cartridge off and on again. The mapper is replaced
+// with a new one in its power-up state, which is given the old one's save;
+// PRG RAM is cleared unless it is battery-backed, and the trainer is loaded
+// again. If the new mapper can't be created, the old one is kept.
+func (cartridge *Cartridge) PowerCycle() error {
+	if cartridge.newMapper == nil {
+		return errors.New("Cartridge wasn't parsed, so its mapper can't be created again")
+	}
+	m, err := cartridge.createMapper()
+	if err != nil {
+		return err
+	}
+	if saver, ok := cartridge.Mapper.(mapper.SaveMapper); ok {
+		var save bytes.Buffer
+		if err = saver.WriteSave(&save); err != nil {
+			return err
+		}
+		if err = m.(mapper.SaveMapper).LoadSave(&save); err != nil {
+			return err
+		}
+	}
+	cartridge.Mapper = m
+
+	if !cartridge.BatteryBackedSRAM {
+		for i := range cartridge.RAM {
+			cartridge.RAM[i] = 0
+		}
+	}
+	cartridge.loadTrainer()
+	return nil
+}
+
+// createMapper creates a new mapper and connects it to the CHR memory and
+// nametable RAM
+func (cartridge *Cartridge) createMapper() (mapper.Mapper, error) {
+	m, err := cartridge.newMapper()
+	if err != nil {
+		return nil, err
+	}
+	if ppuMapper, ok := m.(mapper.PPUMapper); ok {
+		if err = ppuMapper.InitPPU(cartridge.CHR, cartridge.VRAM); err != nil {
+			return nil, err
+		}
+	}
+	if chrRAM, ok := m.(mapper.CHRRAMMapper); ok && len(cartridge.CHR) > cartridge.ChrRomSize {
+		chrRAM.SetCHRRAM(cartridge.ChrRomSize)
+	}
+	return m, nil
+}
+
+// loadTrainer copies the trainer to $7000, into the mapper's PRG RAM if it
+// has its own
+func (cartridge *Cartridge) loadTrainer() error {
+	if cartridge.Trainer == nil {
+		return nil
+	}
+
+	switch m := cartridge.Mapper.(type) {
+	case mapper.TrainerMapper:
+		return m.LoadTrainer(cartridge.Trainer)
+	case mapper.LowMapper:
+		return fmt.Errorf("Mapper %d has no PRG RAM at $%04x to load the trainer into", cartridge.MapperID, trainerAddress)
+	}
+	copy(cartridge.RAM[trainerAddress-0x6000:], cartridge.Trainer)
 	return nil
 }
 
 // WaitForReadWrite watches the control channel and responds to requests
-func (cartridge *Cartridge) WaitForReadWrite(cartridgeControlBus chan uint16, readWriteBus chan int, dataBus chan uint8) {
-	fmt.Println("Cartridge spawned, waiting for operations...")
+// until ctx is done. Reads are sent the value on the data bus, which is left
+// there when nothing on the cartridge responds to the address. Writes are
+// acknowledged by sending the value back once it has been written. Writes
+// the mapper refuses, such as to ROM, are ignored as the hardware ignores
+// them; only a panic in the mapper is sent to faultBus.
+func (cartridge *Cartridge) WaitForReadWrite(ctx context.Context, cartridgeControlBus chan uint16, readWriteBus chan int, dataBus chan uint8, faultBus chan error) {
 	for {
-		addr := <-cartridgeControlBus
+		var addr uint16
+		select {
+		case <-ctx.Done():
+			return
+		case addr = <-cartridgeControlBus:
+		}
+
 		if <-readWriteBus == 0 { // Read op
-			val, _ := cartridge.Read(addr)
+			val := <-dataBus
+			report(faultBus, func() {
+				if value, err := cartridge.Read(addr); err == nil {
+					val = value
+				}
+			})
 			dataBus <- val
 		} else { // Write op
-			cartridge.Write(addr, <-dataBus)
+			val := <-dataBus
+			report(faultBus, func() {
+				cartridge.Write(addr, val)
+			})
+			dataBus <- val
 		}
 	}
 }
 
+// report runs a bus access, sending any panic it raises to faultBus unless
+// an error is already waiting there
+func report(faultBus chan error, access func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			select {
+			case faultBus <- fmt.Errorf("Panic: %v\n%s", r, debug.Stack()):
+			default:
+			}
+		}
+	}()
+	access()
+}
+
 // SetPrgRomSize sets the program ROM size of the cartridge, taking in to
 // account the block size.
 func (cartridge *Cartridge) SetPrgRomSize(size int) {
@@ -81,19 +266,145 @@ func (cartridge *Cartridge) SetChrRomSize(size int) {
 
 // Read returns a byte located at the passed in address.
 func (cartridge *Cartridge) Read(address uint16) (byte, error) {
-	if address < 0x6000 {
-		return byte(0), fmt.Errorf("ROM address out of range: %x", address)
-	}
-
 	if address >= 0x8000 {
 		value, err := cartridge.Mapper.Read(address)
 		return value, err
 	}
 
+	if low, ok := cartridge.Mapper.(mapper.LowMapper); ok {
+		return low.ReadLow(address)
+	}
+
+	if address < 0x6000 {
+		return byte(0), fmt.Errorf("ROM address out of range: %x", address)
+	}
+
 	return cartridge.RAM[address-0x6000], nil
 }
 
 // Write puts a value into RAM at the address specified, or sends it to the mapper.
 func (cartridge *Cartridge) Write(address uint16, value uint8) error {
-	return cartridge.Mapper.Write(address, value)
+	if address >= 0x8000 {
+		return cartridge.Mapper.Write(address, value)
+	}
+
+	if low, ok := cartridge.Mapper.(mapper.LowMapper); ok {
+		return low.WriteLow(address, value)
+	}
+
+	if address < 0x6000 {
+		return fmt.Errorf("ROM address out of range: %x", address)
+	}
+
+	cartridge.RAM[address-0x6000] = value
+	return nil
+}
+
+// ReadPPU returns a byte from the pattern tables or nametables as seen by the
+// PPU at the passed in address.
+func (cartridge *Cartridge) ReadPPU(address uint16) (byte, error) {
+	if ppuMapper, ok := cartridge.Mapper.(mapper.PPUMapper); ok {
+		return ppuMapper.ReadPPU(address)
+	}
+
+	address &= 0x3fff
+	if address < 0x2000 {
+		if int(address) >= len(cartridge.CHR) {
+			return 0, fmt.Errorf("CHR address out of range: %x", address)
+		}
+		return cartridge.CHR[address], nil
+	}
+	return cartridge.VRAM[cartridge.nametableOffset(address)], nil
+}
+
+// WritePPU writes a byte to CHR RAM or the nametables as seen by the PPU.
+func (cartridge *Cartridge) WritePPU(address uint16, value uint8) error {
+	if ppuMapper, ok := cartridge.Mapper.(mapper.PPUMapper); ok {
+		return ppuMapper.WritePPU(address, value)
+	}
+
+	address &= 0x3fff
+	if address < 0x2000 {
+		if int(address) < cartridge.ChrRomSize || int(address) >= len(cartridge.CHR) {
+			return fmt.Errorf("Attempt to write to CHR ROM at %x", address)
+		}
+		cartridge.CHR[address] = value
+		return nil
+	}
+	cartridge.VRAM[cartridge.nametableOffset(address)] = value
+	return nil
+}
+
+// nametableOffset maps one of the four logical nametables onto VRAM
+// according to the mirroring wired on the board.
+func (cartridge *Cartridge) nametableOffset(address uint16) int {
+	nametable := int(address>>10) & 0x03
+	offset := int(address & 0x3ff)
+
+	switch {
+	case cartridge.FourScreen:
+		return nametable*0x400 + offset
+	case cartridge.Mirroring == MirrorHorizontal:
+		return (nametable>>1)*0x400 + offset
+	default:
+		return (nametable&0x01)*0x400 + offset
+	}
+}
+
+// Save writes the mapper's persistent state, if it has any, to SavePath.
+func (cartridge *Cartridge) Save() error {
+	saver, ok := cartridge.Mapper.(mapper.SaveMapper)
+	if !ok || cartridge.SavePath == "" {
+		return nil
+	}
+
+	file, err := os.Create(cartridge.SavePath)
+	if err != nil {
+		return err
+	}
+	if err = saver.WriteSave(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadSave restores the mapper's persistent state from SavePath, if a save
+// exists. ParseROM does this itself.
+func (cartridge *Cartridge) LoadSave() error {
+	saver, ok := cartridge.Mapper.(mapper.SaveMapper)
+	if !ok || cartridge.SavePath == "" {
+		return nil
+	}
+
+	file, err := os.Open(cartridge.SavePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return saver.LoadSave(file)
+}
+
+// InsertDisk inserts a side of the disk image into the drive, for games that
+// span several sides.
+func (cartridge *Cartridge) InsertDisk(side int) error {
+	drive, ok := cartridge.Mapper.(mapper.DiskMapper)
+	if !ok {
+		return errors.New("Cartridge has no disk drive")
+	}
+	return drive.InsertDisk(side)
+}
+
+// EjectDisk removes the disk from the drive.
+func (cartridge *Cartridge) EjectDisk() error {
+	drive, ok := cartridge.Mapper.(mapper.DiskMapper)
+	if !ok {
+		return errors.New("Cartridge has no disk drive")
+	}
+	drive.EjectDisk()
+	return nil
 }
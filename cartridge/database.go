@@ -0,0 +1,141 @@
+package cartridge
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	"github.com/makononov/NESGo/cartridge/romdb"
+)
+
+// DatabasePath is the ROM database to identify dumps and correct their
+// headers with, in the form romdb.Parse reads. When empty, nesgo/romdb.tsv
+// in the user's config directory is used if it exists; without either, ROMs
+// load as their headers say.
+var DatabasePath string
+
+// hashROM sets the cartridge's hashes from the ROM data, in order
+func hashROM(cart *Cartridge, parts ...[]byte) {
+	crc := crc32.NewIEEE()
+	sha := sha1.New()
+	md := md5.New()
+	for _, part := range parts {
+		crc.Write(part)
+		sha.Write(part)
+		md.Write(part)
+	}
+	cart.CRC32 = crc.Sum32()
+	copy(cart.SHA1[:], sha.Sum(nil))
+	copy(cart.MD5[:], md.Sum(nil))
+}
+
+// identify hashes the PRG and CHR ROM and looks the dump up in the ROM
+// database, correcting any header fields that it gets wrong.
+func identify(cart *Cartridge, prg []byte) error {
+	hashROM(cart, prg, cart.CHR)
+
+	entry, err := lookup(cart)
+	if entry == nil {
+		return err
+	}
+	cart.DatabaseEntry = entry
+
+	if cart.MapperID != entry.MapperID || cart.Submapper != entry.Submapper {
+		cart.correct("mapper", fmt.Sprintf("%d.%d", cart.MapperID, cart.Submapper), fmt.Sprintf("%d.%d", entry.MapperID, entry.Submapper))
+		cart.MapperID = entry.MapperID
+		cart.Submapper = entry.Submapper
+	}
+
+	mirroring, fourScreen := cart.Mirroring, cart.FourScreen
+	switch entry.Mirroring {
+	case 'H':
+		mirroring, fourScreen = MirrorHorizontal, false
+	case 'V':
+		mirroring, fourScreen = MirrorVertical, false
+	case '4':
+		fourScreen = true
+	}
+	if mirroring != cart.Mirroring || fourScreen != cart.FourScreen {
+		cart.correct("mirroring", mirroringName(cart.Mirroring, cart.FourScreen), mirroringName(mirroring, fourScreen))
+		cart.Mirroring, cart.FourScreen = mirroring, fourScreen
+	}
+
+	if cart.BatteryBackedSRAM != entry.Battery {
+		cart.correct("battery", fmt.Sprint(cart.BatteryBackedSRAM), fmt.Sprint(entry.Battery))
+		cart.BatteryBackedSRAM = entry.Battery
+	}
+
+	cart.PrgRamSize = entry.PrgRAM
+	cart.PrgNvramSize = entry.PrgNVRAM
+	cart.ChrRamSize = entry.ChrRAM
+	cart.ChrNvramSize = entry.ChrNVRAM
+
+	if format, ok := timingFormat(entry.Timing); ok {
+		cart.TVSystemFormat = format
+	}
+	return nil
+}
+
+// timingFormat converts the ROM database's timing to a TV system format
+func timingFormat(timing string) (int, bool) {
+	format, ok := map[string]int{"NTSC": NTSC, "PAL": PAL, "Multi": MultiRegion, "Dendy": Dendy}[timing]
+	return format, ok
+}
+
+// lookup finds the cartridge's dump in the ROM database by its hashes,
+// returning nil if it isn't there
+func lookup(cart *Cartridge) (*romdb.Entry, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+	entry, _ := db.Lookup(cart.CRC32, cart.SHA1)
+	return entry, nil
+}
+
+// correct notes a header field the database has corrected
+func (cartridge *Cartridge) correct(field string, header string, database string) {
+	cartridge.Warnings = append(cartridge.Warnings,
+		fmt.Sprintf("Header has %s %s, corrected to %s from the ROM database", field, header, database))
+}
+
+// mirroringName describes the mirroring wired on a board
+func mirroringName(mirroring int, fourScreen bool) string {
+	switch {
+	case fourScreen:
+		return "four-screen"
+	case mirroring == MirrorVertical:
+		return "vertical"
+	}
+	return "horizontal"
+}
+
+// database returns the ROM database, which is empty if there is none
+func database() (*romdb.Database, error) {
+	path := DatabasePath
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return romdb.New(), nil
+		}
+		path = filepath.Join(dir, "nesgo", "romdb.tsv")
+		if _, err = os.Stat(path); os.IsNotExist(err) {
+			return romdb.New(), nil
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	db, err := romdb.Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
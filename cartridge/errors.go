@@ -0,0 +1,66 @@
+package cartridge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrUnknownFormat is returned for data that isn't a ROM or disk image
+	// in any format NESGo can load.
+	ErrUnknownFormat = errors.New("Unrecognized ROM image format")
+
+	// ErrTruncated is returned for an image shorter than its header says.
+	ErrTruncated = errors.New("ROM image is truncated")
+
+	// ErrInvalidHeader is returned for an iNES header with reserved bits set,
+	// or a NES 2.0 header giving an impossible ROM size.
+	ErrInvalidHeader = errors.New("Invalid iNES header")
+
+	// ErrPlaychoice10 is returned for PlayChoice-10 arcade ROMs.
+	ErrPlaychoice10 = errors.New("PlayChoice-10 ROMs are not supported")
+
+	// ErrVsUnisystem is returned for Vs. System arcade ROMs.
+	ErrVsUnisystem = errors.New("Vs. System ROMs are not supported")
+
+	// ErrInvalidDiskSize is returned for a disk image that isn't a whole
+	// number of disk sides.
+	ErrInvalidDiskSize = errors.New("Invalid disk image size")
+
+	// ErrFDSBIOSNotFound is returned when loading a disk image without the
+	// Famicom Disk System BIOS. See FDSBIOSPath.
+	ErrFDSBIOSNotFound = errors.New("FDS BIOS not found, disksys.rom is required to run disk images")
+
+	// ErrNoROMInArchive is returned for an archive with no .nes, .fds or
+	// .unf file in it.
+	ErrNoROMInArchive = errors.New("Archive contains no ROM image")
+
+	// ErrImageTooLarge is returned for an archive whose ROM image
+	// decompresses to more than any ROM image could be.
+	ErrImageTooLarge = errors.New("ROM image in archive is too large")
+
+	// ErrUnsupportedArchive is returned for 7-Zip archives, which the
+	// standard library has no decompressor for.
+	ErrUnsupportedArchive = errors.New("7-Zip archives are not supported, extract or rezip the ROM image")
+)
+
+// A MultipleROMsError is returned for an archive holding more than one ROM
+// image, since there's no telling which one to load.
+type MultipleROMsError struct {
+	Names []string
+}
+
+func (e *MultipleROMsError) Error() string {
+	return "Archive contains more than one ROM image: " + strings.Join(e.Names, ", ")
+}
+
+// An UnknownBoardError is returned for a UNIF image whose board has no known
+// mapper number, or translates to a mapper that isn't implemented.
+type UnknownBoardError struct {
+	Board string
+}
+
+func (e *UnknownBoardError) Error() string {
+	return fmt.Sprintf("Unknown UNIF board %q", e.Board)
+}
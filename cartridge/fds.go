@@ -0,0 +1,109 @@
+package cartridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/makononov/NESGo/cartridge/mappers"
+)
+
+// FDSMapperID is the mapper number conventionally used for the Famicom Disk
+// System, which has no iNES header of its own.
+const FDSMapperID = 20
+
+const qdSideSize = 65536
+
+// FDSBIOSPath is the Famicom Disk System BIOS to load with disk images. When
+// empty, disksys.rom is looked for next to the image and then in the working
+// directory, or only in the working directory for images parsed from memory.
+var FDSBIOSPath string
+
+// isFDS reports whether data is a disk image, either with a fwNES header or
+// starting directly with a disk info block.
+func isFDS(data []byte) bool {
+	if len(data) >= 4 && string(data[0:4]) == "FDS\u001a" {
+		return true
+	}
+	return len(data) >= 15 && data[0] == 1 && string(data[1:15]) == "*NINTENDO-HVC*"
+}
+
+// parseFDS sets up a cartridge for a disk image
+func parseFDS(cart *Cartridge, filename string, data []byte) error {
+	sides, err := readFDS(cart, data)
+	if err != nil {
+		return err
+	}
+
+	bios, err := readFDSBIOS(filename)
+	if err != nil {
+		return err
+	}
+
+	cart.MapperID = FDSMapperID
+	cart.Mirroring = MirrorHorizontal
+	cart.VRAM = make([]byte, 2048)
+	cart.newMapper = func() (mapper.Mapper, error) {
+		m := &mapper.FDS{Disk: sides}
+		return m, m.Init(bios)
+	}
+	cart.Mapper, err = cart.createMapper()
+	return err
+}
+
+// readFDS splits a disk image into its sides and hashes it. The image may be
+// in .fds format, with or without its 16-byte fwNES header, or in the QD
+// format that keeps each block's CRC and pads sides to 64KB.
+func readFDS(cart *Cartridge, data []byte) ([][]byte, error) {
+	if len(data) >= 4 && string(data[0:4]) == "FDS\u001a" {
+		if len(data) < 16 {
+			return nil, ErrTruncated
+		}
+		data = data[16:]
+	}
+	hashROM(cart, data)
+
+	var sides [][]byte
+	switch {
+	case len(data) > 0 && len(data)%mapper.FDSSideSize == 0:
+		for position := 0; position < len(data); position += mapper.FDSSideSize {
+			sides = append(sides, data[position:position+mapper.FDSSideSize])
+		}
+	case len(data) > 0 && len(data)%qdSideSize == 0:
+		for position := 0; position < len(data); position += qdSideSize {
+			sides = append(sides, mapper.QDSide(data[position:position+qdSideSize]))
+		}
+	default:
+		return nil, ErrInvalidDiskSize
+	}
+	return sides, nil
+}
+
+// readFDSBIOS loads the 8KB disksys.rom BIOS, which isn't distributed with
+// disk images and has to be supplied by the user.
+func readFDSBIOS(filename string) ([]byte, error) {
+	candidates := []string{FDSBIOSPath}
+	if FDSBIOSPath == "" {
+		candidates = []string{"disksys.rom"}
+		if filename != "" {
+			candidates = append([]string{filepath.Join(filepath.Dir(filename), "disksys.rom")}, candidates...)
+		}
+	}
+
+	for _, path := range candidates {
+		bios, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bios) != 8192 {
+			return nil, fmt.Errorf("%s is not an 8KB FDS BIOS", path)
+		}
+		return bios, nil
+	}
+
+	return nil, ErrFDSBIOSNotFound
+}
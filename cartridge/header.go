@@ -0,0 +1,370 @@
+package cartridge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/makononov/NESGo/cartridge/romdb"
+)
+
+// HeaderSize is the length of an iNES or NES 2.0 header
+const HeaderSize = 16
+
+// TrainerSize is the length of the trainer that may follow the header
+const TrainerSize = 512
+
+const inesMagic = "NES\u001a"
+
+// maxROMSize is the largest PRG or CHR ROM a header may give, far past any
+// real board, so that a corrupt NES 2.0 size can't overflow or make loading
+// allocate gigabytes
+const maxROMSize = 64 << 20
+
+// Console types, from the low bits of header byte 7
+const (
+	// ConsoleNES is a regular NES or Famicom
+	ConsoleNES = iota
+
+	// ConsoleVsSystem is the Vs. System arcade board
+	ConsoleVsSystem = iota
+
+	// ConsolePlaychoice10 is the PlayChoice-10 arcade board
+	ConsolePlaychoice10 = iota
+
+	// ConsoleExtended means the NES 2.0 extended console type says what it
+	// is, such as a famiclone with a different CPU
+	ConsoleExtended = iota
+)
+
+// A Header is a decoded iNES or NES 2.0 header. Sizes are in bytes.
+type Header struct {
+	NES2 bool
+
+	MapperID  int
+	Submapper int
+
+	PrgRomSize int
+	ChrRomSize int
+
+	// RAM sizes are only given by NES 2.0 headers, apart from the PRG RAM
+	// some iNES headers give in 8KB units in byte 8. NVRAM is battery-backed.
+	PrgRamSize   int
+	PrgNvramSize int
+	ChrRamSize   int
+	ChrNvramSize int
+
+	Mirroring  int
+	FourScreen bool
+	Trainer    bool
+	Battery    bool
+
+	ConsoleType    int
+	TVSystemFormat int
+
+	// NES 2.0 byte 13 holds the Vs. System PPU and hardware types, or the
+	// extended console type
+	VsPPU           int
+	VsHardware      int
+	ExtendedConsole int
+
+	// MiscROMs is how many extra ROMs, such as the PlayChoice-10 INST-ROM,
+	// follow the CHR ROM in NES 2.0 images
+	MiscROMs int
+
+	// ExpansionDevice is the NES 2.0 default expansion device, such as a
+	// Zapper or Four Score
+	ExpansionDevice int
+
+	// Junk is what was found in bytes 7-15 of an iNES 1.0 header written by
+	// a tool that left its name or other garbage there. Those bytes were
+	// ignored when decoding.
+	Junk []byte
+}
+
+// ParseHeader decodes the header at the start of an iNES or NES 2.0 image
+func ParseHeader(data []byte) (*Header, error) {
+	if len(data) < HeaderSize || string(data[0:4]) != inesMagic {
+		return nil, ErrUnknownFormat
+	}
+
+	header := &Header{
+		PrgRomSize: int(data[4]) * prgRomBlockSize,
+		ChrRomSize: int(data[5]) * chrRomBlockSize,
+		MapperID:   int(data[6] >> 4),
+		FourScreen: data[6]&0x08 != 0,
+		Trainer:    data[6]&0x04 != 0,
+		Battery:    data[6]&0x02 != 0,
+		Mirroring:  MirrorHorizontal,
+	}
+	if data[6]&0x01 != 0 {
+		header.Mirroring = MirrorVertical
+	}
+
+	switch {
+	case data[7]&0x0c == 0x08:
+		header.NES2 = true
+		header.parseFlags7(data[7])
+		if err := header.parseNES2(data); err != nil {
+			return nil, err
+		}
+	case !bytes.Equal(data[12:16], make([]byte, 4)):
+		// Headers from old dumping tools such as DiskDude! have garbage from
+		// byte 7 on, so none of it can be trusted
+		header.Junk = append([]byte(nil), data[7:16]...)
+	default:
+		header.parseFlags7(data[7])
+		header.PrgRamSize = int(data[8]) * 8192
+		if data[9]&0xfe != 0 {
+			return nil, ErrInvalidHeader
+		}
+		if data[9]&0x01 != 0 {
+			header.TVSystemFormat = PAL
+		}
+	}
+
+	return header, nil
+}
+
+// parseFlags7 reads the console type and upper mapper bits from byte 7
+func (h *Header) parseFlags7(flags byte) {
+	h.ConsoleType = int(flags & 0x03)
+	h.MapperID |= int(flags & 0xf0)
+}
+
+// parseNES2 reads the NES 2.0 extensions from header bytes 8-15: the upper
+// mapper bits, the submapper, the upper ROM size bits, the RAM sizes, the
+// CPU/PPU timing and the console and expansion device details. ROM sizes
+// past maxROMSize are ErrInvalidHeader.
+func (h *Header) parseNES2(data []byte) error {
+	h.MapperID |= int(data[8]&0x0f) << 8
+	h.Submapper = int(data[8] >> 4)
+
+	var ok bool
+	if h.PrgRomSize, ok = nes2RomSize(data[4], data[9]&0x0f, prgRomBlockSize); !ok {
+		return ErrInvalidHeader
+	}
+	if h.ChrRomSize, ok = nes2RomSize(data[5], data[9]>>4, chrRomBlockSize); !ok {
+		return ErrInvalidHeader
+	}
+
+	h.PrgRamSize = nes2RAMSize(data[10] & 0x0f)
+	h.PrgNvramSize = nes2RAMSize(data[10] >> 4)
+	h.ChrRamSize = nes2RAMSize(data[11] & 0x0f)
+	h.ChrNvramSize = nes2RAMSize(data[11] >> 4)
+
+	h.TVSystemFormat = []int{NTSC, PAL, MultiRegion, Dendy}[data[12]&0x03]
+
+	switch h.ConsoleType {
+	case ConsoleVsSystem:
+		h.VsPPU = int(data[13] & 0x0f)
+		h.VsHardware = int(data[13] >> 4)
+	case ConsoleExtended:
+		h.ExtendedConsole = int(data[13] & 0x0f)
+	}
+
+	h.MiscROMs = int(data[14] & 0x03)
+	h.ExpansionDevice = int(data[15] & 0x3f)
+	return nil
+}
+
+// ImageSize is the size of the image the header describes: the header,
+// trainer, PRG and CHR ROM. NES 2.0 images may have miscellaneous ROMs
+// after that.
+func (h *Header) ImageSize() int {
+	size := HeaderSize + h.PrgRomSize + h.ChrRomSize
+	if h.Trainer {
+		size += TrainerSize
+	}
+	return size
+}
+
+// Encode returns the header in NES 2.0 form. Junk is dropped.
+func (h *Header) Encode() ([]byte, error) {
+	switch {
+	case h.MapperID < 0 || h.MapperID > 0xfff:
+		return nil, fmt.Errorf("Mapper %d doesn't fit in a NES 2.0 header", h.MapperID)
+	case h.Submapper < 0 || h.Submapper > 0x0f:
+		return nil, fmt.Errorf("Submapper %d doesn't fit in a NES 2.0 header", h.Submapper)
+	}
+
+	data := make([]byte, HeaderSize)
+	copy(data, inesMagic)
+
+	var err error
+	var prgHigh, chrHigh byte
+	if data[4], prgHigh, err = encodeNES2RomSize(h.PrgRomSize, prgRomBlockSize); err != nil {
+		return nil, fmt.Errorf("PRG ROM: %w", err)
+	}
+	if data[5], chrHigh, err = encodeNES2RomSize(h.ChrRomSize, chrRomBlockSize); err != nil {
+		return nil, fmt.Errorf("CHR ROM: %w", err)
+	}
+	data[9] = chrHigh<<4 | prgHigh
+
+	data[6] = byte(h.MapperID&0x0f) << 4
+	if h.FourScreen {
+		data[6] |= 0x08
+	}
+	if h.Trainer {
+		data[6] |= 0x04
+	}
+	if h.Battery {
+		data[6] |= 0x02
+	}
+	if h.Mirroring == MirrorVertical {
+		data[6] |= 0x01
+	}
+	data[7] = byte(h.MapperID&0xf0) | 0x08 | byte(h.ConsoleType&0x03)
+	data[8] = byte(h.Submapper)<<4 | byte(h.MapperID>>8)
+
+	ram := []int{h.PrgRamSize, h.PrgNvramSize, h.ChrRamSize, h.ChrNvramSize}
+	var shifts [4]byte
+	for i, size := range ram {
+		if shifts[i], err = encodeNES2RAMSize(size); err != nil {
+			return nil, err
+		}
+	}
+	data[10] = shifts[1]<<4 | shifts[0]
+	data[11] = shifts[3]<<4 | shifts[2]
+
+	switch h.TVSystemFormat {
+	case PAL:
+		data[12] = 1
+	case MultiRegion:
+		data[12] = 2
+	case Dendy:
+		data[12] = 3
+	}
+	switch h.ConsoleType {
+	case ConsoleVsSystem:
+		data[13] = byte(h.VsHardware&0x0f)<<4 | byte(h.VsPPU&0x0f)
+	case ConsoleExtended:
+		data[13] = byte(h.ExtendedConsole & 0x0f)
+	}
+	data[14] = byte(h.MiscROMs & 0x03)
+	data[15] = byte(h.ExpansionDevice & 0x3f)
+	return data, nil
+}
+
+// Correct sets the header fields the ROM database knows for a dump
+func (h *Header) Correct(entry *romdb.Entry) {
+	h.MapperID = entry.MapperID
+	h.Submapper = entry.Submapper
+	switch entry.Mirroring {
+	case 'H':
+		h.Mirroring, h.FourScreen = MirrorHorizontal, false
+	case 'V':
+		h.Mirroring, h.FourScreen = MirrorVertical, false
+	case '4':
+		h.FourScreen = true
+	}
+	h.Battery = entry.Battery
+	h.PrgRamSize = entry.PrgRAM
+	h.PrgNvramSize = entry.PrgNVRAM
+	h.ChrRamSize = entry.ChrRAM
+	h.ChrNvramSize = entry.ChrNVRAM
+	if format, ok := timingFormat(entry.Timing); ok {
+		h.TVSystemFormat = format
+	}
+}
+
+// RewriteHeader returns a ROM image, which may be compressed, with its
+// header replaced by header in NES 2.0 form. Anything after the ROM data and
+// any miscellaneous ROMs is dropped.
+func RewriteHeader(data []byte, header *Header) ([]byte, error) {
+	data, err := unpack(data)
+	if err != nil {
+		return nil, err
+	}
+	old, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := header.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	// The trainer and ROM sizes come from the image, so they can't change
+	end := old.ImageSize()
+	if header.ImageSize() != end {
+		return nil, errors.New("Header doesn't describe the same trainer and ROM sizes as the image")
+	}
+	if len(data) < end {
+		return nil, ErrTruncated
+	}
+	if header.MiscROMs > 0 {
+		end = len(data)
+	}
+
+	out := append(encoded, data[HeaderSize:end]...)
+	return out, nil
+}
+
+// encodeNES2RomSize encodes a ROM size as a block count, or when that won't
+// do, as an exponent and multiplier
+func encodeNES2RomSize(size int, blockSize int) (low byte, high byte, err error) {
+	if size%blockSize == 0 && size/blockSize < 0xf00 {
+		blocks := size / blockSize
+		return byte(blocks), byte(blocks >> 8), nil
+	}
+
+	for multiplier := 1; multiplier <= 7; multiplier += 2 {
+		if size%multiplier != 0 || !isPowerOfTwo(size/multiplier) {
+			continue
+		}
+		exponent := 0
+		for 1<<uint(exponent) < size/multiplier {
+			exponent++
+		}
+		if exponent < 64 {
+			return byte(exponent<<2 | (multiplier-1)/2), 0x0f, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("size %d can't be given in a NES 2.0 header", size)
+}
+
+// encodeNES2RAMSize encodes a RAM size as a shift count of 64 bytes
+func encodeNES2RAMSize(size int) (byte, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	for shift := byte(1); shift <= 0x0f; shift++ {
+		if 64<<shift == size {
+			return shift, nil
+		}
+	}
+	return 0, fmt.Errorf("RAM size %d can't be given in a NES 2.0 header", size)
+}
+
+// nes2RomSize decodes a NES 2.0 ROM size. An upper nibble of $F means the
+// lower byte holds an exponent and multiplier instead of a block count. It
+// reports false for sizes past maxROMSize.
+func nes2RomSize(low byte, high byte, blockSize int) (int, bool) {
+	if high != 0x0f {
+		size := (int(high)<<8 | int(low)) * blockSize
+		return size, size <= maxROMSize
+	}
+
+	exponent := uint(low >> 2)
+	if uint64(1)<<exponent > maxROMSize {
+		return 0, false
+	}
+	size := (1 << exponent) * (int(low&0x03)*2 + 1)
+	return size, size <= maxROMSize
+}
+
+// nes2RAMSize decodes a NES 2.0 RAM size, which is a shift count of 64 bytes
+// or zero for none.
+func nes2RAMSize(shift byte) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
+}
+
+// junkWarning describes the garbage found in an iNES 1.0 header
+func (h *Header) junkWarning() string {
+	return fmt.Sprintf("Header contains junk in bytes 7-15: %q, ignored", string(h.Junk))
+}
@@ -0,0 +1,46 @@
+package cartridge
+
+import "testing"
+
+// nes2Header returns a NES 2.0 header for mapper 0 with the given size bytes
+func nes2Header(prg, chr, sizeHigh byte) []byte {
+	return []byte{'N', 'E', 'S', 0x1a, prg, chr, 0x00, 0x08, 0x00, sizeHigh, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+}
+
+func TestParseHeaderRejectsHugeNES2Sizes(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"PRG exponent 63", nes2Header(0xfc, 0x00, 0x0f)},
+		{"PRG exponent 62", nes2Header(0xf9, 0x00, 0x0f)},
+		{"CHR exponent 63", nes2Header(0x01, 0xfc, 0xf0)},
+		{"PRG exponent 27", nes2Header(0x6c, 0x00, 0x0f)},
+	}
+	for _, test := range tests {
+		if _, err := ParseHeader(test.data); err != ErrInvalidHeader {
+			t.Errorf("%s: ParseHeader returned %v, want ErrInvalidHeader", test.name, err)
+		}
+		if _, err := ParseBytes(test.data); err != ErrInvalidHeader {
+			t.Errorf("%s: ParseBytes returned %v, want ErrInvalidHeader", test.name, err)
+		}
+	}
+}
+
+func TestParseHeaderNES2ExponentSize(t *testing.T) {
+	// 2^14 * 3 bytes of PRG ROM
+	header, err := ParseHeader(nes2Header(0x39, 0x00, 0x0f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.PrgRomSize != 3<<14 {
+		t.Errorf("PrgRomSize is %d, want %d", header.PrgRomSize, 3<<14)
+	}
+}
+
+func TestParseBytesTruncated(t *testing.T) {
+	data := append(nes2Header(0x02, 0x01, 0x00), make([]byte, 0x8000)...)
+	if _, err := ParseBytes(data); err != ErrTruncated {
+		t.Errorf("ParseBytes returned %v, want ErrTruncated", err)
+	}
+}
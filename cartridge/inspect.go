@@ -0,0 +1,216 @@
+package cartridge
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/makononov/NESGo/cartridge/romdb"
+)
+
+// A Report describes a ROM image as it was found, including what is wrong
+// with it, for checking dumps without running them. Unlike loading, it
+// doesn't need the mapper to be implemented.
+type Report struct {
+	// Format is "iNES", "NES 2.0", "UNIF" or "FDS"
+	Format string
+
+	// Header is the decoded header of iNES and NES 2.0 images
+	Header *Header
+
+	// Board is the board name of UNIF images
+	Board string
+
+	// DiskSides is the number of sides in disk images
+	DiskSides int
+
+	CRC32         uint32
+	SHA1          [20]byte
+	DatabaseEntry *romdb.Entry
+
+	// FileSize is the size of the image, after decompression, and
+	// ExpectedSize is what its header says it should be
+	FileSize     int
+	ExpectedSize int
+
+	// Lint lists problems found with the image
+	Lint []string
+}
+
+// mapperMirroring are the mappers that control mirroring themselves, for
+// which the header's mirroring and four-screen bits mean nothing
+var mapperMirroring = map[int]bool{
+	1: true, 5: true, 7: true, 9: true, 10: true, 16: true, 18: true, 19: true,
+	21: true, 22: true, 23: true, 24: true, 25: true, 26: true, 28: true,
+	32: true, 33: true, 48: true, 65: true, 68: true, 69: true, 80: true,
+	85: true, 118: true, 153: true, 154: true, 159: true,
+}
+
+// fixedMirroring are the mappers whose boards are all wired for the same
+// mirroring, which the header should give
+var fixedMirroring = map[int]string{
+	111: "four-screen",
+}
+
+// Inspect examines a ROM image, which may be compressed
+func Inspect(data []byte) (*Report, error) {
+	data, err := unpack(data)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{FileSize: len(data)}
+	cart := new(Cartridge)
+	switch {
+	case isFDS(data):
+		report.Format = "FDS"
+		report.ExpectedSize = len(data)
+		sides, err := readFDS(cart, data)
+		if err != nil {
+			return nil, err
+		}
+		report.DiskSides = len(sides)
+	case isUNIF(data):
+		report.Format = "UNIF"
+		report.ExpectedSize = len(data)
+		prg, err := readUNIF(cart, data)
+		if err != nil {
+			return nil, err
+		}
+		hashROM(cart, prg, cart.CHR)
+		report.Board = cart.Board
+		if _, ok := lookupUNIFBoard(cart.Board); !ok {
+			report.lint("UNIF board %q has no known mapper number", cart.Board)
+		}
+	default:
+		header, err := ParseHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		report.Format = "iNES"
+		if header.NES2 {
+			report.Format = "NES 2.0"
+		}
+		report.Header = header
+		report.ExpectedSize = header.ImageSize()
+
+		start := HeaderSize
+		if header.Trainer {
+			start += TrainerSize
+		}
+		end := start + header.PrgRomSize + header.ChrRomSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if start < end {
+			hashROM(cart, data[start:end])
+		}
+		report.lintHeader(data)
+	}
+
+	report.CRC32, report.SHA1 = cart.CRC32, cart.SHA1
+	if report.DatabaseEntry, err = lookup(cart); err != nil {
+		return nil, err
+	}
+	report.lintDatabase()
+	report.lintMirroring()
+	return report, nil
+}
+
+func (r *Report) lint(format string, args ...interface{}) {
+	r.Lint = append(r.Lint, fmt.Sprintf(format, args...))
+}
+
+// lintHeader checks an iNES or NES 2.0 header against itself and the image
+func (r *Report) lintHeader(data []byte) {
+	h := r.Header
+
+	switch {
+	case bytes.Contains(h.Junk, []byte("DiskDude!")):
+		r.lint("Bytes 7-15 contain \"DiskDude!\" from an old dumping tool; the upper mapper bits are lost")
+	case h.Junk != nil:
+		r.lint("Bytes 12-15 are not zero, so bytes 7-15 can't be trusted: %q", string(h.Junk))
+	case !h.NES2 && (data[10] != 0 || data[11] != 0):
+		r.lint("Unused bytes 10-11 are not zero")
+	}
+
+	switch {
+	case h.PrgRomSize == 0:
+		r.lint("Header declares no PRG ROM")
+	case !isPowerOfTwo(h.PrgRomSize):
+		r.lint("PRG ROM size %d is not a power of two", h.PrgRomSize)
+	}
+	if h.ChrRomSize > 0 && !isPowerOfTwo(h.ChrRomSize) {
+		r.lint("CHR ROM size %d is not a power of two", h.ChrRomSize)
+	}
+	if h.NES2 && h.ChrRomSize == 0 && h.ChrRamSize == 0 && h.ChrNvramSize == 0 {
+		r.lint("Header declares neither CHR ROM nor CHR RAM")
+	}
+	if h.NES2 && h.Battery && h.PrgNvramSize == 0 && h.ChrNvramSize == 0 {
+		r.lint("Battery bit is set but no NVRAM size is given")
+	}
+
+	switch {
+	case r.FileSize < r.ExpectedSize:
+		r.lint("File is %d bytes shorter than the header declares", r.ExpectedSize-r.FileSize)
+	case r.FileSize > r.ExpectedSize && !(h.NES2 && h.MiscROMs > 0):
+		r.lint("File has %d bytes past the end of the ROM", r.FileSize-r.ExpectedSize)
+	}
+
+	switch h.ConsoleType {
+	case ConsoleVsSystem:
+		r.lint("Vs. System ROMs are not supported")
+	case ConsolePlaychoice10:
+		r.lint("PlayChoice-10 ROMs are not supported")
+	}
+}
+
+// lintDatabase checks the header against the database entry for the dump
+func (r *Report) lintDatabase() {
+	e, h := r.DatabaseEntry, r.Header
+	if e == nil || h == nil {
+		return
+	}
+
+	if h.MapperID != e.MapperID || h.Submapper != e.Submapper {
+		r.lint("Header has mapper %d.%d, the ROM database has %d.%d", h.MapperID, h.Submapper, e.MapperID, e.Submapper)
+	}
+
+	if h.Battery != e.Battery {
+		r.lint("Header has battery %t, the ROM database has %t", h.Battery, e.Battery)
+	}
+}
+
+// lintMirroring checks the header's mirroring against the board: the
+// database entry for the dump, the wiring of boards that all have the same,
+// or bits that mean nothing on boards whose mapper controls mirroring
+func (r *Report) lintMirroring() {
+	h := r.Header
+	if h == nil {
+		return
+	}
+	header := mirroringName(h.Mirroring, h.FourScreen)
+
+	if e := r.DatabaseEntry; e != nil {
+		known := map[byte]string{'H': "horizontal", 'V': "vertical", '4': "four-screen"}[e.Mirroring]
+		if known != "" && known != header {
+			r.lint("Header has %s mirroring, the ROM database has %s", header, known)
+		}
+		if e.Mirroring == 0 && h.FourScreen {
+			r.lint("Four-screen bit is set, but the ROM database has the mapper controlling mirroring")
+		}
+		return
+	}
+
+	switch {
+	case fixedMirroring[h.MapperID] != "" && fixedMirroring[h.MapperID] != header:
+		r.lint("Header has %s mirroring, but mapper %d boards are wired for %s", header, h.MapperID, fixedMirroring[h.MapperID])
+	case mapperMirroring[h.MapperID] && h.FourScreen:
+		r.lint("Four-screen bit is set, but mapper %d controls mirroring itself", h.MapperID)
+	case mapperMirroring[h.MapperID] && h.Mirroring == MirrorVertical:
+		r.lint("Vertical mirroring bit is set, but mapper %d controls mirroring itself, so it is ignored", h.MapperID)
+	}
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
@@ -0,0 +1,31 @@
+package cartridge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInspectMirroringLint(t *testing.T) {
+	tests := []struct {
+		name      string
+		mapper    byte
+		mirroring byte
+		want      string
+	}{
+		{"GTROM without four-screen", 111, 0x01, "mapper 111 boards are wired for four-screen"},
+		{"MMC1 with four-screen", 1, 0x08, "Four-screen bit is set, but mapper 1 controls mirroring itself"},
+		{"MMC1 with vertical", 1, 0x01, "Vertical mirroring bit is set, but mapper 1 controls mirroring itself"},
+	}
+	for _, test := range tests {
+		flags6 := test.mapper<<4 | test.mirroring
+		flags7 := test.mapper & 0xf0
+		data := append([]byte{'N', 'E', 'S', 0x1a, 2, 0, flags6, flags7, 0, 0, 0, 0, 0, 0, 0, 0}, make([]byte, 0x8000)...)
+		report, err := Inspect(data)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !strings.Contains(strings.Join(report.Lint, "\n"), test.want) {
+			t.Errorf("%s: lint %q doesn't mention %q", test.name, report.Lint, test.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(28, AnySubmapper, Factory{
+		Name: "Action 53",
+		New: func(header Header) (Mapper, error) {
+			return new(Action53), nil
+		},
+	})
+}
+
+// Action53 is the multicart mapper used by the Action 53 compilations. An
+// outer 32KB PRG bank picks the game, and an inner bank within a game size
+// of 32KB to 256KB is switched like BNROM, UNROM or CNROM would. It has 32KB
+// of CHR RAM. The register written at $8000-$FFFF is chosen through
+// $5000-$5FFF:
+//
+//	$00: CHR bank
+//	$01: inner PRG bank
+//	$80: mirroring, PRG mode and game size
+//	$81: outer PRG bank
+type Action53 struct {
+	ppuBanks
+
+	PRG []byte
+
+	selected uint8
+	chr      uint8
+	inner    uint8
+	mode     uint8
+	outer    uint8
+}
+
+// Init implements mapper.Init()
+func (r *Action53) Init(prg []byte) error {
+	if len(prg) < 0x8000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	// Start in the last 32KB, where the menu lives
+	r.outer = 0xff
+	return nil
+}
+
+// InitPPU implements mapper.PPUMapper. The board has 32KB of CHR RAM.
+func (r *Action53) InitPPU(chr []byte, vram []byte) error {
+	if err := r.ppuBanks.InitPPU(make([]byte, 0x8000), vram); err != nil {
+		return err
+	}
+	r.chrROM = 0
+	r.update()
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *Action53) Read(address uint16) (byte, error) {
+	return r.PRG[bankOffset(r.PRG, r.prgBank(address >= 0xc000), 0x4000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *Action53) Write(address uint16, value byte) error {
+	switch r.selected {
+	case 0x00:
+		r.chr = value & 0x03
+		r.setOneScreen(value)
+	case 0x01:
+		r.inner = value & 0x0f
+		r.setOneScreen(value)
+	case 0x80:
+		r.mode = value & 0x3f
+	case 0x81:
+		r.outer = value
+	}
+	r.update()
+	return nil
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *Action53) ReadLow(address uint16) (byte, error) {
+	return 0, fmt.Errorf("Action 53 read from unmapped address $%04x", address)
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *Action53) WriteLow(address uint16, value byte) error {
+	if address < 0x5000 || address >= 0x6000 {
+		return fmt.Errorf("Action 53 write to unmapped address $%04x", address)
+	}
+	r.selected = value & 0x81
+	return nil
+}
+
+// setOneScreen lets bit 4 of CHR and inner bank writes choose the page while
+// mirroring is one-screen, as the CNROM and UNROM games' own writes do.
+func (r *Action53) setOneScreen(value byte) {
+	if r.mode&0x02 == 0 {
+		r.mode = r.mode&^0x01 | value>>4&0x01
+	}
+}
+
+// prgBank returns the 16KB bank for either half of $8000-$FFFF. The game size
+// decides how many low bits of the bank come from the inner bank rather than
+// the outer one. PRG modes 2 and 3 fix the first or last bank of the game at
+// $8000 or $C000 respectively.
+func (r *Action53) prgBank(high bool) int {
+	mask := 2<<uint(r.mode>>4&0x03) - 1
+
+	var current int
+	switch {
+	case r.mode&0x08 == 0:
+		current = int(r.inner) << 1
+		if high {
+			current |= 1
+		}
+	case r.mode&0x04 == 0 && !high:
+		current = 0
+	case r.mode&0x04 != 0 && high:
+		current = 0xff
+	default:
+		current = int(r.inner)
+	}
+
+	return int(r.outer)<<1&^mask | current&mask
+}
+
+func (r *Action53) update() {
+	for i := range r.chrBanks {
+		r.chrBanks[i] = int(r.chr)*8 + i
+	}
+	r.mirroring = [4][4]int{mirrorSingleA, mirrorSingleB, mirrorVertical, mirrorHorizontal}[r.mode&0x03]
+}
+
+// SaveState implements mapper.StateMapper
+func (r *Action53) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *Action53) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+// serialize saves the board's CHR RAM, which the cartridge doesn't have
+func (r *Action53) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Bytes(r.CHR)
+	s.Uint8(&r.selected)
+	s.Uint8(&r.chr)
+	s.Uint8(&r.inner)
+	s.Uint8(&r.mode)
+	s.Uint8(&r.outer)
+}
@@ -0,0 +1,257 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	// Submapper 4 is the FCG-1/2, 5 the LZ93D50 with a 24C02. Others could
+	// be either, so decode both register ranges.
+	Register(16, AnySubmapper, Factory{
+		Name:       "Bandai FCG",
+		Boards:     []string{"FCG-1", "FCG-2", "LZ93D50 with 24C02"},
+		Submappers: []int{4, 5},
+		IRQ:        true,
+		New: func(header Header) (Mapper, error) {
+			switch header.Submapper {
+			case 4:
+				return &BandaiFCG{LowRegisters: true}, nil
+			case 5:
+				return &BandaiFCG{HighRegisters: true, EEPROMSize: 256}, nil
+			}
+			return &BandaiFCG{LowRegisters: true, HighRegisters: true, EEPROMSize: 256}, nil
+		},
+	})
+	Register(153, AnySubmapper, Factory{
+		Name:   "Bandai FCG",
+		Boards: []string{"LZ93D50 with SRAM"},
+		IRQ:    true,
+		New: func(header Header) (Mapper, error) {
+			return &BandaiFCG{HighRegisters: true, SRAM: true}, nil
+		},
+	})
+	Register(159, AnySubmapper, Factory{
+		Name:   "Bandai FCG",
+		Boards: []string{"LZ93D50 with 24C01"},
+		IRQ:    true,
+		New: func(header Header) (Mapper, error) {
+			return &BandaiFCG{HighRegisters: true, EEPROMSize: 128}, nil
+		},
+	})
+}
+
+// BandaiFCG is Bandai's FCG family of mappers: the FCG-1 and FCG-2, which
+// decode their registers at $6000-$7FFF, and the LZ93D50, which decodes them
+// at $8000-$FFFF and adds an IRQ latch. Registers repeat every 16 bytes and
+// select a 16KB PRG bank, 1KB CHR banks, mirroring and a 16-bit CPU cycle
+// IRQ counter. Some LZ93D50 boards save to a serial EEPROM, and one has 8KB
+// of battery-backed RAM and a second 256KB of PRG ROM instead. The board's
+// features must be set before Init.
+type BandaiFCG struct {
+	ppuBanks
+
+	PRG []byte
+
+	LowRegisters  bool
+	HighRegisters bool
+
+	// EEPROMSize is 256 for a 24C02, 128 for an X24C01, or 0 for none
+	EEPROMSize int
+
+	// SRAM is set for the board with battery-backed RAM, where bit 0 of
+	// the CHR registers selects the 256KB half of PRG ROM instead
+	SRAM bool
+	RAM  []byte
+
+	prgBank    int
+	outerBank  int
+	ramEnabled bool
+	eeprom     *eeprom24C
+
+	irqEnabled bool
+	irqCounter uint16
+	irqLatch   uint16
+	irqPending bool
+}
+
+// Init implements mapper.Init()
+func (r *BandaiFCG) Init(prg []byte) error {
+	if len(prg) < 0x4000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+	if !r.LowRegisters && !r.HighRegisters {
+		return errors.New("Bandai FCG register range not set")
+	}
+
+	r.PRG = prg
+	if r.SRAM {
+		r.RAM = make([]byte, 8192)
+	}
+	if r.EEPROMSize > 0 {
+		r.eeprom = newEEPROM24C(r.EEPROMSize)
+	}
+	r.mirroring = mirrorVertical
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *BandaiFCG) Read(address uint16) (byte, error) {
+	var bank int
+	switch {
+	case address < 0xc000:
+		bank = r.outerBank<<4 | r.prgBank
+	case r.SRAM:
+		bank = r.outerBank<<4 | 0x0f
+	default:
+		bank = -1
+	}
+	return r.PRG[bankOffset(r.PRG, bank, 0x4000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *BandaiFCG) Write(address uint16, value byte) error {
+	if !r.HighRegisters {
+		return fmt.Errorf("Bandai FCG has no register at $%04x", address)
+	}
+	r.writeRegister(address, value)
+	return nil
+}
+
+// ReadLow implements mapper.LowMapper, returning the RAM or the EEPROM's data
+// line in bit 4.
+func (r *BandaiFCG) ReadLow(address uint16) (byte, error) {
+	switch {
+	case address < 0x6000:
+	case r.SRAM && r.ramEnabled:
+		return r.RAM[address&0x1fff], nil
+	case r.eeprom != nil:
+		if r.eeprom.out {
+			return 0x10, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("Bandai FCG read from unmapped address $%04x", address)
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *BandaiFCG) WriteLow(address uint16, value byte) error {
+	switch {
+	case address < 0x6000:
+	case r.SRAM && r.ramEnabled:
+		r.RAM[address&0x1fff] = value
+		return nil
+	case r.LowRegisters:
+		r.writeRegister(address, value)
+		return nil
+	}
+	return fmt.Errorf("Bandai FCG write to unmapped address $%04x", address)
+}
+
+// Clock counts the IRQ counter down, raising an IRQ as it passes zero
+func (r *BandaiFCG) Clock() {
+	if !r.irqEnabled {
+		return
+	}
+	if r.irqCounter == 0 {
+		r.irqPending = true
+	}
+	r.irqCounter--
+}
+
+// IRQ implements mapper.IRQMapper
+func (r *BandaiFCG) IRQ() bool {
+	return r.irqPending
+}
+
+// LoadTrainer implements mapper.TrainerMapper
+func (r *BandaiFCG) LoadTrainer(trainer []byte) error {
+	if r.RAM == nil {
+		return errors.New("Bandai FCG board has registers at $7000 in place of PRG RAM")
+	}
+	copy(r.RAM[0x1000:], trainer)
+	return nil
+}
+
+// LoadSave implements mapper.SaveMapper
+func (r *BandaiFCG) LoadSave(save io.Reader) error {
+	return loadRAM(save, r.saveData())
+}
+
+// WriteSave implements mapper.SaveMapper
+func (r *BandaiFCG) WriteSave(save io.Writer) error {
+	return writeRAM(save, r.saveData())
+}
+
+// saveData returns whichever memory the board keeps across power cycles
+func (r *BandaiFCG) saveData() []byte {
+	if r.eeprom != nil {
+		return r.eeprom.data
+	}
+	return r.RAM
+}
+
+func (r *BandaiFCG) writeRegister(address uint16, value byte) {
+	switch register := address & 0x0f; {
+	case register < 0x08:
+		if r.SRAM {
+			r.outerBank = int(value & 0x01)
+		} else {
+			r.chrBanks[register] = int(value)
+		}
+	case register == 0x08:
+		r.prgBank = int(value & 0x0f)
+	case register == 0x09:
+		r.mirroring = [4][4]int{mirrorVertical, mirrorHorizontal, mirrorSingleA, mirrorSingleB}[value&0x03]
+	case register == 0x0a:
+		r.irqEnabled = value&0x01 != 0
+		r.irqPending = false
+		if r.HighRegisters {
+			r.irqCounter = r.irqLatch
+		}
+	case register == 0x0b, register == 0x0c:
+		// The LZ93D50 sets its latch, the FCG its counter directly
+		shift := uint(register-0x0b) * 8
+		r.irqLatch = r.irqLatch&^(0xff<<shift) | uint16(value)<<shift
+		if r.LowRegisters {
+			r.irqCounter = r.irqCounter&^(0xff<<shift) | uint16(value)<<shift
+		}
+	case register == 0x0d:
+		if r.SRAM {
+			r.ramEnabled = value&0x20 != 0
+		} else if r.eeprom != nil {
+			r.eeprom.set(value&0x20 != 0, value&0x40 != 0)
+		}
+	}
+}
+
+// SaveState implements mapper.StateMapper
+func (r *BandaiFCG) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *BandaiFCG) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *BandaiFCG) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	if r.RAM != nil {
+		s.Bytes(r.RAM)
+	}
+	s.Int(&r.prgBank)
+	s.Int(&r.outerBank)
+	s.Bool(&r.ramEnabled)
+	if r.eeprom != nil {
+		r.eeprom.serialize(s)
+	}
+
+	s.Bool(&r.irqEnabled)
+	s.Uint16(&r.irqCounter)
+	s.Uint16(&r.irqLatch)
+	s.Bool(&r.irqPending)
+}
@@ -0,0 +1,477 @@
+package mapper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/cartridge/patch"
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(20, AnySubmapper, Factory{
+		Name:   "Famicom Disk System",
+		Boards: []string{"RAM adapter"},
+		IRQ:    true,
+		Audio:  true,
+		New: func(header Header) (Mapper, error) {
+			return nil, errors.New("Famicom Disk System games must be loaded from disk images")
+		},
+	})
+}
+
+const fdsByteCycles = 149
+const fdsSpinUpCycles = 50000
+const fdsSwapCycles = 900000
+
+// FDS is the Famicom Disk System's RAM adapter and disk drive. It maps the
+// BIOS at $E000-$FFFF over 32KB of PRG RAM, has 8KB of CHR RAM, a timer IRQ,
+// the disk I/O registers at $4020-$4033 and a wavetable sound channel. The
+// disk sides, in .fds format, must be set before Init; the BIOS is passed to
+// Init in place of PRG ROM.
+type FDS struct {
+	ppuBanks
+
+	Disk [][]byte
+	BIOS []byte
+	RAM  []byte
+
+	raw         [][]byte
+	side        int
+	insertDelay int
+
+	diskIO  bool
+	soundIO bool
+
+	timerReload  uint16
+	timerCounter uint16
+	timerRepeat  bool
+	timerEnabled bool
+	timerIRQ     bool
+
+	// $4025 control bits
+	motorOn        bool
+	resetTransfer  bool
+	readMode       bool
+	crcControl     bool
+	diskReady      bool
+	diskIRQEnabled bool
+
+	writeData        uint8
+	readData         uint8
+	external         uint8
+	diskIRQ          bool
+	transferComplete bool
+
+	// drive state
+	position       int
+	delay          int
+	scanning       bool
+	endOfHead      bool
+	gapEnded       bool
+	crc            fdsCRC
+	crcOut         uint16
+	crcBytes       int
+	crcError       bool
+	prevCRCControl bool
+
+	audio fdsAudio
+}
+
+// Init implements mapper.Init(), taking the 8KB BIOS in place of PRG ROM
+func (r *FDS) Init(bios []byte) error {
+	if len(bios) != 8192 {
+		return errors.New("Invalid FDS BIOS length, expected 8KB")
+	}
+	if len(r.Disk) == 0 {
+		return errors.New("FDS image has no disk sides")
+	}
+
+	r.BIOS = bios
+	r.RAM = make([]byte, 32768)
+	r.raw = make([][]byte, len(r.Disk))
+	for i, side := range r.Disk {
+		if len(side) != FDSSideSize {
+			return fmt.Errorf("FDS disk side %d is %d bytes, expected %d", i, len(side), FDSSideSize)
+		}
+		r.raw[i] = rawSide(side)
+	}
+
+	r.side = 0
+	r.endOfHead = true
+	r.mirroring = mirrorHorizontal
+	r.audio.init()
+	return nil
+}
+
+// InitPPU implements mapper.PPUMapper. The RAM adapter has 8KB of CHR RAM
+// and no CHR ROM.
+func (r *FDS) InitPPU(chr []byte, vram []byte) error {
+	if err := r.ppuBanks.InitPPU(make([]byte, 8192), vram); err != nil {
+		return err
+	}
+	r.chrROM = 0
+	return nil
+}
+
+// Read returns PRG RAM below $E000 and the BIOS above it
+func (r *FDS) Read(address uint16) (byte, error) {
+	if address >= 0xe000 {
+		return r.BIOS[address-0xe000], nil
+	}
+	return r.RAM[address-0x6000], nil
+}
+
+// Write stores a value in PRG RAM; the BIOS can't be written
+func (r *FDS) Write(address uint16, value byte) error {
+	if address >= 0xe000 {
+		return fmt.Errorf("FDS write to BIOS at $%04x", address)
+	}
+	r.RAM[address-0x6000] = value
+	return nil
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *FDS) ReadLow(address uint16) (byte, error) {
+	switch {
+	case address >= 0x6000:
+		return r.RAM[address-0x6000], nil
+	case address >= 0x4040 && address <= 0x4092 && r.soundIO:
+		return r.audio.read(address)
+	case address < 0x4030 || address > 0x4033 || !r.diskIO:
+		return 0, fmt.Errorf("FDS read from unmapped address $%04x", address)
+	}
+
+	var value uint8
+	switch address {
+	case 0x4030:
+		if r.timerIRQ {
+			value |= 0x01
+		}
+		if r.transferComplete {
+			value |= 0x02
+		}
+		if r.crcError {
+			value |= 0x10
+		}
+		if r.endOfHead {
+			value |= 0x40
+		}
+		r.timerIRQ = false
+		r.diskIRQ = false
+		r.transferComplete = false
+	case 0x4031:
+		value = r.readData
+		r.transferComplete = false
+		r.diskIRQ = false
+	case 0x4032:
+		value = 0x40
+		if !r.inserted() {
+			value |= 0x07
+		} else if !r.scanning {
+			value |= 0x02
+		}
+	case 0x4033:
+		// Battery good
+		value = 0x80
+	}
+	return value, nil
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *FDS) WriteLow(address uint16, value byte) error {
+	switch {
+	case address >= 0x6000:
+		r.RAM[address-0x6000] = value
+		return nil
+	case address == 0x4023:
+		r.diskIO = value&0x01 != 0
+		r.soundIO = value&0x02 != 0
+		if !r.diskIO {
+			r.timerEnabled = false
+			r.timerIRQ = false
+			r.diskIRQ = false
+		}
+		return nil
+	case address >= 0x4040 && address <= 0x408a:
+		if r.soundIO {
+			r.audio.write(address, value)
+		}
+		return nil
+	case address < 0x4020 || address > 0x4026:
+		return fmt.Errorf("FDS write to unmapped address $%04x", address)
+	case !r.diskIO:
+		return nil
+	}
+
+	switch address {
+	case 0x4020:
+		r.timerReload = r.timerReload&0xff00 | uint16(value)
+	case 0x4021:
+		r.timerReload = r.timerReload&0x00ff | uint16(value)<<8
+	case 0x4022:
+		r.timerRepeat = value&0x01 != 0
+		r.timerEnabled = value&0x02 != 0
+		if r.timerEnabled {
+			r.timerCounter = r.timerReload
+		} else {
+			r.timerIRQ = false
+		}
+	case 0x4024:
+		r.writeData = value
+		r.transferComplete = false
+		r.diskIRQ = false
+	case 0x4025:
+		r.motorOn = value&0x01 != 0
+		r.resetTransfer = value&0x02 != 0
+		r.readMode = value&0x04 != 0
+		if value&0x08 != 0 {
+			r.mirroring = mirrorHorizontal
+		} else {
+			r.mirroring = mirrorVertical
+		}
+		r.crcControl = value&0x10 != 0
+		r.diskReady = value&0x40 != 0
+		r.diskIRQEnabled = value&0x80 != 0
+		r.diskIRQ = false
+	case 0x4026:
+		r.external = value
+	}
+	return nil
+}
+
+// Clock advances the timer, the disk drive and the sound channel by one CPU
+// cycle.
+func (r *FDS) Clock() {
+	if r.timerEnabled && r.diskIO {
+		if r.timerCounter == 0 {
+			r.timerIRQ = true
+			r.timerCounter = r.timerReload
+			if !r.timerRepeat {
+				r.timerEnabled = false
+			}
+		} else {
+			r.timerCounter--
+		}
+	}
+
+	r.clockDrive()
+	r.audio.clock()
+}
+
+// IRQ reports whether the timer or a disk transfer is asserting the IRQ line
+func (r *FDS) IRQ() bool {
+	return r.timerIRQ || r.diskIRQ
+}
+
+// Output implements mapper.AudioMapper
+func (r *FDS) Output() float32 {
+	return r.audio.output()
+}
+
+// Sides implements mapper.DiskMapper
+func (r *FDS) Sides() int {
+	return len(r.raw)
+}
+
+// InsertDisk implements mapper.DiskMapper. The drive reports no disk for a
+// moment first, so the BIOS notices the swap.
+func (r *FDS) InsertDisk(side int) error {
+	if side < 0 || side >= len(r.raw) {
+		return fmt.Errorf("Disk side %d out of range, image has %d sides", side, len(r.raw))
+	}
+	r.side = side
+	r.insertDelay = fdsSwapCycles
+	return nil
+}
+
+// EjectDisk implements mapper.DiskMapper
+func (r *FDS) EjectDisk() {
+	r.side = -1
+}
+
+func (r *FDS) inserted() bool {
+	return r.side >= 0 && r.insertDelay == 0
+}
+
+// clockDrive moves the disk under the head. Once the motor has spun up, a
+// byte is transferred every 149 CPU cycles, with an IRQ if enabled. While
+// reading, the zeros of a gap aren't transferred, and the $80 start mark
+// ends the gap without raising an IRQ. While writing, the CRC is written in
+// place of data once CRC control is set.
+func (r *FDS) clockDrive() {
+	if r.insertDelay > 0 {
+		r.insertDelay--
+	}
+	if !r.inserted() || !r.motorOn {
+		r.endOfHead = true
+		r.scanning = false
+		return
+	}
+	if r.resetTransfer && !r.scanning {
+		return
+	}
+	if r.endOfHead {
+		r.delay = fdsSpinUpCycles
+		r.endOfHead = false
+		r.position = 0
+		r.gapEnded = false
+		return
+	}
+	if r.delay > 0 {
+		r.delay--
+		return
+	}
+
+	r.scanning = true
+	raw := r.raw[r.side]
+	irq := r.diskIRQEnabled
+
+	if !r.diskReady {
+		r.crc = 0
+		r.crcBytes = 0
+	}
+
+	if r.readMode {
+		value := raw[r.position]
+		r.crc.update(value)
+		if !r.diskReady {
+			r.gapEnded = false
+		} else if value != 0 && !r.gapEnded {
+			r.gapEnded = true
+			irq = false
+		} else if r.crcControl {
+			r.crcBytes++
+			if r.crcBytes == 2 {
+				r.crcError = r.crc != 0
+			}
+		}
+
+		if r.gapEnded {
+			r.transferComplete = true
+			r.readData = value
+			r.diskIRQ = r.diskIRQ || irq
+		}
+	} else {
+		var value uint8
+		if !r.crcControl {
+			r.transferComplete = true
+			r.diskIRQ = r.diskIRQ || irq
+			value = r.writeData
+		}
+		if !r.diskReady {
+			value = 0
+		}
+
+		if !r.crcControl {
+			r.crc.update(value)
+		} else {
+			if !r.prevCRCControl {
+				r.crcOut = r.crc.sum()
+			}
+			value = uint8(r.crcOut)
+			r.crcOut >>= 8
+		}
+
+		raw[r.position] = value
+		r.gapEnded = false
+	}
+
+	r.prevCRCControl = r.crcControl
+	r.position++
+	if r.position >= len(raw) {
+		r.motorOn = false
+		r.diskIRQ = r.diskIRQ || irq
+	} else {
+		r.delay = fdsByteCycles
+	}
+}
+
+// LoadSave applies a save written by WriteSave to the disk sides
+func (r *FDS) LoadSave(save io.Reader) error {
+	image := bytes.Join(r.Disk, nil)
+	if err := applyIPS(image, save); err != nil {
+		return err
+	}
+
+	for i := range r.raw {
+		r.raw[i] = rawSide(image[i*FDSSideSize : (i+1)*FDSSideSize])
+	}
+	return nil
+}
+
+// WriteSave writes the changes the game has made to the disk, as an IPS
+// patch against the original image, leaving the image itself untouched.
+func (r *FDS) WriteSave(save io.Writer) error {
+	var original, current []byte
+	for i, raw := range r.raw {
+		original = append(original, r.Disk[i]...)
+		current = append(current, cookedSide(raw)...)
+	}
+	return patch.WriteIPS(save, original, current)
+}
+
+// SaveState implements mapper.StateMapper
+func (r *FDS) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *FDS) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+// serialize saves the RAM adapter's RAM and the disk sides as the game has
+// written them, as well as the drive
+func (r *FDS) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Bytes(r.CHR)
+	s.Bytes(r.RAM)
+	sides := len(r.raw)
+	s.Int(&sides)
+	if sides != len(r.raw) {
+		s.Fail(fmt.Errorf("State has %d disk sides where %d were expected", sides, len(r.raw)))
+		return
+	}
+	for i := range r.raw {
+		s.Slice(&r.raw[i])
+	}
+	s.Int(&r.side)
+	s.Int(&r.insertDelay)
+
+	s.Bool(&r.diskIO)
+	s.Bool(&r.soundIO)
+
+	s.Uint16(&r.timerReload)
+	s.Uint16(&r.timerCounter)
+	s.Bool(&r.timerRepeat)
+	s.Bool(&r.timerEnabled)
+	s.Bool(&r.timerIRQ)
+
+	for _, flag := range []*bool{&r.motorOn, &r.resetTransfer, &r.readMode, &r.crcControl, &r.diskReady, &r.diskIRQEnabled} {
+		s.Bool(flag)
+	}
+
+	s.Uint8(&r.writeData)
+	s.Uint8(&r.readData)
+	s.Uint8(&r.external)
+	s.Bool(&r.diskIRQ)
+	s.Bool(&r.transferComplete)
+
+	s.Int(&r.position)
+	s.Int(&r.delay)
+	s.Bool(&r.scanning)
+	s.Bool(&r.endOfHead)
+	s.Bool(&r.gapEnded)
+	crc := uint16(r.crc)
+	s.Uint16(&crc)
+	r.crc = fdsCRC(crc)
+	s.Uint16(&r.crcOut)
+	s.Int(&r.crcBytes)
+	s.Bool(&r.crcError)
+	s.Bool(&r.prevCRCControl)
+
+	r.audio.serialize(s)
+}
@@ -0,0 +1,278 @@
+package mapper
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// Volume multipliers for the master volume setting in $4089
+var fdsMasterVolume = [4]float32{2.0 / 2, 2.0 / 3, 2.0 / 4, 2.0 / 5}
+
+// Changes applied to the mod counter by each modulation table entry. Entry 4
+// resets the counter instead.
+var fdsModSteps = [8]int{0, 1, 2, 4, 0, -4, -2, -1}
+
+// The RAM adapter filters its output with an RC low-pass at about 2kHz
+var fdsFilter = float32(1 - math.Exp(-2*math.Pi*2000/1789773))
+
+// fdsEnvelope is one of the FDS's two envelope units, which ramp a 6-bit gain
+// up or down at a rate set by their speed and the master envelope speed.
+type fdsEnvelope struct {
+	disabled bool
+	increase bool
+	speed    uint8
+	gain     uint8
+	timer    int
+}
+
+func (e *fdsEnvelope) write(value uint8, master uint8) {
+	e.disabled = value&0x80 != 0
+	e.increase = value&0x40 != 0
+	e.speed = value & 0x3f
+	if e.disabled {
+		e.gain = e.speed
+	}
+	e.reset(master)
+}
+
+func (e *fdsEnvelope) reset(master uint8) {
+	e.timer = 8 * (int(e.speed) + 1) * int(master)
+}
+
+func (e *fdsEnvelope) clock(master uint8) {
+	if e.disabled || master == 0 {
+		return
+	}
+	if e.timer > 0 {
+		e.timer--
+		return
+	}
+
+	e.reset(master)
+	if e.increase && e.gain < 32 {
+		e.gain++
+	} else if !e.increase && e.gain > 0 {
+		e.gain--
+	}
+}
+
+// fdsAudio is the RAM adapter's wavetable channel: a 64-step 6-bit waveform
+// whose pitch is bent by a modulation unit reading a 32-entry table of
+// counter adjustments.
+type fdsAudio struct {
+	wave      [64]uint8
+	waveWrite bool
+	waveHalt  bool
+	pitch     uint16
+	phase     uint32
+	position  uint8
+
+	volume       fdsEnvelope
+	mod          fdsEnvelope
+	envelopeHalt bool
+	masterSpeed  uint8
+	masterVolume uint8
+
+	modTable    [64]uint8
+	modPosition uint8
+	modCounter  int
+	modPitch    uint16
+	modPhase    uint32
+	modHalt     bool
+
+	level  uint8
+	filter float32
+}
+
+func (a *fdsAudio) init() {
+	a.masterSpeed = 0xe8
+	a.modHalt = true
+}
+
+func (a *fdsAudio) read(address uint16) (uint8, error) {
+	switch {
+	case address < 0x4080:
+		return a.wave[address-0x4040], nil
+	case address == 0x4090:
+		return a.volume.gain, nil
+	case address == 0x4092:
+		return a.mod.gain, nil
+	}
+	return 0, fmt.Errorf("FDS read from unmapped sound register $%04x", address)
+}
+
+func (a *fdsAudio) write(address uint16, value uint8) {
+	switch {
+	case address < 0x4080:
+		if a.waveWrite {
+			a.wave[address-0x4040] = value & 0x3f
+		}
+	case address == 0x4080:
+		a.volume.write(value, a.masterSpeed)
+	case address == 0x4082:
+		a.pitch = a.pitch&0x0f00 | uint16(value)
+	case address == 0x4083:
+		a.pitch = a.pitch&0x00ff | uint16(value&0x0f)<<8
+		a.envelopeHalt = value&0x40 != 0
+		a.waveHalt = value&0x80 != 0
+		if a.waveHalt {
+			a.phase = 0
+			a.position = 0
+		}
+		if a.envelopeHalt {
+			a.volume.reset(a.masterSpeed)
+			a.mod.reset(a.masterSpeed)
+		}
+	case address == 0x4084:
+		a.mod.write(value, a.masterSpeed)
+	case address == 0x4085:
+		a.modCounter = signed7(value)
+	case address == 0x4086:
+		a.modPitch = a.modPitch&0x0f00 | uint16(value)
+	case address == 0x4087:
+		a.modPitch = a.modPitch&0x00ff | uint16(value&0x0f)<<8
+		a.modHalt = value&0x80 != 0
+		if a.modHalt {
+			a.modPhase = 0
+		}
+	case address == 0x4088:
+		// The table can only be written while the modulator is halted, and
+		// each write fills two entries.
+		if a.modHalt {
+			a.modTable[a.modPosition] = value & 0x07
+			a.modTable[a.modPosition+1] = value & 0x07
+			a.modPosition = (a.modPosition + 2) & 0x3f
+		}
+	case address == 0x4089:
+		a.waveWrite = value&0x80 != 0
+		a.masterVolume = value & 0x03
+	case address == 0x408a:
+		a.masterSpeed = value
+	}
+}
+
+func (a *fdsAudio) clock() {
+	if !a.envelopeHalt && !a.waveHalt {
+		a.volume.clock(a.masterSpeed)
+		a.mod.clock(a.masterSpeed)
+	}
+
+	if !a.modHalt {
+		a.modPhase += uint32(a.modPitch)
+		if a.modPhase >= 0x10000 {
+			a.modPhase -= 0x10000
+			step := a.modTable[a.modPosition]
+			if step == 4 {
+				a.modCounter = 0
+			} else {
+				a.modCounter = signed7(uint8(a.modCounter + fdsModSteps[step]))
+			}
+			a.modPosition = (a.modPosition + 1) & 0x3f
+		}
+	}
+
+	if !a.waveHalt && !a.waveWrite {
+		a.phase += uint32(a.modulatedPitch())
+		if a.phase >= 0x10000 {
+			a.phase &= 0xffff
+			a.position = (a.position + 1) & 0x3f
+		}
+		a.level = a.wave[a.position]
+	}
+
+	target := float32(0)
+	if gain := a.volume.gain; gain > 0 {
+		if gain > 32 {
+			gain = 32
+		}
+		target = float32(a.level) * float32(gain) * fdsMasterVolume[a.masterVolume]
+	}
+	a.filter += (target - a.filter) * fdsFilter
+}
+
+// modulatedPitch applies the mod counter and mod gain to the wave pitch,
+// following the rounding of the hardware's multiplier.
+func (a *fdsAudio) modulatedPitch() int {
+	pitch := int(a.pitch)
+	if a.modHalt {
+		return pitch
+	}
+
+	temp := a.modCounter * int(a.mod.gain)
+	remainder := temp & 0x0f
+	temp >>= 4
+	if remainder > 0 && temp&0x80 == 0 {
+		if a.modCounter < 0 {
+			temp--
+		} else {
+			temp += 2
+		}
+	}
+	if temp >= 192 {
+		temp -= 256
+	} else if temp < -64 {
+		temp += 256
+	}
+
+	temp *= pitch
+	remainder = temp & 0x3f
+	temp >>= 6
+	if remainder >= 32 {
+		temp++
+	}
+
+	if pitch+temp < 0 {
+		return 0
+	}
+	return pitch + temp
+}
+
+// output scales the filtered level so that full volume is about 2.4 times
+// as loud as a 2A03 pulse channel at full volume.
+func (a *fdsAudio) output() float32 {
+	return a.filter / (63 * 32) * 2.4 * 95.88 / (8128/15 + 100)
+}
+
+// signed7 sign-extends a 7-bit value
+func signed7(value uint8) int {
+	value &= 0x7f
+	if value >= 0x40 {
+		return int(value) - 0x80
+	}
+	return int(value)
+}
+
+func (e *fdsEnvelope) serialize(s *state.Stream) {
+	s.Bool(&e.disabled)
+	s.Bool(&e.increase)
+	s.Uint8(&e.speed)
+	s.Uint8(&e.gain)
+	s.Int(&e.timer)
+}
+
+func (a *fdsAudio) serialize(s *state.Stream) {
+	s.Bytes(a.wave[:])
+	s.Bool(&a.waveWrite)
+	s.Bool(&a.waveHalt)
+	s.Uint16(&a.pitch)
+	s.Uint32(&a.phase)
+	s.Uint8(&a.position)
+
+	a.volume.serialize(s)
+	a.mod.serialize(s)
+	s.Bool(&a.envelopeHalt)
+	s.Uint8(&a.masterSpeed)
+	s.Uint8(&a.masterVolume)
+
+	s.Bytes(a.modTable[:])
+	s.Uint8(&a.modPosition)
+	s.Int(&a.modCounter)
+	s.Uint16(&a.modPitch)
+	s.Uint32(&a.modPhase)
+	s.Bool(&a.modHalt)
+
+	s.Uint8(&a.level)
+	s.Float32(&a.filter)
+}
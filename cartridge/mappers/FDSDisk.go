@@ -0,0 +1,173 @@
+package mapper
+
+// FDSSideSize is the size of one disk side in the .fds image format, which
+// stores the blocks back to back without their gaps or CRCs.
+const FDSSideSize = 65500
+
+// The drive sees a side as a serial stream: a long lead-in gap, then each
+// block preceded by a shorter gap and a $80 start mark and followed by its
+// CRC. Gaps are given here in bytes.
+const (
+	fdsLeadInGap = 28300 / 8
+	fdsBlockGap  = 976 / 8
+	fdsRawSize   = 68000
+)
+
+// fdsCRC is the CRC-16 the drive appends to each block, computed over the
+// block's data but not its $80 start mark.
+type fdsCRC uint16
+
+func newFDSCRC() fdsCRC {
+	return 0x8000
+}
+
+func (c *fdsCRC) update(value byte) {
+	for bit := uint(0); bit < 8; bit++ {
+		carry := *c&0x01 != 0
+		*c = *c>>1 | fdsCRC(value>>bit&0x01)<<15
+		if carry {
+			*c ^= 0x8408
+		}
+	}
+}
+
+// sum returns the CRC of the bytes seen so far, which is what the register
+// holds after shifting in two more zero bytes.
+func (c fdsCRC) sum() uint16 {
+	c.update(0)
+	c.update(0)
+	return uint16(c)
+}
+
+// fdsBlockLength returns the length of the block at the start of data, or 0
+// when no valid block starts there. File data blocks take their length from
+// the file header block before them.
+func fdsBlockLength(data []byte, fileSize int) int {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var length int
+	switch data[0] {
+	case 1:
+		length = 56
+	case 2:
+		length = 2
+	case 3:
+		length = 16
+	case 4:
+		length = 1 + fileSize
+	default:
+		return 0
+	}
+
+	if length > len(data) {
+		return 0
+	}
+	return length
+}
+
+// fdsFileSize reads the file size from a file header block
+func fdsFileSize(header []byte) int {
+	return int(header[13]) | int(header[14])<<8
+}
+
+// fdsBlocks splits a side in .fds format into its blocks. Files beyond the
+// count in the file amount block are included, as some games hide files
+// there.
+func fdsBlocks(side []byte) [][]byte {
+	var blocks [][]byte
+	fileSize := 0
+	for position := 0; position < len(side); {
+		length := fdsBlockLength(side[position:], fileSize)
+		if length == 0 {
+			break
+		}
+		block := side[position : position+length]
+		if block[0] == 3 {
+			fileSize = fdsFileSize(block)
+		}
+		blocks = append(blocks, block)
+		position += length
+	}
+	return blocks
+}
+
+// rawSide lays a side out the way the drive reads it, with gaps, start marks
+// and CRCs, padded with blank disk after the last block.
+func rawSide(side []byte) []byte {
+	raw := make([]byte, 0, fdsRawSize)
+	for i, block := range fdsBlocks(side) {
+		gap := fdsBlockGap
+		if i == 0 {
+			gap = fdsLeadInGap
+		}
+		raw = append(raw, make([]byte, gap)...)
+		raw = append(raw, 0x80)
+		raw = append(raw, block...)
+
+		crc := newFDSCRC()
+		for _, value := range block {
+			crc.update(value)
+		}
+		sum := crc.sum()
+		raw = append(raw, uint8(sum), uint8(sum>>8))
+	}
+
+	if len(raw) < fdsRawSize {
+		raw = append(raw, make([]byte, fdsRawSize-len(raw))...)
+	}
+	return raw
+}
+
+// cookedSide reverses rawSide, collecting the blocks found on a raw side back
+// into .fds format. It stops at the first gap that isn't followed by a valid
+// block.
+func cookedSide(raw []byte) []byte {
+	side := make([]byte, 0, FDSSideSize)
+	fileSize := 0
+	position := 0
+	for position < len(raw) {
+		for position < len(raw) && raw[position] == 0 {
+			position++
+		}
+		if position >= len(raw) || raw[position] != 0x80 {
+			break
+		}
+		position++
+
+		length := fdsBlockLength(raw[position:], fileSize)
+		if length == 0 || len(side)+length > FDSSideSize {
+			break
+		}
+		block := raw[position : position+length]
+		if block[0] == 3 {
+			fileSize = fdsFileSize(block)
+		}
+		side = append(side, block...)
+		position += length + 2
+	}
+
+	return append(side, make([]byte, FDSSideSize-len(side))...)
+}
+
+// QDSide converts a side from the QD image format, which keeps the CRC after
+// each block and pads sides to 64KB, to .fds format.
+func QDSide(qd []byte) []byte {
+	side := make([]byte, 0, FDSSideSize)
+	fileSize := 0
+	for position := 0; position < len(qd); {
+		length := fdsBlockLength(qd[position:], fileSize)
+		if length == 0 || len(side)+length > FDSSideSize {
+			break
+		}
+		block := qd[position : position+length]
+		if block[0] == 3 {
+			fileSize = fdsFileSize(block)
+		}
+		side = append(side, block...)
+		position += length + 2
+	}
+
+	return append(side, make([]byte, FDSSideSize-len(side))...)
+}
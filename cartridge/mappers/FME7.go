@@ -0,0 +1,180 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(69, AnySubmapper, Factory{
+		Name:   "Sunsoft FME-7",
+		Boards: []string{"JLROM", "JSROM", "Sunsoft 5B"},
+		IRQ:    true,
+		Audio:  true,
+		New: func(header Header) (Mapper, error) {
+			return new(FME7), nil
+		},
+	})
+}
+
+// FME7 is Sunsoft's FME-7 mapper and its 5B variant, which adds a three
+// channel sound chip. Registers are written by selecting a command at
+// $8000-$9FFF and writing its parameter to $A000-$BFFF. It has four 8KB PRG
+// banks, one of which maps at $6000 and can select PRG RAM instead, 1KB CHR
+// banks, mirroring control and a 16-bit CPU cycle IRQ counter.
+type FME7 struct {
+	ppuBanks
+
+	PRG []byte
+	RAM []byte
+
+	command    uint8
+	prgBanks   [4]int
+	ramSelect  bool
+	ramEnabled bool
+
+	irqEnabled     bool
+	counterEnabled bool
+	irqCounter     uint16
+	irqPending     bool
+
+	audio sunsoft5B
+}
+
+// Init implements mapper.Init()
+func (r *FME7) Init(prg []byte) error {
+	if len(prg) < 0x2000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.RAM = make([]byte, 8192)
+	r.mirroring = mirrorVertical
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *FME7) Read(address uint16) (byte, error) {
+	bank := -1
+	if slot := int(address-0x8000)/0x2000 + 1; slot < 4 {
+		bank = r.prgBanks[slot]
+	}
+	return r.PRG[bankOffset(r.PRG, bank, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *FME7) Write(address uint16, value byte) error {
+	switch {
+	case address < 0xa000:
+		r.command = value & 0x0f
+	case address < 0xc000:
+		r.writeParameter(value)
+	case address < 0xe000:
+		r.audio.selectRegister(value)
+	default:
+		r.audio.write(value)
+	}
+	return nil
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *FME7) ReadLow(address uint16) (byte, error) {
+	switch {
+	case address < 0x6000:
+		return 0, fmt.Errorf("FME-7 read from unmapped address $%04x", address)
+	case !r.ramSelect:
+		return r.PRG[bankOffset(r.PRG, r.prgBanks[0], 0x2000, address)], nil
+	case r.ramEnabled:
+		return r.RAM[bankOffset(r.RAM, r.prgBanks[0], 0x2000, address)], nil
+	}
+	return 0, errors.New("FME-7 PRG RAM is disabled")
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *FME7) WriteLow(address uint16, value byte) error {
+	if address < 0x6000 || !r.ramSelect || !r.ramEnabled {
+		return fmt.Errorf("FME-7 write to unmapped address $%04x", address)
+	}
+	r.RAM[bankOffset(r.RAM, r.prgBanks[0], 0x2000, address)] = value
+	return nil
+}
+
+// LoadTrainer implements mapper.TrainerMapper
+func (r *FME7) LoadTrainer(trainer []byte) error {
+	copy(r.RAM[0x1000:], trainer)
+	return nil
+}
+
+// Clock counts the IRQ counter down, raising an IRQ when it wraps from $0000
+// to $FFFF, and advances the sound.
+func (r *FME7) Clock() {
+	if r.counterEnabled {
+		r.irqCounter--
+		if r.irqCounter == 0xffff && r.irqEnabled {
+			r.irqPending = true
+		}
+	}
+	r.audio.clock()
+}
+
+// IRQ implements mapper.IRQMapper
+func (r *FME7) IRQ() bool {
+	return r.irqPending
+}
+
+// Output implements mapper.AudioMapper
+func (r *FME7) Output() float32 {
+	return r.audio.output()
+}
+
+func (r *FME7) writeParameter(value byte) {
+	switch command := r.command; {
+	case command < 0x08:
+		r.chrBanks[command] = int(value)
+	case command == 0x08:
+		r.prgBanks[0] = int(value & 0x3f)
+		r.ramSelect = value&0x40 != 0
+		r.ramEnabled = value&0x80 != 0
+	case command < 0x0c:
+		r.prgBanks[command-0x08] = int(value & 0x3f)
+	case command == 0x0c:
+		r.mirroring = [4][4]int{mirrorVertical, mirrorHorizontal, mirrorSingleA, mirrorSingleB}[value&0x03]
+	case command == 0x0d:
+		r.irqEnabled = value&0x01 != 0
+		r.counterEnabled = value&0x80 != 0
+		r.irqPending = false
+	case command == 0x0e:
+		r.irqCounter = r.irqCounter&0xff00 | uint16(value)
+	case command == 0x0f:
+		r.irqCounter = r.irqCounter&0x00ff | uint16(value)<<8
+	}
+}
+
+// SaveState implements mapper.StateMapper
+func (r *FME7) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *FME7) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *FME7) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Bytes(r.RAM)
+	s.Uint8(&r.command)
+	s.Ints(r.prgBanks[:])
+	s.Bool(&r.ramSelect)
+	s.Bool(&r.ramEnabled)
+
+	s.Bool(&r.irqEnabled)
+	s.Bool(&r.counterEnabled)
+	s.Uint16(&r.irqCounter)
+	s.Bool(&r.irqPending)
+
+	r.audio.serialize(s)
+}
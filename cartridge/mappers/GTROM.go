@@ -0,0 +1,145 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(111, AnySubmapper, Factory{
+		Name:   "GTROM",
+		Boards: []string{"Cheapocabra"},
+		New: func(header Header) (Mapper, error) {
+			return new(GTROM), nil
+		},
+	})
+}
+
+// GTROM is the Cheapocabra homebrew board. A register at $5000-$5FFF and
+// $7000-$7FFF selects a 32KB PRG bank, one of two 8KB CHR RAM pages, one of
+// two 8KB pages of four-screen nametable RAM, and drives two LEDs. The PRG
+// ROM is a flash chip the game can reprogram through $8000-$FFFF.
+type GTROM struct {
+	ppuBanks
+
+	PRG []byte
+
+	bank       int
+	nametables []byte
+	nametable  int
+	red        bool
+	green      bool
+	flash      sstFlash
+}
+
+// Init implements mapper.Init()
+func (r *GTROM) Init(prg []byte) error {
+	if len(prg) < 0x8000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.flash.init(prg)
+	return nil
+}
+
+// InitPPU implements mapper.PPUMapper. The board has 16KB of CHR RAM and
+// 16KB of nametable RAM of its own, and doesn't use the console's.
+func (r *GTROM) InitPPU(chr []byte, vram []byte) error {
+	r.nametables = make([]byte, 0x4000)
+	if err := r.ppuBanks.InitPPU(make([]byte, 0x4000), r.nametables[:0x2000]); err != nil {
+		return err
+	}
+	r.chrROM = 0
+	r.mirroring = [4]int{0, 1, 2, 3}
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *GTROM) Read(address uint16) (byte, error) {
+	return r.flash.read(bankOffset(r.PRG, r.bank, 0x8000, address)), nil
+}
+
+// Write implements mapper.Write()
+func (r *GTROM) Write(address uint16, value byte) error {
+	r.flash.write(r.bank*0x8000+int(address&0x7fff), value)
+	return nil
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *GTROM) ReadLow(address uint16) (byte, error) {
+	return 0, fmt.Errorf("GTROM read from unmapped address $%04x", address)
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *GTROM) WriteLow(address uint16, value byte) error {
+	if address&0xd000 != 0x5000 {
+		return fmt.Errorf("GTROM write to unmapped address $%04x", address)
+	}
+
+	r.bank = int(value & 0x0f)
+	for i := range r.chrBanks {
+		r.chrBanks[i] = int(value>>4&0x01)*8 + i
+	}
+	r.nametable = int(value >> 5 & 0x01)
+	r.selectNametables()
+	r.red = value&0x40 == 0
+	r.green = value&0x80 == 0
+	return nil
+}
+
+// selectNametables maps the selected page of nametable RAM into the PPU
+// address space
+func (r *GTROM) selectNametables() {
+	page := r.nametable * 0x2000
+	r.vram = r.nametables[page : page+0x2000]
+}
+
+// LEDs returns whether the board's red and green LEDs are lit
+func (r *GTROM) LEDs() (red bool, green bool) {
+	return r.red, r.green
+}
+
+// LoadSave implements mapper.SaveMapper
+func (r *GTROM) LoadSave(save io.Reader) error {
+	return r.flash.loadSave(save)
+}
+
+// WriteSave implements mapper.SaveMapper, writing the reflashed PRG ROM as an
+// IPS patch against the original.
+func (r *GTROM) WriteSave(save io.Writer) error {
+	return r.flash.writeSave(save)
+}
+
+// SaveState implements mapper.StateMapper
+func (r *GTROM) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *GTROM) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+// serialize saves the board's CHR and nametable RAM, and the flash chip
+// whole as the game may have reprogrammed it
+func (r *GTROM) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Bytes(r.CHR)
+	s.Int(&r.bank)
+	s.Bytes(r.nametables)
+	s.Int(&r.nametable)
+	if s.Loading() {
+		if r.nametable != 0 && r.nametable != 1 {
+			s.Fail(fmt.Errorf("Invalid nametable page %d", r.nametable))
+			return
+		}
+		r.selectNametables()
+	}
+	s.Bool(&r.red)
+	s.Bool(&r.green)
+	r.flash.serialize(s)
+}
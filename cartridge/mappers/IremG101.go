@@ -0,0 +1,115 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(32, AnySubmapper, Factory{
+		Name:       "Irem G-101",
+		Submappers: []int{1},
+		New: func(header Header) (Mapper, error) {
+			return &IremG101{OneScreen: header.Submapper == 1}, nil
+		},
+	})
+}
+
+// IremG101 is Irem's G-101 mapper, with two switchable 8KB PRG banks, one of
+// which can swap places with the fixed second to last bank, and 1KB CHR
+// banks.
+type IremG101 struct {
+	ppuBanks
+
+	PRG []byte
+
+	// OneScreen is set for boards that hardwire one-screen mirroring and
+	// ignore $9000, like Major League's (submapper 1).
+	OneScreen bool
+
+	prgBanks [2]int
+	swapMode bool
+}
+
+// Init implements mapper.Init()
+func (r *IremG101) Init(prg []byte) error {
+	if len(prg) < 0x4000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.prgBanks = [2]int{0, 1}
+	if r.OneScreen {
+		r.mirroring = mirrorSingleA
+	} else {
+		r.mirroring = mirrorVertical
+	}
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *IremG101) Read(address uint16) (byte, error) {
+	var bank int
+	switch {
+	case address < 0xa000:
+		bank = r.prgBanks[0]
+		if r.swapMode {
+			bank = -2
+		}
+	case address < 0xc000:
+		bank = r.prgBanks[1]
+	case address < 0xe000:
+		bank = -2
+		if r.swapMode {
+			bank = r.prgBanks[0]
+		}
+	default:
+		bank = -1
+	}
+
+	return r.PRG[bankOffset(r.PRG, bank, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *IremG101) Write(address uint16, value byte) error {
+	switch address & 0xf000 {
+	case 0x8000:
+		r.prgBanks[0] = int(value & 0x1f)
+	case 0x9000:
+		if r.OneScreen {
+			return nil
+		}
+		if value&0x01 == 0 {
+			r.mirroring = mirrorVertical
+		} else {
+			r.mirroring = mirrorHorizontal
+		}
+		r.swapMode = value&0x02 != 0
+	case 0xa000:
+		r.prgBanks[1] = int(value & 0x1f)
+	case 0xb000:
+		r.chrBanks[address&0x07] = int(value)
+	default:
+		return fmt.Errorf("Irem G-101 has no register at $%04x", address)
+	}
+	return nil
+}
+
+// SaveState implements mapper.StateMapper
+func (r *IremG101) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *IremG101) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *IremG101) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Ints(r.prgBanks[:])
+	s.Bool(&r.swapMode)
+}
@@ -0,0 +1,126 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(65, AnySubmapper, Factory{
+		Name: "Irem H3001",
+		IRQ:  true,
+		New: func(header Header) (Mapper, error) {
+			return new(IremH3001), nil
+		},
+	})
+}
+
+// IremH3001 is Irem's H3001 mapper, with three switchable 8KB PRG banks, 1KB
+// CHR banks, mirroring control and a 16-bit CPU cycle IRQ counter.
+type IremH3001 struct {
+	ppuBanks
+
+	PRG []byte
+
+	prgBanks [3]int
+
+	irqEnabled bool
+	irqCounter uint16
+	irqReload  uint16
+	irqPending bool
+}
+
+// Init implements mapper.Init()
+func (r *IremH3001) Init(prg []byte) error {
+	if len(prg) < 0x4000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.prgBanks = [3]int{0, 1, -2}
+	r.mirroring = mirrorVertical
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *IremH3001) Read(address uint16) (byte, error) {
+	bank := -1
+	if slot := int(address-0x8000) / 0x2000; slot < 3 {
+		bank = r.prgBanks[slot]
+	}
+	return r.PRG[bankOffset(r.PRG, bank, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *IremH3001) Write(address uint16, value byte) error {
+	switch {
+	case address == 0x8000:
+		r.prgBanks[0] = int(value)
+	case address == 0x9001:
+		if value&0x80 == 0 {
+			r.mirroring = mirrorVertical
+		} else {
+			r.mirroring = mirrorHorizontal
+		}
+	case address == 0x9003:
+		r.irqEnabled = value&0x80 != 0
+		r.irqPending = false
+	case address == 0x9004:
+		r.irqCounter = r.irqReload
+		r.irqPending = false
+	case address == 0x9005:
+		r.irqReload = r.irqReload&0x00ff | uint16(value)<<8
+	case address == 0x9006:
+		r.irqReload = r.irqReload&0xff00 | uint16(value)
+	case address == 0xa000:
+		r.prgBanks[1] = int(value)
+	case address >= 0xb000 && address <= 0xb007:
+		r.chrBanks[address&0x07] = int(value)
+	case address == 0xc000:
+		r.prgBanks[2] = int(value)
+	default:
+		return fmt.Errorf("Irem H3001 has no register at $%04x", address)
+	}
+	return nil
+}
+
+// Clock counts the IRQ counter down, raising an IRQ and stopping when it
+// reaches zero.
+func (r *IremH3001) Clock() {
+	if !r.irqEnabled || r.irqCounter == 0 {
+		return
+	}
+	r.irqCounter--
+	if r.irqCounter == 0 {
+		r.irqPending = true
+		r.irqEnabled = false
+	}
+}
+
+// IRQ implements mapper.IRQMapper
+func (r *IremH3001) IRQ() bool {
+	return r.irqPending
+}
+
+// SaveState implements mapper.StateMapper
+func (r *IremH3001) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *IremH3001) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *IremH3001) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Ints(r.prgBanks[:])
+
+	s.Bool(&r.irqEnabled)
+	s.Uint16(&r.irqCounter)
+	s.Uint16(&r.irqReload)
+	s.Bool(&r.irqPending)
+}
@@ -0,0 +1,186 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(18, AnySubmapper, Factory{
+		Name: "Jaleco SS 88006",
+		IRQ:  true,
+		New: func(header Header) (Mapper, error) {
+			return new(JalecoSS88006), nil
+		},
+	})
+}
+
+// jalecoIRQMasks gives the bits of the IRQ counter that count, for the 16,
+// 12, 8 and 4-bit counter sizes selected by $F001.
+var jalecoIRQMasks = [4]uint16{0xffff, 0x0fff, 0x00ff, 0x000f}
+
+// JalecoSS88006 is Jaleco's SS 88006 mapper. Its three switchable 8KB PRG
+// banks and 1KB CHR banks are each written a nibble at a time, and it has
+// PRG RAM, mirroring control and a CPU cycle IRQ counter that can count with
+// 4 to 16 bits.
+//
+// The uPD7756 speech chip some boards drive through $F003 isn't emulated.
+type JalecoSS88006 struct {
+	ppuBanks
+
+	PRG []byte
+	RAM []byte
+
+	prgBanks    [3]int
+	ramEnabled  bool
+	ramWritable bool
+
+	irqReload  uint16
+	irqCounter uint16
+	irqMask    uint16
+	irqEnabled bool
+	irqPending bool
+}
+
+// Init implements mapper.Init()
+func (r *JalecoSS88006) Init(prg []byte) error {
+	if len(prg) < 0x2000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.RAM = make([]byte, 8192)
+	r.prgBanks = [3]int{0, 1, 2}
+	r.irqMask = jalecoIRQMasks[0]
+	r.mirroring = mirrorHorizontal
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *JalecoSS88006) Read(address uint16) (byte, error) {
+	bank := -1
+	if slot := int(address-0x8000) / 0x2000; slot < 3 {
+		bank = r.prgBanks[slot]
+	}
+	return r.PRG[bankOffset(r.PRG, bank, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *JalecoSS88006) Write(address uint16, value byte) error {
+	register := address & 0xf003
+
+	switch {
+	case register < 0x9002:
+		slot := int(register>>12-0x08)*2 + int(register&0x02)>>1
+		r.prgBanks[slot] = setNibble(r.prgBanks[slot], register, value)
+	case register == 0x9002:
+		r.ramEnabled = value&0x01 != 0
+		r.ramWritable = value&0x02 != 0
+	case register >= 0xa000 && register < 0xe000:
+		slot := int(register>>12-0x0a)*2 + int(register&0x02)>>1
+		r.chrBanks[slot] = setNibble(r.chrBanks[slot], register, value)
+	case register >= 0xe000 && register < 0xf000:
+		shift := uint(register&0x03) * 4
+		r.irqReload = r.irqReload&^(0x0f<<shift) | uint16(value&0x0f)<<shift
+	case register == 0xf000:
+		r.irqCounter = r.irqReload
+		r.irqPending = false
+	case register == 0xf001:
+		r.irqEnabled = value&0x01 != 0
+		switch {
+		case value&0x08 != 0:
+			r.irqMask = jalecoIRQMasks[3]
+		case value&0x04 != 0:
+			r.irqMask = jalecoIRQMasks[2]
+		case value&0x02 != 0:
+			r.irqMask = jalecoIRQMasks[1]
+		default:
+			r.irqMask = jalecoIRQMasks[0]
+		}
+		r.irqPending = false
+	case register == 0xf002:
+		r.mirroring = [4][4]int{mirrorHorizontal, mirrorVertical, mirrorSingleA, mirrorSingleB}[value&0x03]
+	case register == 0xf003:
+		// Speech chip control
+	default:
+		return fmt.Errorf("Jaleco SS 88006 has no register at $%04x", address)
+	}
+	return nil
+}
+
+// setNibble sets the low nibble of a bank from a write to an even register,
+// or the high nibble from an odd one.
+func setNibble(bank int, register uint16, value byte) int {
+	if register&0x01 == 0 {
+		return bank&^0x0f | int(value&0x0f)
+	}
+	return bank&0x0f | int(value&0x0f)<<4
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *JalecoSS88006) ReadLow(address uint16) (byte, error) {
+	if address < 0x6000 || !r.ramEnabled {
+		return 0, fmt.Errorf("Jaleco SS 88006 read from unmapped address $%04x", address)
+	}
+	return r.RAM[address&0x1fff], nil
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *JalecoSS88006) WriteLow(address uint16, value byte) error {
+	if address < 0x6000 || !r.ramEnabled || !r.ramWritable {
+		return fmt.Errorf("Jaleco SS 88006 write to unmapped address $%04x", address)
+	}
+	r.RAM[address&0x1fff] = value
+	return nil
+}
+
+// LoadTrainer implements mapper.TrainerMapper
+func (r *JalecoSS88006) LoadTrainer(trainer []byte) error {
+	copy(r.RAM[0x1000:], trainer)
+	return nil
+}
+
+// Clock counts down the bits of the IRQ counter selected by its size, leaving
+// the others alone, and raises an IRQ when they reach zero.
+func (r *JalecoSS88006) Clock() {
+	if !r.irqEnabled {
+		return
+	}
+	counter := (r.irqCounter - 1) & r.irqMask
+	r.irqCounter = r.irqCounter&^r.irqMask | counter
+	if counter == 0 {
+		r.irqPending = true
+	}
+}
+
+// IRQ implements mapper.IRQMapper
+func (r *JalecoSS88006) IRQ() bool {
+	return r.irqPending
+}
+
+// SaveState implements mapper.StateMapper
+func (r *JalecoSS88006) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *JalecoSS88006) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *JalecoSS88006) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Bytes(r.RAM)
+	s.Ints(r.prgBanks[:])
+	s.Bool(&r.ramEnabled)
+	s.Bool(&r.ramWritable)
+
+	s.Uint16(&r.irqReload)
+	s.Uint16(&r.irqCounter)
+	s.Uint16(&r.irqMask)
+	s.Bool(&r.irqEnabled)
+	s.Bool(&r.irqPending)
+}
@@ -2,9 +2,21 @@ package mapper
 
 import (
 	"errors"
-	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
 )
 
+func init() {
+	Register(1, AnySubmapper, Factory{
+		Name:   "MMC1",
+		Boards: []string{"SxROM"},
+		New: func(header Header) (Mapper, error) {
+			return new(MMC1), nil
+		},
+	})
+}
+
 type shiftregister struct {
 	val uint8
 }
@@ -42,7 +54,6 @@ func (r shiftregister) reset() {
 
 // Init initializes the ROM pages and control register
 func (r *MMC1) Init(prg []byte) error {
-	fmt.Println("Loaded mapper MMC1")
 	if len(prg) < 8192 {
 		return errors.New("Invalid PRG ROM data length")
 	}
@@ -94,3 +105,23 @@ func (r *MMC1) Write(address uint16, value byte) error {
 
 	return nil
 }
+
+// SaveState implements mapper.StateMapper
+func (r *MMC1) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *MMC1) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *MMC1) serialize(s *state.Stream) {
+	s.Int(&r.firstPage)
+	s.Int(&r.secondPage)
+	s.Uint8(&r.control)
+	s.Uint8(&r.load.val)
+	s.Uint8(&r.chr0)
+	s.Uint8(&r.chr1)
+	s.Uint8(&r.prg0)
+}
@@ -0,0 +1,575 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(5, AnySubmapper, Factory{
+		Name:   "MMC5",
+		Boards: []string{"ExROM"},
+		IRQ:    true,
+		Audio:  true,
+		New: func(header Header) (Mapper, error) {
+			return new(MMC5), nil
+		},
+	})
+}
+
+const mmc5ExRAMSize = 1024
+const mmc5PRGRAMSize = 65536
+
+// MMC5 ExRAM modes, selected by $5104.
+const (
+	exRAMNametable = iota
+	exRAMAttributes
+	exRAMReadWrite
+	exRAMReadOnly
+)
+
+// MMC5 is Nintendo's ExROM mapper, the most complex of the licensed boards.
+// It has four PRG and CHR banking modes, up to 64KB of PRG RAM, 1KB of ExRAM
+// usable as a nametable or as per-tile attributes, a fill-mode nametable, a
+// vertical split, a scanline IRQ driven by watching PPU fetches, a hardware
+// multiplier, and two pulse channels plus a PCM channel of expansion audio.
+type MMC5 struct {
+	PRG   []byte
+	CHR   []byte
+	RAM   []byte
+	ExRAM []byte
+	vram  []byte
+
+	prgMode    uint8
+	chrMode    uint8
+	ramProtect [2]uint8
+	exRAMMode  uint8
+	nametables uint8
+	fillTile   uint8
+	fillAttr   uint8
+	ramBank    uint8
+	prgBanks   [4]uint8
+	chrBanks   [12]uint16
+	chrUpper   uint8
+	lastChrB   bool
+
+	splitEnabled bool
+	splitRight   bool
+	splitTile    uint8
+	splitScroll  uint8
+	splitBank    uint8
+	splitY       int
+
+	irqCompare uint8
+	irqEnabled bool
+	irqPending bool
+	inFrame    bool
+	scanline   uint8
+
+	multiplicand uint8
+	multiplier   uint8
+
+	// PPU state snooped from the CPU bus
+	tallSprites bool
+	rendering   bool
+
+	// PPU fetch sniffing
+	lastFetch  uint16
+	matches    int
+	fetches    int
+	idleCycles int
+	exAttr     uint8
+
+	audio mmc5Audio
+}
+
+// Init sets the power-up banking state
+func (r *MMC5) Init(prg []byte) error {
+	if len(prg) < 8192 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.RAM = make([]byte, mmc5PRGRAMSize)
+	r.ExRAM = make([]byte, mmc5ExRAMSize)
+	r.prgMode = 3
+	r.chrMode = 3
+	r.prgBanks[3] = 0xff
+	return nil
+}
+
+// InitPPU implements mapper.PPUMapper
+func (r *MMC5) InitPPU(chr []byte, vram []byte) error {
+	if len(chr) < 1024 {
+		return errors.New("MMC5 requires CHR ROM")
+	}
+	r.CHR = chr
+	r.vram = vram
+	return nil
+}
+
+// Read returns the value mapped at an address in $8000-$FFFF
+func (r *MMC5) Read(address uint16) (byte, error) {
+	// Fetching the NMI vector tells the MMC5 that the frame has ended
+	if address == 0xfffa || address == 0xfffb {
+		r.inFrame = false
+		r.scanline = 0
+		r.irqPending = false
+	}
+
+	value := r.readPRG(address)
+	if address < 0xc000 {
+		r.audio.pcmRead(value)
+	}
+	return value, nil
+}
+
+// Write stores a value in PRG RAM mapped in $8000-$DFFF
+func (r *MMC5) Write(address uint16, value byte) error {
+	ram, offset := r.mapPRG(address)
+	if !ram {
+		return fmt.Errorf("MMC5 write to PRG ROM at $%04x", address)
+	}
+	if r.ramWritable() {
+		r.RAM[offset%len(r.RAM)] = value
+	}
+	return nil
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *MMC5) ReadLow(address uint16) (byte, error) {
+	switch {
+	case address >= 0x6000:
+		return r.RAM[r.ramOffset(r.ramBank, address)], nil
+	case address >= 0x5c00:
+		if r.exRAMMode < exRAMReadWrite {
+			return 0, fmt.Errorf("MMC5 ExRAM is write-only in mode %d", r.exRAMMode)
+		}
+		return r.ExRAM[address-0x5c00], nil
+	case address == 0x5204:
+		var status uint8
+		if r.irqPending {
+			status |= 0x80
+		}
+		if r.inFrame {
+			status |= 0x40
+		}
+		r.irqPending = false
+		return status, nil
+	case address == 0x5205:
+		return uint8(uint16(r.multiplicand) * uint16(r.multiplier)), nil
+	case address == 0x5206:
+		return uint8(uint16(r.multiplicand) * uint16(r.multiplier) >> 8), nil
+	case address == 0x5010 || address == 0x5015:
+		return r.audio.read(address), nil
+	}
+
+	return 0, fmt.Errorf("MMC5 read from unmapped address $%04x", address)
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *MMC5) WriteLow(address uint16, value byte) error {
+	switch {
+	case address >= 0x6000:
+		if r.ramWritable() {
+			r.RAM[r.ramOffset(r.ramBank, address)] = value
+		}
+	case address >= 0x5c00:
+		r.writeExRAM(address-0x5c00, value)
+	case address >= 0x5000 && address <= 0x5015:
+		r.audio.write(address, value)
+	case address == 0x5100:
+		r.prgMode = value & 0x03
+	case address == 0x5101:
+		r.chrMode = value & 0x03
+	case address == 0x5102 || address == 0x5103:
+		r.ramProtect[address-0x5102] = value & 0x03
+	case address == 0x5104:
+		r.exRAMMode = value & 0x03
+	case address == 0x5105:
+		r.nametables = value
+	case address == 0x5106:
+		r.fillTile = value
+	case address == 0x5107:
+		r.fillAttr = value & 0x03
+	case address == 0x5113:
+		r.ramBank = value & 0x07
+	case address >= 0x5114 && address <= 0x5117:
+		r.prgBanks[address-0x5114] = value
+	case address >= 0x5120 && address <= 0x512b:
+		r.chrBanks[address-0x5120] = uint16(value) | uint16(r.chrUpper)<<8
+		r.lastChrB = address >= 0x5128
+	case address == 0x5130:
+		r.chrUpper = value & 0x03
+	case address == 0x5200:
+		r.splitEnabled = value&0x80 != 0
+		r.splitRight = value&0x40 != 0
+		r.splitTile = value & 0x1f
+	case address == 0x5201:
+		r.splitScroll = value
+	case address == 0x5202:
+		r.splitBank = value
+	case address == 0x5203:
+		r.irqCompare = value
+	case address == 0x5204:
+		r.irqEnabled = value&0x80 != 0
+	case address == 0x5205:
+		r.multiplicand = value
+	case address == 0x5206:
+		r.multiplier = value
+	default:
+		return fmt.Errorf("MMC5 write to unmapped address $%04x", address)
+	}
+
+	return nil
+}
+
+// MonitorWrite watches PPUCTRL and PPUMASK, as the MMC5 needs to know the
+// sprite size and whether rendering is enabled.
+func (r *MMC5) MonitorWrite(address uint16, value byte) {
+	if address < 0x2000 || address >= 0x4000 {
+		return
+	}
+
+	switch address & 0x07 {
+	case 0:
+		r.tallSprites = value&0x20 != 0
+	case 1:
+		r.rendering = value&0x18 != 0
+		if !r.rendering {
+			r.inFrame = false
+		}
+	}
+}
+
+// LoadTrainer implements mapper.TrainerMapper
+func (r *MMC5) LoadTrainer(trainer []byte) error {
+	copy(r.RAM[r.ramOffset(r.ramBank, 0x7000):], trainer)
+	return nil
+}
+
+// Clock advances the audio and detects the PPU going idle at the end of a
+// frame, which it does when no PPU fetches happen for three CPU cycles.
+func (r *MMC5) Clock() {
+	r.audio.clock()
+
+	if r.idleCycles < 3 {
+		r.idleCycles++
+		if r.idleCycles == 3 {
+			r.inFrame = false
+		}
+	}
+}
+
+// IRQ reports whether the scanline or PCM IRQ is asserted
+func (r *MMC5) IRQ() bool {
+	return (r.irqPending && r.irqEnabled) || r.audio.irq()
+}
+
+// Output implements mapper.AudioMapper
+func (r *MMC5) Output() float32 {
+	return r.audio.output()
+}
+
+// ReadPPU returns the pattern or nametable byte the PPU is fetching, after
+// using the fetch to track the PPU's position on the scanline.
+func (r *MMC5) ReadPPU(address uint16) (byte, error) {
+	address &= 0x3fff
+	r.sniff(address)
+	fetch := r.fetches
+	r.fetches++
+
+	background := r.inFrame && (fetch < 128 || fetch >= 160)
+	split := background && r.inSplit(fetch)
+
+	if address >= 0x2000 {
+		if split {
+			return r.splitNametable(address, fetch), nil
+		}
+		return r.readNametable(address, background), nil
+	}
+
+	switch {
+	case split:
+		y := r.splitY
+		if fetch >= 160 {
+			y = (y + 1) % 240
+		}
+		offset := int(r.splitBank)*0x1000 + int(address&0x0ff8) + y&0x07
+		return r.CHR[offset%len(r.CHR)], nil
+	case background && r.exRAMMode == exRAMAttributes:
+		bank := int(r.exAttr&0x3f) | int(r.chrUpper)<<6
+		return r.CHR[(bank*0x1000+int(address&0x0fff))%len(r.CHR)], nil
+	}
+
+	// With 8x16 sprites the two sets are split between sprite and background
+	// fetches; otherwise the last set written applies to everything.
+	useB := r.lastChrB
+	if r.inFrame && r.tallSprites {
+		useB = background
+	}
+	return r.CHR[r.chrOffset(address, useB)], nil
+}
+
+// WritePPU writes to a nametable; CHR ROM can't be written
+func (r *MMC5) WritePPU(address uint16, value byte) error {
+	address &= 0x3fff
+	if address < 0x2000 {
+		return errors.New("MMC5 write to CHR ROM")
+	}
+
+	switch r.nametableSource(address) {
+	case 0, 1:
+		r.vram[int(r.nametableSource(address))*0x400+int(address&0x3ff)] = value
+	case 2:
+		r.writeExRAM(address&0x3ff, value)
+	}
+	return nil
+}
+
+// sniff detects the start of a scanline, which the MMC5 sees as three
+// consecutive fetches of the same nametable address: the two dummy fetches at
+// the end of a line and the first tile fetch of the next.
+func (r *MMC5) sniff(address uint16) {
+	r.idleCycles = 0
+
+	if address >= 0x2000 && address < 0x3000 && address == r.lastFetch {
+		r.matches++
+		if r.matches == 2 {
+			r.newScanline()
+		}
+	} else {
+		r.matches = 0
+	}
+	r.lastFetch = address
+}
+
+func (r *MMC5) newScanline() {
+	r.fetches = 0
+	if !r.inFrame {
+		r.inFrame = true
+		r.scanline = 0
+		r.splitY = int(r.splitScroll) % 240
+		return
+	}
+
+	r.scanline++
+	r.splitY = (r.splitY + 1) % 240
+	if r.scanline == r.irqCompare {
+		r.irqPending = true
+	}
+}
+
+// inSplit reports whether a background fetch belongs to a tile in the split
+// region. The first 128 fetches of a line are tiles 2-33, the last eight are
+// tiles 0 and 1 of the next line.
+func (r *MMC5) inSplit(fetch int) bool {
+	if !r.splitEnabled || r.exRAMMode >= exRAMReadWrite {
+		return false
+	}
+
+	tile := uint8(fetch/4 + 2)
+	if fetch >= 160 {
+		tile = uint8((fetch - 160) / 4)
+	}
+	if r.splitRight {
+		return tile >= r.splitTile
+	}
+	return tile < r.splitTile
+}
+
+func (r *MMC5) splitNametable(address uint16, fetch int) byte {
+	y := r.splitY
+	tile := fetch/4 + 2
+	if fetch >= 160 {
+		y = (y + 1) % 240
+		tile = (fetch - 160) / 4
+	}
+	tile &= 0x1f
+
+	if address&0x3ff < 0x3c0 {
+		return r.ExRAM[(y/8)*32+tile]
+	}
+
+	attr := r.ExRAM[0x3c0+(y/32)*8+tile/4]
+	shift := uint((y/16)&1)*4 + uint((tile/2)&1)*2
+	return ((attr >> shift) & 0x03) * 0x55
+}
+
+func (r *MMC5) readNametable(address uint16, background bool) byte {
+	offset := address & 0x3ff
+	attribute := offset >= 0x3c0
+
+	if background && r.exRAMMode == exRAMAttributes {
+		if !attribute {
+			r.exAttr = r.ExRAM[offset]
+		} else {
+			return (r.exAttr >> 6) * 0x55
+		}
+	}
+
+	switch source := r.nametableSource(address); source {
+	case 0, 1:
+		return r.vram[int(source)*0x400+int(offset)]
+	case 2:
+		if r.exRAMMode < exRAMReadWrite {
+			return r.ExRAM[offset]
+		}
+		return 0
+	default:
+		if attribute {
+			return r.fillAttr * 0x55
+		}
+		return r.fillTile
+	}
+}
+
+func (r *MMC5) nametableSource(address uint16) uint8 {
+	nametable := (address >> 10) & 0x03
+	return (r.nametables >> (nametable * 2)) & 0x03
+}
+
+func (r *MMC5) writeExRAM(offset uint16, value byte) {
+	// In the nametable modes ExRAM is only writable while rendering
+	if r.exRAMMode == exRAMReadOnly {
+		return
+	}
+	if r.exRAMMode < exRAMReadWrite && !r.inFrame {
+		value = 0
+	}
+	r.ExRAM[offset] = value
+}
+
+func (r *MMC5) readPRG(address uint16) byte {
+	ram, offset := r.mapPRG(address)
+	if ram {
+		return r.RAM[offset%len(r.RAM)]
+	}
+	return r.PRG[offset%len(r.PRG)]
+}
+
+// mapPRG resolves an address in $8000-$FFFF to an offset in PRG ROM or RAM.
+// Bank registers count in 8KB units, ignoring their low bits for larger
+// windows; bit 7 selects ROM, and $5117 always maps ROM.
+func (r *MMC5) mapPRG(address uint16) (bool, int) {
+	var reg uint8
+	var size uint16
+
+	switch r.prgMode {
+	case 0:
+		reg, size = r.prgBanks[3]|0x80, 0x8000
+	case 1:
+		if address < 0xc000 {
+			reg, size = r.prgBanks[1], 0x4000
+		} else {
+			reg, size = r.prgBanks[3]|0x80, 0x4000
+		}
+	case 2:
+		switch {
+		case address < 0xc000:
+			reg, size = r.prgBanks[1], 0x4000
+		case address < 0xe000:
+			reg, size = r.prgBanks[2], 0x2000
+		default:
+			reg, size = r.prgBanks[3]|0x80, 0x2000
+		}
+	default:
+		reg, size = r.prgBanks[(address-0x8000)/0x2000], 0x2000
+		if address >= 0xe000 {
+			reg |= 0x80
+		}
+	}
+
+	bank := int(reg&0x7f) &^ (int(size/0x2000) - 1)
+	if reg&0x80 == 0 {
+		return true, (bank&0x07)*0x2000 + int(address&(size-1))
+	}
+	return false, bank*0x2000 + int(address&(size-1))
+}
+
+func (r *MMC5) ramOffset(bank uint8, address uint16) int {
+	return (int(bank&0x07)*0x2000 + int(address&0x1fff)) % len(r.RAM)
+}
+
+func (r *MMC5) ramWritable() bool {
+	return r.ramProtect[0] == 0x02 && r.ramProtect[1] == 0x01
+}
+
+// chrOffset maps a pattern table address through sprite set A ($5120-$5127)
+// or background set B ($5128-$512B, mirrored across both pattern tables).
+func (r *MMC5) chrOffset(address uint16, useB bool) int {
+	var reg int
+	size := 0x2000 >> r.chrMode
+	slot := int(address) / size
+
+	switch r.chrMode {
+	case 0:
+		reg = 7
+	case 1:
+		reg = slot*4 + 3
+	case 2:
+		reg = slot*2 + 1
+	default:
+		reg = slot
+	}
+	if useB {
+		reg = 8 + reg&0x03
+	}
+
+	bank := int(r.chrBanks[reg])
+	return (bank*size + int(address)%size) % len(r.CHR)
+}
+
+// SaveState implements mapper.StateMapper
+func (r *MMC5) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *MMC5) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *MMC5) serialize(s *state.Stream) {
+	s.Bytes(r.RAM)
+	s.Bytes(r.ExRAM)
+
+	for _, register := range []*uint8{&r.prgMode, &r.chrMode, &r.ramProtect[0], &r.ramProtect[1], &r.exRAMMode, &r.nametables, &r.fillTile, &r.fillAttr, &r.ramBank, &r.chrUpper} {
+		s.Uint8(register)
+	}
+	serializeBanks(s, r.prgBanks[:])
+	for i := range r.chrBanks {
+		s.Uint16(&r.chrBanks[i])
+	}
+	s.Bool(&r.lastChrB)
+
+	s.Bool(&r.splitEnabled)
+	s.Bool(&r.splitRight)
+	s.Uint8(&r.splitTile)
+	s.Uint8(&r.splitScroll)
+	s.Uint8(&r.splitBank)
+	s.Int(&r.splitY)
+
+	s.Uint8(&r.irqCompare)
+	s.Bool(&r.irqEnabled)
+	s.Bool(&r.irqPending)
+	s.Bool(&r.inFrame)
+	s.Uint8(&r.scanline)
+
+	s.Uint8(&r.multiplicand)
+	s.Uint8(&r.multiplier)
+
+	s.Bool(&r.tallSprites)
+	s.Bool(&r.rendering)
+
+	s.Uint16(&r.lastFetch)
+	s.Int(&r.matches)
+	s.Int(&r.fetches)
+	s.Int(&r.idleCycles)
+	s.Uint8(&r.exAttr)
+
+	r.audio.serialize(s)
+}
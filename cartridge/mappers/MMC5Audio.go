@@ -0,0 +1,227 @@
+package mapper
+
+import "github.com/makononov/NESGo/state"
+
+// The MMC5 clocks its envelopes and length counters at a fixed 240Hz rather
+// than from a frame sequencer like the 2A03's.
+const mmc5FrameCycles = 7457
+
+var lengthTable = [32]uint8{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+var dutyTable = [4][8]uint8{
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+// mmc5Pulse is a 2A03 pulse channel without the sweep unit
+type mmc5Pulse struct {
+	enabled  bool
+	duty     uint8
+	step     uint8
+	timer    uint16
+	period   uint16
+	length   uint8
+	halt     bool
+	constant bool
+	volume   uint8
+
+	envelopeStart   bool
+	envelopeDivider uint8
+	envelopeDecay   uint8
+}
+
+func (p *mmc5Pulse) write(register uint16, value uint8) {
+	switch register {
+	case 0:
+		p.duty = value >> 6
+		p.halt = value&0x20 != 0
+		p.constant = value&0x10 != 0
+		p.volume = value & 0x0f
+	case 2:
+		p.period = p.period&0x0700 | uint16(value)
+	case 3:
+		p.period = p.period&0x00ff | uint16(value&0x07)<<8
+		if p.enabled {
+			p.length = lengthTable[value>>3]
+		}
+		p.step = 0
+		p.envelopeStart = true
+	}
+}
+
+func (p *mmc5Pulse) setEnabled(enabled bool) {
+	p.enabled = enabled
+	if !enabled {
+		p.length = 0
+	}
+}
+
+func (p *mmc5Pulse) clockTimer() {
+	if p.timer == 0 {
+		p.timer = p.period
+		p.step = (p.step + 1) & 0x07
+	} else {
+		p.timer--
+	}
+}
+
+func (p *mmc5Pulse) clockFrame() {
+	if p.envelopeStart {
+		p.envelopeStart = false
+		p.envelopeDecay = 15
+		p.envelopeDivider = p.volume
+	} else if p.envelopeDivider == 0 {
+		p.envelopeDivider = p.volume
+		if p.envelopeDecay > 0 {
+			p.envelopeDecay--
+		} else if p.halt {
+			p.envelopeDecay = 15
+		}
+	} else {
+		p.envelopeDivider--
+	}
+
+	if !p.halt && p.length > 0 {
+		p.length--
+	}
+}
+
+func (p *mmc5Pulse) output() uint8 {
+	if p.length == 0 || dutyTable[p.duty][p.step] == 0 {
+		return 0
+	}
+	if p.constant {
+		return p.volume
+	}
+	return p.envelopeDecay
+}
+
+// mmc5Audio holds the MMC5's two pulse channels and its 8-bit PCM channel
+type mmc5Audio struct {
+	pulse [2]mmc5Pulse
+
+	pcm         uint8
+	pcmReadMode bool
+	pcmIRQ      bool
+	pcmPending  bool
+
+	cycles int
+}
+
+func (a *mmc5Audio) read(address uint16) uint8 {
+	var value uint8
+	switch address {
+	case 0x5010:
+		if a.pcmPending && a.pcmIRQ {
+			value |= 0x80
+		}
+		if a.pcmReadMode {
+			value |= 0x01
+		}
+		a.pcmPending = false
+	case 0x5015:
+		for i := range a.pulse {
+			if a.pulse[i].length > 0 {
+				value |= 1 << uint(i)
+			}
+		}
+	}
+	return value
+}
+
+func (a *mmc5Audio) write(address uint16, value uint8) {
+	switch {
+	case address < 0x5004:
+		a.pulse[0].write(address-0x5000, value)
+	case address < 0x5008:
+		a.pulse[1].write(address-0x5004, value)
+	case address == 0x5010:
+		a.pcmReadMode = value&0x01 != 0
+		a.pcmIRQ = value&0x80 != 0
+	case address == 0x5011:
+		// Writing zero has no effect, it's reserved for the IRQ in read mode
+		if !a.pcmReadMode && value != 0 {
+			a.pcm = value
+		}
+	case address == 0x5015:
+		a.pulse[0].setEnabled(value&0x01 != 0)
+		a.pulse[1].setEnabled(value&0x02 != 0)
+	}
+}
+
+// pcmRead latches a byte the CPU read from $8000-$BFFF into the PCM channel
+// when it is in read mode. A zero raises the PCM IRQ instead.
+func (a *mmc5Audio) pcmRead(value uint8) {
+	if !a.pcmReadMode {
+		return
+	}
+	if value == 0 {
+		a.pcmPending = true
+		return
+	}
+	a.pcm = value
+}
+
+func (a *mmc5Audio) irq() bool {
+	return a.pcmIRQ && a.pcmPending
+}
+
+func (a *mmc5Audio) clock() {
+	a.cycles++
+	if a.cycles%2 == 0 {
+		a.pulse[0].clockTimer()
+		a.pulse[1].clockTimer()
+	}
+	if a.cycles >= mmc5FrameCycles {
+		a.cycles = 0
+		a.pulse[0].clockFrame()
+		a.pulse[1].clockFrame()
+	}
+}
+
+// output mixes the channels with the same nonlinear curves the 2A03 uses
+// for its pulse and DMC channels, which is how they sound at the same volume
+// as the internal ones.
+func (a *mmc5Audio) output() float32 {
+	var out float32
+	if pulses := float32(a.pulse[0].output()) + float32(a.pulse[1].output()); pulses > 0 {
+		out += 95.88 / (8128/pulses + 100)
+	}
+	if a.pcm > 1 {
+		out += 159.79 / (1/(float32(a.pcm>>1)/22638) + 100)
+	}
+	return out
+}
+
+func (p *mmc5Pulse) serialize(s *state.Stream) {
+	s.Bool(&p.enabled)
+	s.Uint8(&p.duty)
+	s.Uint8(&p.step)
+	s.Uint16(&p.timer)
+	s.Uint16(&p.period)
+	s.Uint8(&p.length)
+	s.Bool(&p.halt)
+	s.Bool(&p.constant)
+	s.Uint8(&p.volume)
+
+	s.Bool(&p.envelopeStart)
+	s.Uint8(&p.envelopeDivider)
+	s.Uint8(&p.envelopeDecay)
+}
+
+func (a *mmc5Audio) serialize(s *state.Stream) {
+	a.pulse[0].serialize(s)
+	a.pulse[1].serialize(s)
+
+	s.Uint8(&a.pcm)
+	s.Bool(&a.pcmReadMode)
+	s.Bool(&a.pcmIRQ)
+	s.Bool(&a.pcmPending)
+
+	s.Int(&a.cycles)
+}
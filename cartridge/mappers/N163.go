@@ -0,0 +1,248 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(19, AnySubmapper, Factory{
+		Name:   "Namco 163",
+		Boards: []string{"Namco 129", "Namco 163"},
+		IRQ:    true,
+		Audio:  true,
+		New: func(header Header) (Mapper, error) {
+			return new(N163), nil
+		},
+	})
+}
+
+// N163 is Namco's 163 mapper, with three switchable 8KB PRG banks, 1KB CHR
+// banks that can select nametable RAM instead of CHR ROM, nametables that
+// can come from CHR ROM, a 15-bit CPU cycle IRQ counter, and 128 bytes of
+// sound RAM driving up to eight wavetable channels.
+type N163 struct {
+	PRG  []byte
+	CHR  []byte
+	RAM  []byte
+	vram []byte
+
+	prgBanks   [3]int
+	chrBanks   [12]uint8
+	chrRAMOff  [2]bool
+	ramProtect uint8
+
+	irqCounter uint16
+	irqEnabled bool
+	irqPending bool
+
+	audio n163Audio
+}
+
+// Init implements mapper.Init()
+func (r *N163) Init(prg []byte) error {
+	if len(prg) < 0x2000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.RAM = make([]byte, 8192)
+	r.prgBanks = [3]int{0, 1, 2}
+	r.audio.init()
+	return nil
+}
+
+// InitPPU implements mapper.PPUMapper
+func (r *N163) InitPPU(chr []byte, vram []byte) error {
+	if len(chr) == 0 {
+		return errors.New("Namco 163 requires CHR ROM")
+	}
+	r.CHR = chr
+	r.vram = vram
+	for i := range r.chrBanks {
+		r.chrBanks[i] = uint8(i)
+	}
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *N163) Read(address uint16) (byte, error) {
+	bank := -1
+	if slot := int(address-0x8000) / 0x2000; slot < 3 {
+		bank = r.prgBanks[slot]
+	}
+	return r.PRG[bankOffset(r.PRG, bank, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *N163) Write(address uint16, value byte) error {
+	switch {
+	case address < 0xe000:
+		r.chrBanks[(address-0x8000)/0x800] = value
+	case address < 0xe800:
+		r.prgBanks[0] = int(value & 0x3f)
+		r.audio.disabled = value&0x40 != 0
+	case address < 0xf000:
+		r.prgBanks[1] = int(value & 0x3f)
+		r.chrRAMOff[0] = value&0x40 != 0
+		r.chrRAMOff[1] = value&0x80 != 0
+	case address < 0xf800:
+		r.prgBanks[2] = int(value & 0x3f)
+	default:
+		r.ramProtect = value
+		r.audio.setAddress(value)
+	}
+	return nil
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *N163) ReadLow(address uint16) (byte, error) {
+	switch {
+	case address >= 0x6000:
+		return r.RAM[address-0x6000], nil
+	case address >= 0x5800:
+		value := uint8(r.irqCounter >> 8)
+		if r.irqEnabled {
+			value |= 0x80
+		}
+		return value, nil
+	case address >= 0x5000:
+		return uint8(r.irqCounter), nil
+	case address >= 0x4800:
+		return r.audio.readData(), nil
+	}
+	return 0, fmt.Errorf("Namco 163 read from unmapped address $%04x", address)
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *N163) WriteLow(address uint16, value byte) error {
+	switch {
+	case address >= 0x6000:
+		if r.ramWritable(address) {
+			r.RAM[address-0x6000] = value
+		}
+	case address >= 0x5800:
+		r.irqCounter = r.irqCounter&0x00ff | uint16(value&0x7f)<<8
+		r.irqEnabled = value&0x80 != 0
+		r.irqPending = false
+	case address >= 0x5000:
+		r.irqCounter = r.irqCounter&0x7f00 | uint16(value)
+		r.irqPending = false
+	case address >= 0x4800:
+		r.audio.writeData(value)
+	default:
+		return fmt.Errorf("Namco 163 write to unmapped address $%04x", address)
+	}
+	return nil
+}
+
+// LoadTrainer implements mapper.TrainerMapper
+func (r *N163) LoadTrainer(trainer []byte) error {
+	copy(r.RAM[0x1000:], trainer)
+	return nil
+}
+
+// Clock counts the IRQ counter up towards $7FFF, where it raises an IRQ and
+// stops, and advances the sound.
+func (r *N163) Clock() {
+	if r.irqEnabled && r.irqCounter < 0x7fff {
+		r.irqCounter++
+		if r.irqCounter == 0x7fff {
+			r.irqPending = true
+		}
+	}
+	r.audio.clock()
+}
+
+// IRQ implements mapper.IRQMapper
+func (r *N163) IRQ() bool {
+	return r.irqPending
+}
+
+// Output implements mapper.AudioMapper
+func (r *N163) Output() float32 {
+	return r.audio.output()
+}
+
+// SetMultiplexing chooses between mixing the sound channels as an average,
+// and outputting them one at a time as the chip does, with the audible
+// whine that causes when many channels are enabled.
+func (r *N163) SetMultiplexing(multiplexed bool) {
+	r.audio.multiplexed = multiplexed
+}
+
+// ReadPPU implements mapper.PPUMapper
+func (r *N163) ReadPPU(address uint16) (byte, error) {
+	address &= 0x3fff
+	ram, offset := r.mapPPU(address)
+	if ram {
+		return r.vram[offset], nil
+	}
+	return r.CHR[offset], nil
+}
+
+// WritePPU implements mapper.PPUMapper
+func (r *N163) WritePPU(address uint16, value byte) error {
+	address &= 0x3fff
+	ram, offset := r.mapPPU(address)
+	if !ram {
+		return fmt.Errorf("Namco 163 write to CHR ROM at $%04x", address)
+	}
+	r.vram[offset] = value
+	return nil
+}
+
+// mapPPU resolves a PPU address through its 1KB bank register. Bank values
+// $E0 and above select a page of nametable RAM instead of CHR ROM, which the
+// pattern tables only allow while $E800 hasn't disabled it for them.
+func (r *N163) mapPPU(address uint16) (bool, int) {
+	slot := int(address/0x400) % 12
+	bank := r.chrBanks[slot]
+
+	ram := bank >= 0xe0
+	if slot < 8 && r.chrRAMOff[slot/4] {
+		ram = false
+	}
+
+	if ram {
+		return true, (int(bank&0x01)*0x400 + int(address&0x3ff)) % len(r.vram)
+	}
+	return false, bankOffset(r.CHR, int(bank), 0x400, address)
+}
+
+// ramWritable checks $F800's write protection. The upper nibble must be 4,
+// and each of the low four bits protects a 2KB quarter of PRG RAM.
+func (r *N163) ramWritable(address uint16) bool {
+	if r.ramProtect&0xf0 != 0x40 {
+		return false
+	}
+	return r.ramProtect&(1<<((address-0x6000)/0x800)) == 0
+}
+
+// SaveState implements mapper.StateMapper
+func (r *N163) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *N163) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *N163) serialize(s *state.Stream) {
+	s.Bytes(r.RAM)
+	s.Ints(r.prgBanks[:])
+	serializeBanks(s, r.chrBanks[:])
+	s.Bool(&r.chrRAMOff[0])
+	s.Bool(&r.chrRAMOff[1])
+	s.Uint8(&r.ramProtect)
+
+	s.Uint16(&r.irqCounter)
+	s.Bool(&r.irqEnabled)
+	s.Bool(&r.irqPending)
+
+	r.audio.serialize(s)
+}
@@ -0,0 +1,130 @@
+package mapper
+
+import "github.com/makononov/NESGo/state"
+
+// The N163 updates one channel every 15 CPU cycles, taking turns between the
+// enabled channels.
+const n163ChannelCycles = 15
+
+// n163Audio is the N163's wavetable synthesizer. Its 128 bytes of sound RAM
+// hold 4-bit samples, and up to eight channel register sets at $40-$7F, with
+// channel 7 at the top. The number of enabled channels is set in $7F.
+type n163Audio struct {
+	ram         [128]uint8
+	address     uint8
+	increment   bool
+	disabled    bool
+	multiplexed bool
+
+	cycles  int
+	channel int
+	outputs [8]int
+}
+
+func (a *n163Audio) init() {
+	a.channel = 7
+}
+
+func (a *n163Audio) setAddress(value uint8) {
+	a.address = value & 0x7f
+	a.increment = value&0x80 != 0
+}
+
+func (a *n163Audio) readData() uint8 {
+	value := a.ram[a.address]
+	a.advance()
+	return value
+}
+
+func (a *n163Audio) writeData(value uint8) {
+	a.ram[a.address] = value
+	a.advance()
+}
+
+func (a *n163Audio) advance() {
+	if a.increment {
+		a.address = (a.address + 1) & 0x7f
+	}
+}
+
+// channels returns how many channels are enabled, counting down from 7
+func (a *n163Audio) channels() int {
+	return int(a.ram[0x7f]>>4&0x07) + 1
+}
+
+func (a *n163Audio) clock() {
+	if a.disabled {
+		return
+	}
+
+	a.cycles++
+	if a.cycles < n163ChannelCycles {
+		return
+	}
+	a.cycles = 0
+
+	a.updateChannel(a.channel)
+	a.channel--
+	if a.channel < 8-a.channels() {
+		a.channel = 7
+	}
+}
+
+// updateChannel advances a channel's 24-bit phase by its 18-bit frequency,
+// wrapping at the wave length, and looks up its next sample.
+func (a *n163Audio) updateChannel(channel int) {
+	registers := a.ram[0x40+channel*8 : 0x48+channel*8]
+
+	frequency := uint32(registers[0]) | uint32(registers[2])<<8 | uint32(registers[4]&0x03)<<16
+	phase := uint32(registers[1]) | uint32(registers[3])<<8 | uint32(registers[5])<<16
+	length := (256 - uint32(registers[4]&0xfc)) << 16
+
+	phase = (phase + frequency) % length
+	registers[1] = uint8(phase)
+	registers[3] = uint8(phase >> 8)
+	registers[5] = uint8(phase >> 16)
+
+	index := (uint32(registers[6]) + phase>>16) & 0xff
+	sample := a.ram[index>>1]
+	if index&0x01 == 0 {
+		sample &= 0x0f
+	} else {
+		sample >>= 4
+	}
+
+	a.outputs[channel] = (int(sample) - 8) * int(registers[7]&0x0f)
+}
+
+// output either averages the enabled channels, or, when multiplexed, gives
+// whichever channel the chip is currently outputting. A channel at full
+// volume is about as loud as a 2A03 pulse channel at full volume.
+func (a *n163Audio) output() float32 {
+	if a.disabled {
+		return 0
+	}
+
+	var level float32
+	if a.multiplexed {
+		level = float32(a.outputs[a.channel])
+	} else {
+		channels := a.channels()
+		for channel := 8 - channels; channel < 8; channel++ {
+			level += float32(a.outputs[channel])
+		}
+		level /= float32(channels)
+	}
+
+	return level / 120 * 95.88 / (8128/15 + 100)
+}
+
+func (a *n163Audio) serialize(s *state.Stream) {
+	s.Bytes(a.ram[:])
+	s.Uint8(&a.address)
+	s.Bool(&a.increment)
+	s.Bool(&a.disabled)
+	s.Bool(&a.multiplexed)
+
+	s.Int(&a.cycles)
+	s.Int(&a.channel)
+	s.Ints(a.outputs[:])
+}
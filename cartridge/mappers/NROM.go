@@ -2,8 +2,21 @@ package mapper
 
 import (
 	"errors"
+	"io"
+
+	"github.com/makononov/NESGo/state"
 )
 
+func init() {
+	Register(0, AnySubmapper, Factory{
+		Name:   "NROM",
+		Boards: []string{"NROM-128", "NROM-256"},
+		New: func(header Header) (Mapper, error) {
+			return new(NROM), nil
+		},
+	})
+}
+
 // NROM is a simple ROM mapper with no logic controller.
 type NROM struct {
 	PRG   []byte
@@ -33,3 +46,17 @@ func (r *NROM) Read(address uint16) (byte, error) {
 func (r *NROM) Write(address uint16, value byte) error {
 	return errors.New("NROM does not support writing")
 }
+
+// SaveState implements mapper.StateMapper
+func (r *NROM) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *NROM) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *NROM) serialize(s *state.Stream) {
+	// NROM has no registers
+}
@@ -0,0 +1,157 @@
+package mapper
+
+import (
+	"errors"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(206, AnySubmapper, Factory{
+		Name:   "Namco 108",
+		Boards: []string{"DxROM"},
+		New: func(header Header) (Mapper, error) {
+			return &Namco108{Vertical: header.Vertical}, nil
+		},
+	})
+	Register(88, AnySubmapper, Factory{
+		Name: "Namco 3433",
+		New: func(header Header) (Mapper, error) {
+			return &Namco108{Vertical: header.Vertical, SplitCHR: true}, nil
+		},
+	})
+	Register(154, AnySubmapper, Factory{
+		Name: "Namco 3453",
+		New: func(header Header) (Mapper, error) {
+			return &Namco108{SplitCHR: true, OneScreen: true}, nil
+		},
+	})
+}
+
+// Namco108 is Namco's 108 mapper, also known as DxROM, along with its 3433
+// and 3453 variants. Like a cut-down MMC3, it has a bank select register at
+// even addresses in $8000-$9FFF and bank data at odd ones, with two 8KB PRG
+// banks, two 2KB and four 1KB CHR banks, and no IRQ. Mirroring is hardwired
+// except on the 3453, so the variant and mirroring must be set before Init.
+type Namco108 struct {
+	ppuBanks
+
+	PRG []byte
+
+	// Vertical is the board's hardwired mirroring
+	Vertical bool
+
+	// SplitCHR ties CHR A16 to PPU A12, as on the 3433 and 3453, so the
+	// 2KB banks come from the first 64KB of CHR and the 1KB banks from the
+	// second.
+	SplitCHR bool
+
+	// OneScreen lets bit 6 of any write to $8000-$FFFF pick a one-screen
+	// page, as on the 3453.
+	OneScreen bool
+
+	register uint8
+	prgBanks [2]int
+	chrRegs  [6]int
+}
+
+// Init implements mapper.Init()
+func (r *Namco108) Init(prg []byte) error {
+	if len(prg) < 0x4000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.prgBanks = [2]int{0, 1}
+	switch {
+	case r.OneScreen:
+		r.mirroring = mirrorSingleA
+	case r.Vertical:
+		r.mirroring = mirrorVertical
+	default:
+		r.mirroring = mirrorHorizontal
+	}
+	return nil
+}
+
+// InitPPU implements mapper.PPUMapper
+func (r *Namco108) InitPPU(chr []byte, vram []byte) error {
+	if err := r.ppuBanks.InitPPU(chr, vram); err != nil {
+		return err
+	}
+	r.chrRegs = [6]int{0, 2, 4, 5, 6, 7}
+	r.updateCHR()
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *Namco108) Read(address uint16) (byte, error) {
+	bank := -1
+	switch {
+	case address < 0xc000:
+		bank = r.prgBanks[(address-0x8000)/0x2000]
+	case address < 0xe000:
+		bank = -2
+	}
+	return r.PRG[bankOffset(r.PRG, bank, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *Namco108) Write(address uint16, value byte) error {
+	if r.OneScreen {
+		if value&0x40 == 0 {
+			r.mirroring = mirrorSingleA
+		} else {
+			r.mirroring = mirrorSingleB
+		}
+	}
+
+	switch {
+	case address >= 0xa000:
+		return nil
+	case address&0x01 == 0:
+		r.register = value & 0x07
+	case r.register < 6:
+		r.chrRegs[r.register] = int(value & 0x3f)
+		r.updateCHR()
+	default:
+		r.prgBanks[r.register-6] = int(value & 0x0f)
+	}
+	return nil
+}
+
+// updateCHR lays R0 and R1 out as 2KB banks at $0000, ignoring their low
+// bits, and R2-R5 as 1KB banks at $1000.
+func (r *Namco108) updateCHR() {
+	for i := 0; i < 4; i++ {
+		r.chrBanks[i] = r.chrRegs[i/2]&^1 | i&1
+		r.chrBanks[4+i] = r.chrRegs[2+i]
+	}
+
+	if r.SplitCHR {
+		for i := range r.chrBanks {
+			r.chrBanks[i] &= 0x3f
+			if i >= 4 {
+				r.chrBanks[i] |= 0x40
+			}
+		}
+	}
+}
+
+// SaveState implements mapper.StateMapper
+func (r *Namco108) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *Namco108) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *Namco108) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Uint8(&r.register)
+	s.Ints(r.prgBanks[:])
+	s.Ints(r.chrRegs[:])
+}
@@ -0,0 +1,217 @@
+package mapper
+
+import (
+	"math"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// The 5B's units all step every 16 CPU cycles, except the envelope which
+// steps twice as often through its 32 levels.
+const sunsoft5BDivider = 16
+
+// sunsoft5BVolume holds the amplitude of each of the 5B's 32 output levels.
+// Levels are 1.5dB apart and level 0 is silent.
+var sunsoft5BVolume = func() [32]float32 {
+	var table [32]float32
+	for level := 1; level < 32; level++ {
+		table[level] = float32(math.Pow(10, float64(level-31)*1.5/20))
+	}
+	return table
+}()
+
+type sunsoft5BChannel struct {
+	period   uint16
+	counter  uint16
+	tone     bool
+	volume   uint8
+	envelope bool
+}
+
+// sunsoft5B is the YM2149F-derived sound chip in the Sunsoft 5B: three square
+// wave channels, each of which can be mixed with a shared noise generator and
+// use a shared envelope instead of a fixed volume. Registers are selected at
+// $C000 and written at $E000 like the AY-3-8910's.
+type sunsoft5B struct {
+	register uint8
+	channels [3]sunsoft5BChannel
+
+	toneDisabled  uint8
+	noiseDisabled uint8
+
+	noisePeriod  uint8
+	noiseCounter uint8
+	noiseShift   uint32
+
+	envelopePeriod  uint16
+	envelopeCounter uint16
+	envelopeShape   uint8
+	envelopeStep    uint8
+	envelopeHolding bool
+
+	cycles int
+}
+
+func (a *sunsoft5B) selectRegister(value uint8) {
+	a.register = value & 0x0f
+}
+
+func (a *sunsoft5B) write(value uint8) {
+	switch register := a.register; {
+	case register < 0x06:
+		channel := &a.channels[register/2]
+		if register&0x01 == 0 {
+			channel.period = channel.period&0x0f00 | uint16(value)
+		} else {
+			channel.period = channel.period&0x00ff | uint16(value&0x0f)<<8
+		}
+	case register == 0x06:
+		a.noisePeriod = value & 0x1f
+	case register == 0x07:
+		a.toneDisabled = value & 0x07
+		a.noiseDisabled = (value >> 3) & 0x07
+	case register < 0x0b:
+		channel := &a.channels[register-0x08]
+		channel.volume = value & 0x0f
+		channel.envelope = value&0x10 != 0
+	case register == 0x0b:
+		a.envelopePeriod = a.envelopePeriod&0xff00 | uint16(value)
+	case register == 0x0c:
+		a.envelopePeriod = a.envelopePeriod&0x00ff | uint16(value)<<8
+	case register == 0x0d:
+		a.envelopeShape = value & 0x0f
+		a.envelopeStep = 0
+		a.envelopeCounter = 0
+		a.envelopeHolding = false
+	}
+}
+
+func (a *sunsoft5B) clock() {
+	a.cycles++
+	if a.cycles%(sunsoft5BDivider/2) == 0 {
+		a.clockEnvelope()
+	}
+	if a.cycles < sunsoft5BDivider {
+		return
+	}
+	a.cycles = 0
+
+	for i := range a.channels {
+		channel := &a.channels[i]
+		channel.counter++
+		if channel.counter >= channel.period {
+			channel.counter = 0
+			channel.tone = !channel.tone
+		}
+	}
+
+	// The noise period counts in units of two tone steps
+	a.noiseCounter++
+	if a.noiseCounter >= a.noisePeriod*2 {
+		a.noiseCounter = 0
+		if a.noiseShift == 0 {
+			a.noiseShift = 1
+		}
+		feedback := (a.noiseShift ^ a.noiseShift>>3) & 0x01
+		a.noiseShift = a.noiseShift>>1 | feedback<<16
+	}
+}
+
+// clockEnvelope steps the envelope through its 32 levels. The shape bits are
+// continue, attack, alternate and hold, as on the AY-3-8910.
+func (a *sunsoft5B) clockEnvelope() {
+	if a.envelopeHolding {
+		return
+	}
+
+	a.envelopeCounter++
+	if a.envelopeCounter < a.envelopePeriod {
+		return
+	}
+	a.envelopeCounter = 0
+
+	a.envelopeStep++
+	if a.envelopeStep < 32 {
+		return
+	}
+
+	continues := a.envelopeShape&0x08 != 0
+	hold := a.envelopeShape&0x01 != 0
+	alternate := a.envelopeShape&0x02 != 0
+	switch {
+	case !continues:
+		a.envelopeHolding = true
+		a.envelopeStep = 31
+		a.envelopeShape = 0
+	case hold:
+		a.envelopeHolding = true
+		a.envelopeStep = 31
+		if alternate {
+			a.envelopeShape ^= 0x04
+		}
+	default:
+		a.envelopeStep = 0
+		if alternate {
+			a.envelopeShape ^= 0x04
+		}
+	}
+}
+
+func (a *sunsoft5B) envelopeLevel() uint8 {
+	if a.envelopeShape&0x04 != 0 {
+		return a.envelopeStep
+	}
+	return 31 - a.envelopeStep
+}
+
+// output sums the channels through the 5B's logarithmic volume curve. A
+// channel at full volume is about as loud as a 2A03 pulse channel at full
+// volume.
+func (a *sunsoft5B) output() float32 {
+	var sum float32
+	noise := a.noiseShift&0x01 != 0
+	for i, channel := range a.channels {
+		tone := channel.tone || a.toneDisabled&(1<<uint(i)) != 0
+		noisy := noise || a.noiseDisabled&(1<<uint(i)) != 0
+		if !tone || !noisy {
+			continue
+		}
+
+		level := channel.volume*2 + 1
+		if channel.volume == 0 {
+			level = 0
+		}
+		if channel.envelope {
+			level = a.envelopeLevel()
+		}
+		sum += sunsoft5BVolume[level]
+	}
+	return sum * 95.88 / (8128/15 + 100)
+}
+
+func (a *sunsoft5B) serialize(s *state.Stream) {
+	s.Uint8(&a.register)
+	for i := range a.channels {
+		channel := &a.channels[i]
+		s.Uint16(&channel.period)
+		s.Uint16(&channel.counter)
+		s.Bool(&channel.tone)
+		s.Uint8(&channel.volume)
+		s.Bool(&channel.envelope)
+	}
+
+	s.Uint8(&a.toneDisabled)
+	s.Uint8(&a.noiseDisabled)
+
+	s.Uint8(&a.noisePeriod)
+	s.Uint8(&a.noiseCounter)
+	s.Uint32(&a.noiseShift)
+
+	s.Uint16(&a.envelopePeriod)
+	s.Uint16(&a.envelopeCounter)
+	s.Uint8(&a.envelopeShape)
+	s.Uint8(&a.envelopeStep)
+	s.Bool(&a.envelopeHolding)
+
+	s.Int(&a.cycles)
+}
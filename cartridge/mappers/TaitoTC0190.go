@@ -0,0 +1,166 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(33, AnySubmapper, Factory{
+		Name: "Taito TC0190",
+		New: func(header Header) (Mapper, error) {
+			return new(TaitoTC0190), nil
+		},
+	})
+	Register(48, AnySubmapper, Factory{
+		Name: "Taito TC0690",
+		IRQ:  true,
+		New: func(header Header) (Mapper, error) {
+			return &TaitoTC0190{TC0690: true}, nil
+		},
+	})
+}
+
+// TaitoTC0190 is Taito's TC0190 mapper, along with the TC0690, which moves
+// mirroring control to $E000 and adds an MMC3-style scanline IRQ. Both have
+// two switchable 8KB PRG banks, two 2KB and four 1KB CHR banks.
+type TaitoTC0190 struct {
+	ppuBanks
+
+	PRG    []byte
+	TC0690 bool
+
+	prgBanks [2]int
+
+	irqLatch   uint8
+	irqCounter uint8
+	irqReload  bool
+	irqEnabled bool
+	irqPending bool
+	a12        a12Filter
+}
+
+// Init implements mapper.Init()
+func (r *TaitoTC0190) Init(prg []byte) error {
+	if len(prg) < 0x4000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.prgBanks = [2]int{0, 1}
+	r.mirroring = mirrorVertical
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *TaitoTC0190) Read(address uint16) (byte, error) {
+	bank := -1
+	switch {
+	case address < 0xc000:
+		bank = r.prgBanks[(address-0x8000)/0x2000]
+	case address < 0xe000:
+		bank = -2
+	}
+	return r.PRG[bankOffset(r.PRG, bank, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *TaitoTC0190) Write(address uint16, value byte) error {
+	register := address & 0xe003
+
+	switch {
+	case register == 0x8000:
+		r.prgBanks[0] = int(value & 0x3f)
+		if !r.TC0690 {
+			r.setMirroring(value)
+		}
+	case register == 0x8001:
+		r.prgBanks[1] = int(value & 0x3f)
+	case register == 0x8002, register == 0x8003:
+		slot := int(register&0x01) * 2
+		r.chrBanks[slot] = int(value) * 2
+		r.chrBanks[slot+1] = int(value)*2 + 1
+	case register >= 0xa000 && register <= 0xa003:
+		r.chrBanks[4+register&0x03] = int(value)
+	case !r.TC0690:
+		return fmt.Errorf("Taito TC0190 has no register at $%04x", address)
+	case register == 0xc000:
+		// The latch counts up to 256 rather than down to 0
+		r.irqLatch = value ^ 0xff
+	case register == 0xc001:
+		r.irqCounter = 0
+		r.irqReload = true
+	case register == 0xc002:
+		r.irqEnabled = true
+	case register == 0xc003:
+		r.irqEnabled = false
+		r.irqPending = false
+	case register == 0xe000:
+		r.setMirroring(value)
+	default:
+		return fmt.Errorf("Taito TC0690 has no register at $%04x", address)
+	}
+	return nil
+}
+
+// ReadPPU implements mapper.PPUMapper, clocking the TC0690's scanline counter
+func (r *TaitoTC0190) ReadPPU(address uint16) (byte, error) {
+	if r.TC0690 && r.a12.rising(address&0x3fff) {
+		r.clockScanline()
+	}
+	return r.ppuBanks.ReadPPU(address)
+}
+
+// IRQ implements mapper.IRQMapper
+func (r *TaitoTC0190) IRQ() bool {
+	return r.irqPending
+}
+
+func (r *TaitoTC0190) setMirroring(value byte) {
+	if value&0x40 == 0 {
+		r.mirroring = mirrorVertical
+	} else {
+		r.mirroring = mirrorHorizontal
+	}
+}
+
+// clockScanline reloads the counter from the latch when it is zero or a
+// reload was requested, and otherwise counts it down, raising an IRQ when it
+// reaches zero.
+func (r *TaitoTC0190) clockScanline() {
+	if r.irqCounter == 0 || r.irqReload {
+		r.irqCounter = r.irqLatch
+		r.irqReload = false
+	} else {
+		r.irqCounter--
+	}
+
+	if r.irqCounter == 0 && r.irqEnabled {
+		r.irqPending = true
+	}
+}
+
+// SaveState implements mapper.StateMapper
+func (r *TaitoTC0190) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *TaitoTC0190) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *TaitoTC0190) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Ints(r.prgBanks[:])
+
+	s.Uint8(&r.irqLatch)
+	s.Uint8(&r.irqCounter)
+	s.Bool(&r.irqReload)
+	s.Bool(&r.irqEnabled)
+	s.Bool(&r.irqPending)
+	r.a12.serialize(s)
+}
@@ -0,0 +1,127 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	Register(80, AnySubmapper, Factory{
+		Name: "Taito X1-005",
+		New: func(header Header) (Mapper, error) {
+			return new(TaitoX1005), nil
+		},
+	})
+}
+
+// taitoX1005Unlock is the value $7EF8 must hold for the internal RAM to be
+// accessible
+const taitoX1005Unlock = 0xa3
+
+// TaitoX1005 is Taito's X1-005 mapper. Its registers sit at $7EF0-$7EFF,
+// selecting three 8KB PRG banks, two 2KB and four 1KB CHR banks and
+// mirroring, and it has 128 bytes of battery-backed RAM at $7F00-$7FFF that
+// must be unlocked through $7EF8.
+type TaitoX1005 struct {
+	ppuBanks
+
+	PRG []byte
+	RAM []byte
+
+	prgBanks   [3]int
+	permission uint8
+}
+
+// Init implements mapper.Init()
+func (r *TaitoX1005) Init(prg []byte) error {
+	if len(prg) < 0x2000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.RAM = make([]byte, 128)
+	r.prgBanks = [3]int{0, 1, 2}
+	r.mirroring = mirrorHorizontal
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *TaitoX1005) Read(address uint16) (byte, error) {
+	bank := -1
+	if slot := int(address-0x8000) / 0x2000; slot < 3 {
+		bank = r.prgBanks[slot]
+	}
+	return r.PRG[bankOffset(r.PRG, bank, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *TaitoX1005) Write(address uint16, value byte) error {
+	return fmt.Errorf("Taito X1-005 has no register at $%04x", address)
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *TaitoX1005) ReadLow(address uint16) (byte, error) {
+	if address < 0x7f00 || r.permission != taitoX1005Unlock {
+		return 0, fmt.Errorf("Taito X1-005 read from unmapped address $%04x", address)
+	}
+	return r.RAM[address&0x7f], nil
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *TaitoX1005) WriteLow(address uint16, value byte) error {
+	switch {
+	case address >= 0x7f00:
+		if r.permission == taitoX1005Unlock {
+			r.RAM[address&0x7f] = value
+		}
+	case address == 0x7ef0, address == 0x7ef1:
+		slot := int(address&0x01) * 2
+		r.chrBanks[slot] = int(value &^ 0x01)
+		r.chrBanks[slot+1] = int(value | 0x01)
+	case address >= 0x7ef2 && address <= 0x7ef5:
+		r.chrBanks[address-0x7ef2+4] = int(value)
+	case address == 0x7ef6, address == 0x7ef7:
+		if value&0x01 == 0 {
+			r.mirroring = mirrorHorizontal
+		} else {
+			r.mirroring = mirrorVertical
+		}
+	case address == 0x7ef8, address == 0x7ef9:
+		r.permission = value
+	case address >= 0x7efa && address <= 0x7eff:
+		r.prgBanks[(address-0x7efa)/2] = int(value)
+	default:
+		return fmt.Errorf("Taito X1-005 write to unmapped address $%04x", address)
+	}
+	return nil
+}
+
+// LoadSave implements mapper.SaveMapper
+func (r *TaitoX1005) LoadSave(save io.Reader) error {
+	return loadRAM(save, r.RAM)
+}
+
+// WriteSave implements mapper.SaveMapper
+func (r *TaitoX1005) WriteSave(save io.Writer) error {
+	return writeRAM(save, r.RAM)
+}
+
+// SaveState implements mapper.StateMapper
+func (r *TaitoX1005) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *TaitoX1005) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *TaitoX1005) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Bytes(r.RAM)
+	s.Ints(r.prgBanks[:])
+	s.Uint8(&r.permission)
+}
@@ -0,0 +1,151 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// Nametable layouts for UNROM512.Nametables, from header flags 6 bits 0 and 3
+const (
+	UNROM512Horizontal = iota
+	UNROM512Vertical
+	UNROM512OneScreen
+	UNROM512FourScreen
+)
+
+func init() {
+	// The board reuses flags 6 bits 0 and 3 for four nametable layouts, and
+	// the battery bit to mark a self-flashable board
+	Register(30, AnySubmapper, Factory{
+		Name: "UNROM 512",
+		New: func(header Header) (Mapper, error) {
+			nametables := UNROM512Horizontal
+			if header.Vertical {
+				nametables = UNROM512Vertical
+			}
+			if header.FourScreen {
+				nametables |= UNROM512OneScreen
+			}
+			return &UNROM512{Nametables: nametables, Flash: header.Battery}, nil
+		},
+	})
+}
+
+// UNROM512 is RetroUSB's UNROM 512 homebrew board: UxROM with up to 512KB of
+// PRG ROM and 32KB of CHR RAM in four 8KB banks. Nametables and whether the
+// PRG ROM is a self-flashable chip come from the header, so they must be set
+// before Init.
+//
+// On flashable boards, writes to $8000-$BFFF go to the flash chip and
+// $C000-$FFFF select banks; other boards select banks anywhere in
+// $8000-$FFFF. Bus conflicts aren't emulated.
+type UNROM512 struct {
+	ppuBanks
+
+	Nametables int
+	Flash      bool
+	PRG        []byte
+
+	bank  int
+	flash sstFlash
+}
+
+// Init implements mapper.Init()
+func (r *UNROM512) Init(prg []byte) error {
+	if len(prg) < 0x4000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+
+	r.PRG = prg
+	r.flash.init(prg)
+	return nil
+}
+
+// InitPPU implements mapper.PPUMapper. The board has 32KB of CHR RAM, the
+// last 8KB of which holds the nametables in four-screen mode.
+func (r *UNROM512) InitPPU(chr []byte, vram []byte) error {
+	if err := r.ppuBanks.InitPPU(make([]byte, 0x8000), vram); err != nil {
+		return err
+	}
+	r.chrROM = 0
+
+	switch r.Nametables {
+	case UNROM512Horizontal:
+		r.mirroring = mirrorHorizontal
+	case UNROM512Vertical:
+		r.mirroring = mirrorVertical
+	case UNROM512OneScreen:
+		r.mirroring = mirrorSingleA
+	case UNROM512FourScreen:
+		r.vram = r.CHR[0x6000:]
+		r.mirroring = [4]int{0, 1, 2, 3}
+	default:
+		return fmt.Errorf("Invalid UNROM 512 nametable layout %d", r.Nametables)
+	}
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *UNROM512) Read(address uint16) (byte, error) {
+	bank := -1
+	if address < 0xc000 {
+		bank = r.bank
+	}
+	return r.flash.read(bankOffset(r.PRG, bank, 0x4000, address)), nil
+}
+
+// Write implements mapper.Write()
+func (r *UNROM512) Write(address uint16, value byte) error {
+	if r.Flash && address < 0xc000 {
+		r.flash.write(r.bank*0x4000+int(address&0x3fff), value)
+		return nil
+	}
+
+	r.bank = int(value & 0x1f)
+	for i := range r.chrBanks {
+		r.chrBanks[i] = int(value>>5&0x03)*8 + i
+	}
+	if r.Nametables == UNROM512OneScreen {
+		if value&0x80 == 0 {
+			r.mirroring = mirrorSingleA
+		} else {
+			r.mirroring = mirrorSingleB
+		}
+	}
+	return nil
+}
+
+// LoadSave implements mapper.SaveMapper
+func (r *UNROM512) LoadSave(save io.Reader) error {
+	return r.flash.loadSave(save)
+}
+
+// WriteSave implements mapper.SaveMapper, writing the reflashed PRG ROM as an
+// IPS patch against the original.
+func (r *UNROM512) WriteSave(save io.Writer) error {
+	return r.flash.writeSave(save)
+}
+
+// SaveState implements mapper.StateMapper
+func (r *UNROM512) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *UNROM512) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+// serialize saves the board's CHR RAM, and the flash chip whole as the game
+// may have reprogrammed it
+func (r *UNROM512) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Bytes(r.CHR)
+	s.Int(&r.bank)
+	if r.Flash {
+		r.flash.serialize(s)
+	}
+}
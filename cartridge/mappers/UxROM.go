@@ -2,9 +2,21 @@ package mapper
 
 import (
 	"errors"
-	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
 )
 
+func init() {
+	Register(2, AnySubmapper, Factory{
+		Name:   "UxROM",
+		Boards: []string{"UNROM", "UOROM"},
+		New: func(header Header) (Mapper, error) {
+			return new(UxROM), nil
+		},
+	})
+}
+
 // UxROM is a simple mapper with one siwtchable and one fixed page
 type UxROM struct {
 	PRG  []byte
@@ -13,7 +25,6 @@ type UxROM struct {
 
 // Init implements mapper.Init()
 func (r *UxROM) Init(prg []byte) error {
-	fmt.Println("Loaded mapper UxROM")
 	r.PRG = prg
 	r.page = 0
 
@@ -45,3 +56,17 @@ func (r *UxROM) Write(address uint16, value byte) error {
 	r.page = int(value)
 	return nil
 }
+
+// SaveState implements mapper.StateMapper
+func (r *UxROM) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *UxROM) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *UxROM) serialize(s *state.Stream) {
+	s.Int(&r.page)
+}
@@ -0,0 +1,408 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	for _, id := range []int{21, 22, 23, 25} {
+		registerVRC(id, "Konami VRC2/VRC4", false, func(board VRCBoard) Mapper {
+			return &VRC4{Board: board}
+		})
+	}
+}
+
+// A VRCBoard describes how a Konami VRC board is wired: which CPU address
+// lines drive the chip's two register select pins, and which chip it carries.
+// The VRC7 has a single register select pin, so its boards leave A1 zero.
+type VRCBoard struct {
+	Name string
+	A0   uint16
+	A1   uint16
+	VRC2 bool
+
+	// CHRShift drops low CHR bank bits the board doesn't connect
+	CHRShift uint
+}
+
+// vrcBoards lists the boards behind each iNES mapper number, in NES 2.0
+// submapper order starting from submapper 1.
+var vrcBoards = map[int][]VRCBoard{
+	21: {{"VRC4a", 0x02, 0x04, false, 0}, {"VRC4c", 0x40, 0x80, false, 0}},
+	22: {{"VRC2a", 0x02, 0x01, true, 1}},
+	23: {{"VRC4f", 0x01, 0x02, false, 0}, {"VRC4e", 0x04, 0x08, false, 0}, {"VRC2b", 0x01, 0x02, true, 0}},
+	25: {{"VRC4b", 0x02, 0x01, false, 0}, {"VRC4d", 0x08, 0x04, false, 0}, {"VRC2c", 0x02, 0x01, true, 0}},
+	24: {{"VRC6a", 0x01, 0x02, false, 0}},
+	26: {{"VRC6b", 0x02, 0x01, false, 0}},
+	85: {{"VRC7b", 0x08, 0, false, 0}, {"VRC7a", 0x10, 0, false, 0}},
+}
+
+// VRCBoardFor picks the board for a VRC mapper number. The NES 2.0
+// submapper says which board it is, and the cartridge takes it from the ROM
+// database for known dumps whose header lacks one. Failing both, the PRG ROM
+// is searched for stores to the mapper registers to see which address lines
+// the game uses, decoding every candidate wiring at once when that is
+// inconclusive, and for stores to the registers only the VRC4 has to tell it
+// from a VRC2 wired the same way.
+func VRCBoardFor(mapperID int, submapper int, prg []byte) (VRCBoard, error) {
+	boards, ok := vrcBoards[mapperID]
+	if !ok {
+		return VRCBoard{}, fmt.Errorf("Mapper %d is not a Konami VRC board", mapperID)
+	}
+
+	if submapper > 0 && submapper <= len(boards) {
+		return boards[submapper-1], nil
+	}
+	if len(boards) == 1 {
+		return boards[0], nil
+	}
+
+	// VRC2 and VRC4 boards can share a wiring, so score each wiring once
+	var wirings []VRCBoard
+	var scores []int
+	for _, board := range boards {
+		if vrcWiring(wirings, board) < 0 {
+			wirings = append(wirings, board)
+			scores = append(scores, vrcRegisterStores(prg, board))
+		}
+	}
+
+	best := 0
+	for i := range wirings {
+		if scores[i] > scores[best] {
+			best = i
+		}
+	}
+	for i := range wirings {
+		if i != best && scores[best] <= scores[i]*2 {
+			return combinedVRCBoard(prg, boards), nil
+		}
+	}
+
+	vrc2 := vrc4RegisterStores(prg, wirings[best]) == 0
+	for _, board := range boards {
+		if board.A0 == wirings[best].A0 && board.A1 == wirings[best].A1 && board.VRC2 == vrc2 {
+			return board, nil
+		}
+	}
+	return wirings[best], nil
+}
+
+// combinedVRCBoard decodes every wiring the boards for a mapper number use.
+// If a VRC2 is among them, it is one unless the game stores to the VRC4's
+// own registers under one of the wirings.
+func combinedVRCBoard(prg []byte, boards []VRCBoard) VRCBoard {
+	var combined VRCBoard
+	vrc2, vrc4 := false, false
+	for i, board := range boards {
+		if i > 0 {
+			combined.Name += "/"
+		}
+		combined.Name += board.Name
+		combined.A0 |= board.A0
+		combined.A1 |= board.A1
+		vrc2 = vrc2 || board.VRC2
+		vrc4 = vrc4 || vrc4RegisterStores(prg, board) > 0
+	}
+	combined.VRC2 = vrc2 && !vrc4
+	return combined
+}
+
+// vrcWiring returns the index of the board in wirings with the same address
+// lines as board, or -1 if there isn't one
+func vrcWiring(wirings []VRCBoard, board VRCBoard) int {
+	for i, wiring := range wirings {
+		if wiring.A0 == board.A0 && wiring.A1 == board.A1 {
+			return i
+		}
+	}
+	return -1
+}
+
+// registerVRC registers a VRC mapper number, with a factory that picks the
+// board with VRCBoardFor and passes it to create.
+func registerVRC(id int, name string, audio bool, create func(board VRCBoard) Mapper) {
+	factory := Factory{
+		Name:  name,
+		Audio: audio,
+		New: func(header Header) (Mapper, error) {
+			board, err := VRCBoardFor(header.MapperID, header.Submapper, header.PRG)
+			if err != nil {
+				return nil, err
+			}
+			return create(board), nil
+		},
+	}
+
+	boards := vrcBoards[id]
+	for i, board := range boards {
+		factory.Boards = append(factory.Boards, board.Name)
+		factory.IRQ = factory.IRQ || !board.VRC2
+		if len(boards) > 1 {
+			factory.Submappers = append(factory.Submappers, i+1)
+		}
+	}
+	Register(id, AnySubmapper, factory)
+}
+
+// register normalizes an address to the $x000-$x003 register it selects
+func (b VRCBoard) register(address uint16) uint16 {
+	register := address & 0xf000
+	if address&b.A0 != 0 {
+		register |= 0x01
+	}
+	if address&b.A1 != 0 {
+		register |= 0x02
+	}
+	return register
+}
+
+// vrcRegisterStores counts absolute STA, STX and STY instructions that target
+// one of the board's $x001-$x003 registers in $9000-$EFFF.
+func vrcRegisterStores(prg []byte, board VRCBoard) int {
+	return countStores(prg, func(address uint16) bool {
+		low := address & 0x0fff
+		return address >= 0x9000 && address < 0xf000 && low != 0 && low&^(board.A0|board.A1) == 0
+	})
+}
+
+// vrc4RegisterStores counts stores to the registers a VRC2 doesn't have under
+// the board's wiring: the PRG swap and RAM control at $9002 and the IRQ
+// registers at $F000-$F003.
+func vrc4RegisterStores(prg []byte, board VRCBoard) int {
+	return countStores(prg, func(address uint16) bool {
+		low := address & 0x0fff
+		if low&^(board.A0|board.A1) != 0 {
+			return false
+		}
+		return address >= 0xf000 || address&0xf000 == 0x9000 && low&board.A1 != 0
+	})
+}
+
+// countStores counts absolute STA, STX and STY instructions in the PRG ROM
+// whose address target accepts
+func countStores(prg []byte, target func(address uint16) bool) int {
+	count := 0
+	for i := 0; i+2 < len(prg); i++ {
+		switch prg[i] {
+		case 0x8c, 0x8d, 0x8e:
+		default:
+			continue
+		}
+
+		if target(uint16(prg[i+1]) | uint16(prg[i+2])<<8) {
+			count++
+		}
+	}
+	return count
+}
+
+// VRC4 is Konami's VRC4 mapper, along with its predecessor the VRC2, which
+// has a subset of the VRC4's registers. Each board wires different CPU
+// address lines to the register select pins, so Board must be set before
+// Init.
+type VRC4 struct {
+	ppuBanks
+
+	Board VRCBoard
+	PRG   []byte
+	RAM   []byte
+
+	prgBanks   [2]int
+	chrRegs    [8]int
+	swapMode   bool
+	ramEnabled bool
+	irq        vrcIRQ
+
+	// microwire is the VRC2's one-bit latch at $6000-$6FFF, which some
+	// games use in place of an EEPROM
+	microwire uint8
+}
+
+// Init implements mapper.Init()
+func (r *VRC4) Init(prg []byte) error {
+	if len(prg) < 8192 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+	if r.Board.A0 == 0 || r.Board.A1 == 0 {
+		return errors.New("VRC board wiring not set")
+	}
+
+	r.PRG = prg
+	r.RAM = make([]byte, 8192)
+	r.prgBanks = [2]int{0, 1}
+	r.mirroring = mirrorVertical
+	return nil
+}
+
+// InitPPU implements mapper.PPUMapper
+func (r *VRC4) InitPPU(chr []byte, vram []byte) error {
+	if err := r.ppuBanks.InitPPU(chr, vram); err != nil {
+		return err
+	}
+	for i := range r.chrRegs {
+		r.chrRegs[i] = i
+	}
+	r.updateCHR()
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *VRC4) Read(address uint16) (byte, error) {
+	var bank int
+	switch {
+	case address < 0xa000:
+		bank = r.prgBanks[0]
+		if r.swapMode {
+			bank = -2
+		}
+	case address < 0xc000:
+		bank = r.prgBanks[1]
+	case address < 0xe000:
+		bank = -2
+		if r.swapMode {
+			bank = r.prgBanks[0]
+		}
+	default:
+		bank = -1
+	}
+
+	return r.PRG[bankOffset(r.PRG, bank, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *VRC4) Write(address uint16, value byte) error {
+	register := r.Board.register(address)
+
+	switch {
+	case register < 0x9000:
+		r.prgBanks[0] = int(value & 0x1f)
+	case register < 0xa000:
+		r.writeControl(register, value)
+	case register < 0xb000:
+		r.prgBanks[1] = int(value & 0x1f)
+	case register < 0xf000:
+		r.writeCHR(register, value)
+	case r.Board.VRC2:
+		return fmt.Errorf("%s has no register at $%04x", r.Board.Name, address)
+	case register == 0xf000:
+		r.irq.setLatchLow(value)
+	case register == 0xf001:
+		r.irq.setLatchHigh(value)
+	case register == 0xf002:
+		r.irq.setControl(value)
+	case register == 0xf003:
+		r.irq.acknowledge()
+	}
+
+	return nil
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *VRC4) ReadLow(address uint16) (byte, error) {
+	switch {
+	case address < 0x6000:
+		return 0, fmt.Errorf("%s read from unmapped address $%04x", r.Board.Name, address)
+	case r.Board.VRC2 && address < 0x7000:
+		return 0x60 | r.microwire, nil
+	case r.Board.VRC2 || r.ramEnabled:
+		return r.RAM[address&0x1fff], nil
+	}
+	return 0, fmt.Errorf("%s PRG RAM is disabled", r.Board.Name)
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *VRC4) WriteLow(address uint16, value byte) error {
+	switch {
+	case address < 0x6000:
+		return fmt.Errorf("%s write to unmapped address $%04x", r.Board.Name, address)
+	case r.Board.VRC2 && address < 0x7000:
+		r.microwire = value & 0x01
+	case r.Board.VRC2 || r.ramEnabled:
+		r.RAM[address&0x1fff] = value
+	}
+	return nil
+}
+
+// LoadTrainer implements mapper.TrainerMapper
+func (r *VRC4) LoadTrainer(trainer []byte) error {
+	copy(r.RAM[0x1000:], trainer)
+	return nil
+}
+
+// Clock implements mapper.ClockedMapper
+func (r *VRC4) Clock() {
+	r.irq.clock()
+}
+
+// IRQ implements mapper.IRQMapper
+func (r *VRC4) IRQ() bool {
+	return r.irq.pending
+}
+
+func (r *VRC4) writeControl(register uint16, value byte) {
+	if r.Board.VRC2 {
+		if value&0x01 == 0 {
+			r.mirroring = mirrorVertical
+		} else {
+			r.mirroring = mirrorHorizontal
+		}
+		return
+	}
+
+	switch register {
+	case 0x9000, 0x9001:
+		r.mirroring = [4][4]int{mirrorVertical, mirrorHorizontal, mirrorSingleA, mirrorSingleB}[value&0x03]
+	case 0x9002:
+		r.ramEnabled = value&0x01 != 0
+		r.swapMode = value&0x02 != 0
+	}
+}
+
+// writeCHR sets the low or high bits of one of the eight 1KB CHR banks.
+// $B000-$B003 hold banks 0 and 1, $C000-$C003 banks 2 and 3, and so on.
+func (r *VRC4) writeCHR(register uint16, value byte) {
+	bank := int(register>>12-0x0b)*2 + int(register&0x02)>>1
+
+	if register&0x01 == 0 {
+		r.chrRegs[bank] = r.chrRegs[bank]&^0x0f | int(value&0x0f)
+	} else if r.Board.VRC2 {
+		r.chrRegs[bank] = r.chrRegs[bank]&0x0f | int(value&0x0f)<<4
+	} else {
+		r.chrRegs[bank] = r.chrRegs[bank]&0x0f | int(value&0x1f)<<4
+	}
+	r.updateCHR()
+}
+
+// updateCHR applies the CHR registers. The VRC2a ignores the low bit of each
+// register and uses the rest as the 1KB bank number.
+func (r *VRC4) updateCHR() {
+	for i, reg := range r.chrRegs {
+		r.chrBanks[i] = reg >> r.Board.CHRShift
+	}
+}
+
+// SaveState implements mapper.StateMapper
+func (r *VRC4) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *VRC4) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *VRC4) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Bytes(r.RAM)
+	s.Ints(r.prgBanks[:])
+	s.Ints(r.chrRegs[:])
+	s.Bool(&r.swapMode)
+	s.Bool(&r.ramEnabled)
+	r.irq.serialize(s)
+	s.Uint8(&r.microwire)
+}
@@ -0,0 +1,206 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	for _, id := range []int{24, 26} {
+		registerVRC(id, "Konami VRC6", true, func(board VRCBoard) Mapper {
+			return &VRC6{Board: board}
+		})
+	}
+}
+
+// VRC6 is Konami's VRC6 mapper, with 16KB and 8KB switchable PRG banks, 1KB
+// CHR banks, the VRC cycle IRQ, and two pulse channels and a sawtooth channel
+// of expansion audio. The VRC6a and VRC6b swap the two register select lines,
+// so Board must be set before Init.
+//
+// The rarely used nametables-from-CHR-ROM mode ($B003 bit 4) isn't supported.
+type VRC6 struct {
+	ppuBanks
+
+	Board VRCBoard
+	PRG   []byte
+	RAM   []byte
+
+	prg16      int
+	prg8       int
+	chrRegs    [8]int
+	ppuMode    uint8
+	ramEnabled bool
+	irq        vrcIRQ
+	audio      vrc6Audio
+}
+
+// Init implements mapper.Init()
+func (r *VRC6) Init(prg []byte) error {
+	if len(prg) < 0x4000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+	if r.Board.A0 == 0 || r.Board.A1 == 0 {
+		return errors.New("VRC board wiring not set")
+	}
+
+	r.PRG = prg
+	r.RAM = make([]byte, 8192)
+	r.mirroring = mirrorVertical
+	return nil
+}
+
+// InitPPU implements mapper.PPUMapper
+func (r *VRC6) InitPPU(chr []byte, vram []byte) error {
+	if err := r.ppuBanks.InitPPU(chr, vram); err != nil {
+		return err
+	}
+	for i := range r.chrRegs {
+		r.chrRegs[i] = i
+	}
+	r.updateCHR()
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *VRC6) Read(address uint16) (byte, error) {
+	switch {
+	case address < 0xc000:
+		return r.PRG[bankOffset(r.PRG, r.prg16, 0x4000, address)], nil
+	case address < 0xe000:
+		return r.PRG[bankOffset(r.PRG, r.prg8, 0x2000, address)], nil
+	}
+	return r.PRG[bankOffset(r.PRG, -1, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *VRC6) Write(address uint16, value byte) error {
+	register := r.Board.register(address)
+
+	switch {
+	case register < 0x9000:
+		r.prg16 = int(value & 0x0f)
+	case register < 0xb003:
+		r.audio.write(register, value)
+	case register == 0xb003:
+		r.setPPUMode(value)
+	case register < 0xd000:
+		r.prg8 = int(value & 0x1f)
+	case register < 0xf000:
+		r.chrRegs[int((register-0xd000)>>12)*4+int(register&0x03)] = int(value)
+		r.updateCHR()
+	case register == 0xf000:
+		r.irq.setLatch(value)
+	case register == 0xf001:
+		r.irq.setControl(value)
+	case register == 0xf002:
+		r.irq.acknowledge()
+	default:
+		return fmt.Errorf("%s has no register at $%04x", r.Board.Name, address)
+	}
+
+	return nil
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *VRC6) ReadLow(address uint16) (byte, error) {
+	if address < 0x6000 || !r.ramEnabled {
+		return 0, fmt.Errorf("%s read from unmapped address $%04x", r.Board.Name, address)
+	}
+	return r.RAM[address&0x1fff], nil
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *VRC6) WriteLow(address uint16, value byte) error {
+	if address < 0x6000 || !r.ramEnabled {
+		return fmt.Errorf("%s write to unmapped address $%04x", r.Board.Name, address)
+	}
+	r.RAM[address&0x1fff] = value
+	return nil
+}
+
+// LoadTrainer implements mapper.TrainerMapper
+func (r *VRC6) LoadTrainer(trainer []byte) error {
+	copy(r.RAM[0x1000:], trainer)
+	return nil
+}
+
+// Clock implements mapper.ClockedMapper
+func (r *VRC6) Clock() {
+	r.irq.clock()
+	r.audio.clock()
+}
+
+// IRQ implements mapper.IRQMapper
+func (r *VRC6) IRQ() bool {
+	return r.irq.pending
+}
+
+// Output returns the level of the pulse and sawtooth channels. The mapper
+// only generates it; the APU mixes it in through its Expansion hook.
+func (r *VRC6) Output() float32 {
+	return r.audio.output()
+}
+
+// setPPUMode handles $B003, which selects the CHR banking layout and
+// mirroring, and enables PRG RAM.
+func (r *VRC6) setPPUMode(value byte) {
+	r.ppuMode = value
+	r.ramEnabled = value&0x80 != 0
+	r.mirroring = [4][4]int{mirrorVertical, mirrorHorizontal, mirrorSingleA, mirrorSingleB}[(value>>2)&0x03]
+	r.updateCHR()
+}
+
+// updateCHR lays the eight CHR registers out over the pattern tables. Mode 0
+// uses them as 1KB banks, mode 1 uses R0-R3 as 2KB banks, and modes 2 and 3
+// use R0-R3 as 1KB banks for the first table and R4-R5 as 2KB banks for the
+// second. 2KB banks take their low bit from PPU A10 unless $B003 bit 5 is
+// set, in which case both halves show the same 1KB bank.
+func (r *VRC6) updateCHR() {
+	wide := func(slot int, reg int) int {
+		if r.ppuMode&0x20 != 0 {
+			return reg
+		}
+		return reg&^1 | slot&1
+	}
+
+	for slot := range r.chrBanks {
+		switch r.ppuMode & 0x03 {
+		case 0:
+			r.chrBanks[slot] = r.chrRegs[slot]
+		case 1:
+			r.chrBanks[slot] = wide(slot, r.chrRegs[slot/2])
+		default:
+			if slot < 4 {
+				r.chrBanks[slot] = r.chrRegs[slot]
+			} else {
+				r.chrBanks[slot] = wide(slot, r.chrRegs[4+(slot-4)/2])
+			}
+		}
+	}
+}
+
+// SaveState implements mapper.StateMapper
+func (r *VRC6) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *VRC6) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *VRC6) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Bytes(r.RAM)
+	s.Int(&r.prg16)
+	s.Int(&r.prg8)
+	s.Ints(r.chrRegs[:])
+	s.Uint8(&r.ppuMode)
+	s.Bool(&r.ramEnabled)
+	r.irq.serialize(s)
+	r.audio.serialize(s)
+}
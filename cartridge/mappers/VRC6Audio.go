@@ -0,0 +1,174 @@
+package mapper
+
+import "github.com/makononov/NESGo/state"
+
+// vrc6Pulse is one of the VRC6's two pulse channels. It has 16 duty steps,
+// eight duty settings and a mode that holds the output at full volume.
+type vrc6Pulse struct {
+	enabled bool
+	digital bool
+	duty    uint8
+	volume  uint8
+	period  uint16
+	timer   uint16
+	step    uint8
+}
+
+func (p *vrc6Pulse) write(register uint16, value uint8) {
+	switch register {
+	case 0:
+		p.digital = value&0x80 != 0
+		p.duty = (value >> 4) & 0x07
+		p.volume = value & 0x0f
+	case 1:
+		p.period = p.period&0x0f00 | uint16(value)
+	case 2:
+		p.period = p.period&0x00ff | uint16(value&0x0f)<<8
+		p.enabled = value&0x80 != 0
+		if !p.enabled {
+			p.step = 15
+		}
+	}
+}
+
+func (p *vrc6Pulse) clock(shift uint) {
+	if !p.enabled {
+		return
+	}
+	if p.timer == 0 {
+		p.timer = p.period >> shift
+		p.step = (p.step - 1) & 0x0f
+	} else {
+		p.timer--
+	}
+}
+
+func (p *vrc6Pulse) output() uint8 {
+	if !p.enabled || (!p.digital && p.step > p.duty) {
+		return 0
+	}
+	return p.volume
+}
+
+// vrc6Saw is the VRC6's sawtooth channel, an accumulator that adds its rate
+// every other timer clock and resets on the fourteenth.
+type vrc6Saw struct {
+	enabled     bool
+	rate        uint8
+	period      uint16
+	timer       uint16
+	step        uint8
+	accumulator uint8
+}
+
+func (s *vrc6Saw) write(register uint16, value uint8) {
+	switch register {
+	case 0:
+		s.rate = value & 0x3f
+	case 1:
+		s.period = s.period&0x0f00 | uint16(value)
+	case 2:
+		s.period = s.period&0x00ff | uint16(value&0x0f)<<8
+		s.enabled = value&0x80 != 0
+		if !s.enabled {
+			s.step = 0
+			s.accumulator = 0
+		}
+	}
+}
+
+func (s *vrc6Saw) clock(shift uint) {
+	if !s.enabled {
+		return
+	}
+	if s.timer > 0 {
+		s.timer--
+		return
+	}
+
+	s.timer = s.period >> shift
+	s.step++
+	switch {
+	case s.step == 14:
+		s.step = 0
+		s.accumulator = 0
+	case s.step&0x01 == 0:
+		s.accumulator += s.rate
+	}
+}
+
+func (s *vrc6Saw) output() uint8 {
+	return s.accumulator >> 3
+}
+
+// vrc6Audio holds the VRC6's expansion sound channels
+type vrc6Audio struct {
+	pulse [2]vrc6Pulse
+	saw   vrc6Saw
+
+	halt  bool
+	shift uint
+}
+
+func (a *vrc6Audio) write(register uint16, value uint8) {
+	switch {
+	case register == 0x9003:
+		a.halt = value&0x01 != 0
+		switch {
+		case value&0x04 != 0:
+			a.shift = 8
+		case value&0x02 != 0:
+			a.shift = 4
+		default:
+			a.shift = 0
+		}
+	case register < 0xa000:
+		a.pulse[0].write(register&0x03, value)
+	case register < 0xb000:
+		a.pulse[1].write(register&0x03, value)
+	default:
+		a.saw.write(register&0x03, value)
+	}
+}
+
+func (a *vrc6Audio) clock() {
+	if a.halt {
+		return
+	}
+	a.pulse[0].clock(a.shift)
+	a.pulse[1].clock(a.shift)
+	a.saw.clock(a.shift)
+}
+
+// output sums the channels linearly, as the VRC6 does. A pulse step is about
+// as loud as a step of a 2A03 pulse channel, so the 0-61 sum is scaled with
+// the slope of the 2A03's pulse mixing curve near zero.
+func (a *vrc6Audio) output() float32 {
+	sum := float32(a.pulse[0].output()) + float32(a.pulse[1].output()) + float32(a.saw.output())
+	return sum * 95.88 / 8128
+}
+
+func (a *vrc6Audio) serialize(s *state.Stream) {
+	for i := range a.pulse {
+		pulse := &a.pulse[i]
+		s.Bool(&pulse.enabled)
+		s.Bool(&pulse.digital)
+		s.Uint8(&pulse.duty)
+		s.Uint8(&pulse.volume)
+		s.Uint16(&pulse.period)
+		s.Uint16(&pulse.timer)
+		s.Uint8(&pulse.step)
+	}
+
+	s.Bool(&a.saw.enabled)
+	s.Uint8(&a.saw.rate)
+	s.Uint16(&a.saw.period)
+	s.Uint16(&a.saw.timer)
+	s.Uint8(&a.saw.step)
+	s.Uint8(&a.saw.accumulator)
+
+	s.Bool(&a.halt)
+	shift := int(a.shift)
+	s.Int(&shift)
+	a.shift = uint(shift)
+}
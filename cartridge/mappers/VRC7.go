@@ -0,0 +1,162 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+func init() {
+	registerVRC(85, "Konami VRC7", true, func(board VRCBoard) Mapper {
+		return &VRC7{Board: board}
+	})
+}
+
+// VRC7 is Konami's VRC7 mapper, with three switchable 8KB PRG banks, 1KB CHR
+// banks, the VRC cycle IRQ, and a six channel FM synthesizer derived from
+// the YM2413. The VRC7a and VRC7b use different address lines to select
+// registers, so Board must be set before Init.
+type VRC7 struct {
+	ppuBanks
+
+	Board VRCBoard
+	PRG   []byte
+	RAM   []byte
+
+	prgBanks   [3]int
+	ramEnabled bool
+	irq        vrcIRQ
+	audio      vrc7Audio
+}
+
+// Init implements mapper.Init()
+func (r *VRC7) Init(prg []byte) error {
+	if len(prg) < 0x2000 {
+		return errors.New("Invalid PRG ROM data length")
+	}
+	if r.Board.A0 == 0 {
+		return errors.New("VRC board wiring not set")
+	}
+
+	r.PRG = prg
+	r.RAM = make([]byte, 8192)
+	r.prgBanks = [3]int{0, 1, 2}
+	r.mirroring = mirrorVertical
+	r.audio.init()
+	return nil
+}
+
+// Read implements mapper.Read()
+func (r *VRC7) Read(address uint16) (byte, error) {
+	bank := -1
+	if slot := int(address-0x8000) / 0x2000; slot < 3 {
+		bank = r.prgBanks[slot]
+	}
+	return r.PRG[bankOffset(r.PRG, bank, 0x2000, address)], nil
+}
+
+// Write implements mapper.Write()
+func (r *VRC7) Write(address uint16, value byte) error {
+	// The sound registers are decoded from A4 and A5 on every board
+	switch address & 0xf030 {
+	case 0x9010:
+		r.audio.selectRegister(value)
+		return nil
+	case 0x9030:
+		r.audio.write(value)
+		return nil
+	}
+
+	register := r.Board.register(address)
+
+	switch {
+	case register == 0x8000:
+		r.prgBanks[0] = int(value & 0x3f)
+	case register == 0x8001:
+		r.prgBanks[1] = int(value & 0x3f)
+	case register == 0x9000:
+		r.prgBanks[2] = int(value & 0x3f)
+	case register >= 0xa000 && register < 0xe000:
+		r.chrBanks[int(register>>12-0x0a)*2+int(register&0x01)] = int(value)
+	case register == 0xe000:
+		r.writeControl(value)
+	case register == 0xe001:
+		r.irq.setLatch(value)
+	case register == 0xf000:
+		r.irq.setControl(value)
+	case register == 0xf001:
+		r.irq.acknowledge()
+	default:
+		return fmt.Errorf("%s has no register at $%04x", r.Board.Name, address)
+	}
+
+	return nil
+}
+
+// ReadLow implements mapper.LowMapper
+func (r *VRC7) ReadLow(address uint16) (byte, error) {
+	if address < 0x6000 || !r.ramEnabled {
+		return 0, fmt.Errorf("%s read from unmapped address $%04x", r.Board.Name, address)
+	}
+	return r.RAM[address&0x1fff], nil
+}
+
+// WriteLow implements mapper.LowMapper
+func (r *VRC7) WriteLow(address uint16, value byte) error {
+	if address < 0x6000 || !r.ramEnabled {
+		return fmt.Errorf("%s write to unmapped address $%04x", r.Board.Name, address)
+	}
+	r.RAM[address&0x1fff] = value
+	return nil
+}
+
+// LoadTrainer implements mapper.TrainerMapper
+func (r *VRC7) LoadTrainer(trainer []byte) error {
+	copy(r.RAM[0x1000:], trainer)
+	return nil
+}
+
+// Clock implements mapper.ClockedMapper
+func (r *VRC7) Clock() {
+	r.irq.clock()
+	r.audio.clock()
+}
+
+// IRQ implements mapper.IRQMapper
+func (r *VRC7) IRQ() bool {
+	return r.irq.pending
+}
+
+// Output implements mapper.AudioMapper
+func (r *VRC7) Output() float32 {
+	return r.audio.output()
+}
+
+// writeControl handles $E000, which sets mirroring, holds the sound chip in
+// reset, and enables PRG RAM.
+func (r *VRC7) writeControl(value byte) {
+	r.mirroring = [4][4]int{mirrorVertical, mirrorHorizontal, mirrorSingleA, mirrorSingleB}[value&0x03]
+	r.audio.setReset(value&0x40 != 0)
+	r.ramEnabled = value&0x80 != 0
+}
+
+// SaveState implements mapper.StateMapper
+func (r *VRC7) SaveState(w io.Writer) error {
+	return saveState(w, r.serialize)
+}
+
+// LoadState implements mapper.StateMapper
+func (r *VRC7) LoadState(reader io.Reader) error {
+	return loadState(reader, r.serialize)
+}
+
+func (r *VRC7) serialize(s *state.Stream) {
+	r.ppuBanks.serialize(s)
+	s.Bytes(r.RAM)
+	s.Ints(r.prgBanks[:])
+	s.Bool(&r.ramEnabled)
+	r.irq.serialize(s)
+	r.audio.serialize(s)
+}
@@ -0,0 +1,409 @@
+package mapper
+
+import (
+	"math"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// The VRC7's sound chip makes one sample every 72 cycles of its 3.58MHz
+// clock, which is every 36 CPU cycles.
+const vrc7SampleCycles = 36
+
+// Attenuation is tracked in the chip's envelope steps of 0.375dB. The
+// envelope runs from 0 to 127 steps, where the operator is silent.
+const (
+	vrc7StepDB   = 0.375
+	vrc7Silent   = 127
+	vrc7PhaseBit = 19
+)
+
+// vrc7Patch is an instrument: the settings of a channel's modulator and
+// carrier operators, laid out as in the custom instrument registers $00-$07.
+type vrc7Patch [8]uint8
+
+// vrc7Patches holds the VRC7's fifteen built-in instruments. Instrument 0 is
+// the custom instrument, which is programmed through registers $00-$07.
+var vrc7Patches = [16]vrc7Patch{
+	{},
+	{0x03, 0x21, 0x05, 0x06, 0xe8, 0x81, 0x42, 0x27},
+	{0x13, 0x41, 0x14, 0x0d, 0xd8, 0xf6, 0x23, 0x12},
+	{0x11, 0x11, 0x08, 0x08, 0xfa, 0xb2, 0x20, 0x12},
+	{0x31, 0x61, 0x0c, 0x07, 0xa8, 0x64, 0x61, 0x27},
+	{0x32, 0x21, 0x1e, 0x06, 0xe1, 0x76, 0x01, 0x28},
+	{0x02, 0x01, 0x06, 0x00, 0xa3, 0xe2, 0xf4, 0xf4},
+	{0x21, 0x61, 0x1d, 0x07, 0x82, 0x81, 0x11, 0x07},
+	{0x23, 0x21, 0x22, 0x17, 0xa2, 0x72, 0x01, 0x17},
+	{0x35, 0x11, 0x25, 0x00, 0x40, 0x73, 0x72, 0x01},
+	{0xb5, 0x01, 0x0f, 0x0f, 0xa8, 0xa5, 0x51, 0x02},
+	{0x17, 0xc1, 0x24, 0x07, 0xf8, 0xf8, 0x22, 0x12},
+	{0x71, 0x23, 0x11, 0x06, 0x65, 0x74, 0x18, 0x16},
+	{0x01, 0x02, 0xd3, 0x05, 0xc9, 0x95, 0x03, 0x02},
+	{0x61, 0x63, 0x0c, 0x00, 0x94, 0xc0, 0x33, 0xf6},
+	{0x21, 0x72, 0x0d, 0x00, 0xc1, 0xd5, 0x56, 0x06},
+}
+
+// vrc7Multiples gives each frequency multiplier setting, doubled so the
+// setting for one half is a whole number.
+var vrc7Multiples = [16]uint32{1, 2, 4, 6, 8, 10, 12, 14, 16, 18, 20, 20, 24, 24, 30, 30}
+
+// vrc7KeyScale gives the attenuation in dB for the top four F-number bits in
+// octave 7. Lower octaves are 6dB quieter each.
+var vrc7KeyScale = [16]float64{0, 18, 24, 27.75, 30, 32.25, 33.75, 35.25, 36, 37.5, 38.25, 39, 39.75, 40.5, 41.25, 42}
+
+// vrc7Vibrato is the F-number offset, in 1/256ths of the F-number, for each
+// step of the 6.1Hz vibrato.
+var vrc7Vibrato = [8]int{0, 1, 2, 1, 0, -1, -2, -1}
+
+// vrc7Operator holds the settings for one operator, decoded from a patch
+type vrc7Operator struct {
+	tremolo    bool
+	vibrato    bool
+	sustained  bool
+	keyScale   bool
+	multiple   uint32
+	scaleLevel uint8
+	level      uint8
+	rectified  bool
+	attack     uint8
+	decay      uint8
+	sustain    uint8
+	release    uint8
+}
+
+// operator decodes the settings for the modulator (0) or carrier (1)
+func (p *vrc7Patch) operator(op uint) vrc7Operator {
+	return vrc7Operator{
+		tremolo:    p[op]&0x80 != 0,
+		vibrato:    p[op]&0x40 != 0,
+		sustained:  p[op]&0x20 != 0,
+		keyScale:   p[op]&0x10 != 0,
+		multiple:   vrc7Multiples[p[op]&0x0f],
+		scaleLevel: p[2+op] >> 6,
+		level:      p[2] & 0x3f,
+		rectified:  p[3]&(0x08<<op) != 0,
+		attack:     p[4+op] >> 4,
+		decay:      p[4+op] & 0x0f,
+		sustain:    p[6+op] >> 4,
+		release:    p[6+op] & 0x0f,
+	}
+}
+
+type vrc7EnvelopeState int
+
+const (
+	vrc7Attack vrc7EnvelopeState = iota
+	vrc7Decay
+	vrc7Sustain
+	vrc7Release
+)
+
+// vrc7Slot is the running state of one operator
+type vrc7Slot struct {
+	phase    uint32
+	envelope float64
+	state    vrc7EnvelopeState
+}
+
+func (s *vrc7Slot) keyOn() {
+	s.phase = 0
+	s.state = vrc7Attack
+}
+
+func (s *vrc7Slot) keyOff() {
+	s.state = vrc7Release
+}
+
+// clockEnvelope advances the envelope by one sample. Attack is exponential,
+// the other phases linear in dB. Sustained instruments hold at the sustain
+// level until released, while the others carry on decaying at their release
+// rate. Once released, the channel's sustain bit and percussive instruments
+// override the release rate.
+func (s *vrc7Slot) clockEnvelope(op vrc7Operator, c *vrc7Channel) {
+	var rate uint8
+	switch s.state {
+	case vrc7Attack:
+		rate = op.attack
+	case vrc7Decay:
+		rate = op.decay
+	case vrc7Sustain:
+		if op.sustained {
+			return
+		}
+		rate = op.release
+	case vrc7Release:
+		switch {
+		case c.sustain:
+			rate = 5
+		case op.sustained:
+			rate = op.release
+		default:
+			rate = 7
+		}
+	}
+	if rate == 0 {
+		return
+	}
+
+	effective := int(rate)*4 + c.keyScaleRate(op.keyScale)
+	if effective > 63 {
+		effective = 63
+	}
+	step := float64(4+effective&0x03) * math.Exp2(float64(effective>>2)) / 65536
+
+	if s.state == vrc7Attack {
+		if effective >= 60 {
+			s.envelope = 0
+		} else {
+			s.envelope -= (s.envelope + 1) * math.Min(step/2, 1)
+		}
+		if s.envelope <= 0 {
+			s.envelope = 0
+			s.state = vrc7Decay
+		}
+		return
+	}
+
+	s.envelope = math.Min(s.envelope+step, vrc7Silent)
+	if sustain := float64(op.sustain) * 8; s.state == vrc7Decay && s.envelope >= sustain {
+		s.envelope = sustain
+		s.state = vrc7Sustain
+	}
+}
+
+// vrc7Channel is one of the six FM channels: a modulator operator that
+// modulates the phase of a carrier operator, which is heard.
+type vrc7Channel struct {
+	fnumber    uint16
+	octave     uint8
+	key        bool
+	sustain    bool
+	instrument uint8
+	volume     uint8
+
+	modulator vrc7Slot
+	carrier   vrc7Slot
+	feedback  [2]float64
+}
+
+func (c *vrc7Channel) setKey(key bool) {
+	if key && !c.key {
+		c.modulator.keyOn()
+		c.carrier.keyOn()
+	} else if !key && c.key {
+		c.modulator.keyOff()
+		c.carrier.keyOff()
+	}
+	c.key = key
+}
+
+// keyScaleRate speeds envelopes up for higher notes, in quarter rate steps
+func (c *vrc7Channel) keyScaleRate(full bool) int {
+	rate := int(c.octave)<<1 | int(c.fnumber>>8)
+	if !full {
+		rate >>= 2
+	}
+	return rate
+}
+
+// keyScaleLevel returns the attenuation, in envelope steps, for a key scale
+// level setting of 1.5, 3 or 6dB per octave.
+func (c *vrc7Channel) keyScaleLevel(setting uint8) float64 {
+	if setting == 0 {
+		return 0
+	}
+	db := vrc7KeyScale[c.fnumber>>5] - 6*float64(7-c.octave)
+	if db <= 0 {
+		return 0
+	}
+	return db / float64(uint(1)<<(3-setting)) / vrc7StepDB
+}
+
+// step advances an operator's phase, with vibrato if enabled
+func (c *vrc7Channel) step(s *vrc7Slot, op vrc7Operator, vibrato int) {
+	fnumber := uint32(c.fnumber) << 8
+	if op.vibrato {
+		fnumber = uint32(int(fnumber) + int(c.fnumber)*vibrato)
+	}
+	s.phase += (fnumber << c.octave) * op.multiple >> 9
+	s.phase &= 1<<vrc7PhaseBit - 1
+}
+
+// operate runs one operator, with its phase offset by modulation given in
+// cycles, and returns its output between -1 and 1.
+func (c *vrc7Channel) operate(s *vrc7Slot, op vrc7Operator, level float64, tremolo float64, modulation float64) float64 {
+	if s.envelope >= vrc7Silent {
+		return 0
+	}
+
+	attenuation := s.envelope + level + c.keyScaleLevel(op.scaleLevel)
+	if op.tremolo {
+		attenuation += tremolo
+	}
+
+	phase := float64(s.phase)/(1<<vrc7PhaseBit) + modulation
+	wave := math.Sin(2 * math.Pi * phase)
+	if op.rectified && wave < 0 {
+		wave = 0
+	}
+
+	return wave * math.Pow(10, -attenuation*vrc7StepDB/20)
+}
+
+// sample makes the channel's next sample. The modulator feeds back into
+// itself by up to two cycles of phase, and swings the carrier's phase by up
+// to four cycles.
+func (c *vrc7Channel) sample(patch *vrc7Patch, tremolo float64, vibrato int) float64 {
+	modulator, carrier := patch.operator(0), patch.operator(1)
+
+	c.step(&c.modulator, modulator, vibrato)
+	c.step(&c.carrier, carrier, vibrato)
+	c.modulator.clockEnvelope(modulator, c)
+	c.carrier.clockEnvelope(carrier, c)
+
+	var feedback float64
+	if shift := patch[3] & 0x07; shift != 0 {
+		feedback = (c.feedback[0] + c.feedback[1]) / float64(uint(1)<<(7-shift))
+	}
+	modulation := c.operate(&c.modulator, modulator, float64(modulator.level)*2, tremolo, feedback)
+	c.feedback[1], c.feedback[0] = c.feedback[0], modulation
+
+	return c.operate(&c.carrier, carrier, float64(c.volume)*8, tremolo, modulation*4)
+}
+
+// vrc7Audio is the VRC7's FM synthesizer. Registers are selected at $9010
+// and written at $9030. $00-$07 hold the custom instrument, and each channel
+// has an F-number low byte at $10-$15, key, sustain, octave and F-number high
+// bit at $20-$25, and instrument and volume at $30-$35.
+type vrc7Audio struct {
+	register uint8
+	custom   vrc7Patch
+	channels [6]vrc7Channel
+	reset    bool
+
+	cycles  int
+	samples int
+	level   float64
+}
+
+func (a *vrc7Audio) selectRegister(value uint8) {
+	a.register = value
+}
+
+func (a *vrc7Audio) write(value uint8) {
+	if a.reset {
+		return
+	}
+
+	register := a.register
+	if register < 0x08 {
+		a.custom[register] = value
+		return
+	}
+
+	index := int(register & 0x0f)
+	if index >= len(a.channels) {
+		return
+	}
+	channel := &a.channels[index]
+
+	switch register & 0xf0 {
+	case 0x10:
+		channel.fnumber = channel.fnumber&0x100 | uint16(value)
+	case 0x20:
+		channel.fnumber = channel.fnumber&0xff | uint16(value&0x01)<<8
+		channel.octave = (value >> 1) & 0x07
+		channel.sustain = value&0x20 != 0
+		channel.setKey(value&0x10 != 0)
+	case 0x30:
+		channel.instrument = value >> 4
+		channel.volume = value & 0x0f
+	}
+}
+
+// init starts every operator silent, as if released long ago
+func (a *vrc7Audio) init() {
+	for i := range a.channels {
+		for _, slot := range []*vrc7Slot{&a.channels[i].modulator, &a.channels[i].carrier} {
+			slot.envelope = vrc7Silent
+			slot.state = vrc7Release
+		}
+	}
+}
+
+// setReset holds the chip in reset, silencing it and clearing its registers
+func (a *vrc7Audio) setReset(reset bool) {
+	if reset {
+		*a = vrc7Audio{}
+		a.init()
+	}
+	a.reset = reset
+}
+
+func (a *vrc7Audio) patch(channel *vrc7Channel) *vrc7Patch {
+	if channel.instrument == 0 {
+		return &a.custom
+	}
+	return &vrc7Patches[channel.instrument]
+}
+
+func (a *vrc7Audio) clock() {
+	if a.reset {
+		return
+	}
+
+	a.cycles++
+	if a.cycles < vrc7SampleCycles {
+		return
+	}
+	a.cycles = 0
+	a.samples++
+
+	// Tremolo is a 3.7Hz triangle wave of up to 4.875dB, and vibrato steps
+	// every 1024 samples.
+	tremolo := (a.samples >> 9) % 26
+	if tremolo >= 13 {
+		tremolo = 25 - tremolo
+	}
+	vibrato := vrc7Vibrato[a.samples>>10&0x07]
+
+	a.level = 0
+	for i := range a.channels {
+		channel := &a.channels[i]
+		a.level += channel.sample(a.patch(channel), float64(tremolo), vibrato)
+	}
+}
+
+// output gives the latest sample. A channel at full volume is about as loud
+// as a 2A03 pulse channel at full volume.
+func (a *vrc7Audio) output() float32 {
+	return float32(a.level * 95.88 / (8128/15 + 100))
+}
+
+func (a *vrc7Audio) serialize(s *state.Stream) {
+	s.Uint8(&a.register)
+	serializeBanks(s, a.custom[:])
+	for i := range a.channels {
+		channel := &a.channels[i]
+		s.Uint16(&channel.fnumber)
+		s.Uint8(&channel.octave)
+		s.Bool(&channel.key)
+		s.Bool(&channel.sustain)
+		s.Uint8(&channel.instrument)
+		s.Uint8(&channel.volume)
+		for _, slot := range []*vrc7Slot{&channel.modulator, &channel.carrier} {
+			s.Uint32(&slot.phase)
+			s.Float64(&slot.envelope)
+			envelopeState := int(slot.state)
+			s.Int(&envelopeState)
+			slot.state = vrc7EnvelopeState(envelopeState)
+		}
+		s.Float64(&channel.feedback[0])
+		s.Float64(&channel.feedback[1])
+	}
+	s.Bool(&a.reset)
+
+	s.Int(&a.cycles)
+	s.Int(&a.samples)
+	s.Float64(&a.level)
+}
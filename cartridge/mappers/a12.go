@@ -0,0 +1,30 @@
+package mapper
+
+import "github.com/makononov/NESGo/state"
+
+// a12LowReads is how many PPU reads in a row A12 must stay low before a rise
+// counts. It filters out the brief dips between sprite pattern fetches, so a
+// scanline clocks the counter once.
+const a12LowReads = 3
+
+// a12Filter watches the PPU address bus for the rising edges of A12 that
+// MMC3-style scanline counters clock on. With background patterns at $0000
+// and sprites at $1000, A12 rises once per scanline as sprite fetches begin.
+type a12Filter struct {
+	low int
+}
+
+// rising reports whether a PPU read at address is a filtered rise of A12
+func (f *a12Filter) rising(address uint16) bool {
+	if address&0x1000 == 0 {
+		f.low++
+		return false
+	}
+	rose := f.low >= a12LowReads
+	f.low = 0
+	return rose
+}
+
+func (f *a12Filter) serialize(s *state.Stream) {
+	s.Int(&f.low)
+}
@@ -0,0 +1,104 @@
+package mapper
+
+import (
+	"errors"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// Nametable arrangements, given as the VRAM page each of the four logical
+// nametables maps to.
+var (
+	mirrorVertical   = [4]int{0, 1, 0, 1}
+	mirrorHorizontal = [4]int{0, 0, 1, 1}
+	mirrorSingleA    = [4]int{0, 0, 0, 0}
+	mirrorSingleB    = [4]int{1, 1, 1, 1}
+)
+
+// bankOffset returns where an address falls in PRG or CHR data when the
+// window containing it maps the given bank. Negative banks count back from
+// the end of the data, so -1 is the last bank.
+func bankOffset(data []byte, bank int, size int, address uint16) int {
+	banks := len(data) / size
+	if banks == 0 {
+		banks = 1
+	}
+	bank %= banks
+	if bank < 0 {
+		bank += banks
+	}
+	return (bank*size + int(address)%size) % len(data)
+}
+
+// ppuBanks maps the PPU address space through eight 1KB CHR banks and a
+// nametable arrangement, which covers most ASIC mappers. Mappers embed it to
+// implement mapper.PPUMapper and mapper.CHRRAMMapper.
+type ppuBanks struct {
+	CHR  []byte
+	vram []byte
+
+	// chrROM is how much of CHR is ROM. Anything after it is CHR RAM.
+	chrROM int
+
+	chrBanks  [8]int
+	mirroring [4]int
+}
+
+// InitPPU implements mapper.PPUMapper
+func (b *ppuBanks) InitPPU(chr []byte, vram []byte) error {
+	if len(chr) == 0 {
+		return errors.New("CHR ROM is empty")
+	}
+	b.CHR = chr
+	b.chrROM = len(chr)
+	b.vram = vram
+	for i := range b.chrBanks {
+		b.chrBanks[i] = i
+	}
+	return nil
+}
+
+// ReadPPU implements mapper.PPUMapper
+func (b *ppuBanks) ReadPPU(address uint16) (byte, error) {
+	address &= 0x3fff
+	if address < 0x2000 {
+		return b.CHR[b.chrOffset(address)], nil
+	}
+	return b.vram[b.nametableOffset(address)], nil
+}
+
+// WritePPU implements mapper.PPUMapper
+func (b *ppuBanks) WritePPU(address uint16, value byte) error {
+	address &= 0x3fff
+	if address < 0x2000 {
+		offset := b.chrOffset(address)
+		if offset < b.chrROM {
+			return errors.New("Attempt to write to CHR ROM")
+		}
+		b.CHR[offset] = value
+		return nil
+	}
+	b.vram[b.nametableOffset(address)] = value
+	return nil
+}
+
+// SetCHRRAM implements mapper.CHRRAMMapper
+func (b *ppuBanks) SetCHRRAM(romSize int) {
+	b.chrROM = romSize
+}
+
+func (b *ppuBanks) chrOffset(address uint16) int {
+	return bankOffset(b.CHR, b.chrBanks[address/0x400], 0x400, address)
+}
+
+func (b *ppuBanks) nametableOffset(address uint16) int {
+	page := b.mirroring[(address>>10)&0x03]
+	return (page*0x400 + int(address&0x3ff)) % len(b.vram)
+}
+
+// serialize writes or reads the bank registers and mirroring. CHR RAM is
+// saved by the cartridge, and VRAM is the console's.
+func (b *ppuBanks) serialize(s *state.Stream) {
+	s.Ints(b.chrBanks[:])
+	s.Ints(b.mirroring[:])
+}
@@ -0,0 +1,165 @@
+package mapper
+
+import "github.com/makononov/NESGo/state"
+
+// What an EEPROM is doing between start and stop conditions
+const (
+	eepromStandby = iota
+	eepromDevice
+	eepromAddress
+	eepromWrite
+	eepromRead
+)
+
+// eeprom24C is a 24C01 or 24C02 serial EEPROM, driven bit by bit over its
+// two-wire bus. Data is shifted in on rising edges of SCL and out while it
+// is low, and the chip acknowledges each byte it receives by pulling SDA
+// low for a ninth clock.
+//
+// The 24C02 expects a device address before the word address, while the
+// X24C01 has no device address and takes the read/write bit with its 7-bit
+// word address.
+type eeprom24C struct {
+	data   []byte
+	x24c01 bool
+
+	scl bool
+	sda bool
+	out bool
+
+	state   int
+	shift   uint8
+	bits    int
+	acking  bool
+	address int
+}
+
+func newEEPROM24C(size int) *eeprom24C {
+	e := &eeprom24C{data: make([]byte, size), x24c01: size <= 128, out: true}
+	for i := range e.data {
+		e.data[i] = 0xff
+	}
+	return e
+}
+
+// set drives SCL and SDA to new levels
+func (e *eeprom24C) set(scl bool, sda bool) {
+	switch {
+	case scl && e.scl && sda != e.sda:
+		if sda {
+			// stop
+			e.state = eepromStandby
+			e.acking = false
+		} else {
+			e.start()
+		}
+		e.out = true
+	case scl && !e.scl:
+		e.rise(sda)
+	case !scl && e.scl:
+		e.fall()
+	}
+	e.scl, e.sda = scl, sda
+}
+
+func (e *eeprom24C) start() {
+	e.state = eepromDevice
+	if e.x24c01 {
+		e.state = eepromAddress
+	}
+	e.shift = 0
+	e.bits = 0
+	e.acking = false
+}
+
+// rise clocks in a bit, or the ninth clock where a byte is acknowledged
+func (e *eeprom24C) rise(sda bool) {
+	if e.acking {
+		e.acking = false
+		e.bits = 0
+		e.shift = 0
+		return
+	}
+
+	switch e.state {
+	case eepromDevice, eepromAddress, eepromWrite:
+		e.shift <<= 1
+		if sda {
+			e.shift |= 0x01
+		}
+		e.bits++
+		if e.bits == 8 {
+			e.receive()
+		}
+	case eepromRead:
+		if e.bits < 8 {
+			e.bits++
+			return
+		}
+		// The game acknowledges to read on, or leaves SDA high to stop
+		if sda {
+			e.state = eepromStandby
+			return
+		}
+		e.address = (e.address + 1) % len(e.data)
+		e.bits = 0
+	}
+}
+
+// fall drives SDA for the next clock: an acknowledgement, a bit being read,
+// or nothing.
+func (e *eeprom24C) fall() {
+	switch {
+	case e.acking:
+		e.out = false
+	case e.state == eepromRead && e.bits < 8:
+		e.out = e.data[e.address]>>uint(7-e.bits)&0x01 != 0
+	default:
+		e.out = true
+	}
+}
+
+// receive handles a byte shifted in from the game
+func (e *eeprom24C) receive() {
+	switch e.state {
+	case eepromDevice:
+		if e.shift&0xf0 != 0xa0 {
+			e.state = eepromStandby
+			return
+		}
+		e.state = eepromAddress
+		if e.shift&0x01 != 0 {
+			e.state = eepromRead
+		}
+	case eepromAddress:
+		e.state = eepromWrite
+		e.address = int(e.shift) % len(e.data)
+		if e.x24c01 {
+			e.address = int(e.shift>>1) % len(e.data)
+			if e.shift&0x01 != 0 {
+				e.state = eepromRead
+			}
+		}
+	case eepromWrite:
+		e.data[e.address] = e.shift
+		// Writes wrap within a page of 4 bytes on the X24C01, 8 on the 24C02
+		page := 8
+		if e.x24c01 {
+			page = 4
+		}
+		e.address = e.address&^(page-1) | (e.address+1)&(page-1)
+	}
+	e.acking = true
+}
+
+func (e *eeprom24C) serialize(s *state.Stream) {
+	s.Bytes(e.data)
+	s.Bool(&e.scl)
+	s.Bool(&e.sda)
+	s.Bool(&e.out)
+	s.Int(&e.state)
+	s.Uint8(&e.shift)
+	s.Int(&e.bits)
+	s.Bool(&e.acking)
+	s.Int(&e.address)
+}
@@ -0,0 +1,123 @@
+package mapper
+
+import (
+	"io"
+
+	"github.com/makononov/NESGo/cartridge/patch"
+	"github.com/makononov/NESGo/state"
+)
+
+// Steps through the SST39SF040's command sequences. Every command starts by
+// writing $AA to $5555 and $55 to $2AAA; erasing repeats that unlock before
+// saying what to erase.
+const (
+	flashIdle = iota
+	flashUnlocking
+	flashUnlocked
+	flashEraseIdle
+	flashEraseUnlocking
+	flashEraseUnlocked
+	flashProgram
+)
+
+// SST's manufacturer ID and the SST39SF040's device ID
+const (
+	flashManufacturerID = 0xbf
+	flashDeviceID       = 0xb7
+)
+
+// sstFlash is the command interface of the SST39SF040 flash chip that
+// self-flashing homebrew boards use for PRG ROM, so games can save by
+// reprogramming it. Programming can only clear bits; erasing sets a 4KB
+// sector or the whole chip back to $FF. Both finish instantly.
+type sstFlash struct {
+	data     []byte
+	original []byte
+	step     int
+	id       bool
+}
+
+func (f *sstFlash) init(data []byte) {
+	f.data = data
+	f.original = append([]byte(nil), data...)
+}
+
+// read returns the byte at a chip address, or the chip's IDs while in
+// software ID mode.
+func (f *sstFlash) read(address int) byte {
+	if f.id {
+		if address&0x01 == 0 {
+			return flashManufacturerID
+		}
+		return flashDeviceID
+	}
+	return f.data[address%len(f.data)]
+}
+
+// write feeds a write at a chip address to the command sequence
+func (f *sstFlash) write(address int, value byte) {
+	command := address & 0x7fff
+	step := f.step
+	f.step = flashIdle
+
+	switch step {
+	case flashIdle, flashEraseIdle:
+		if command == 0x5555 && value == 0xaa {
+			f.step = step + 1
+		} else if value == 0xf0 {
+			f.id = false
+		}
+	case flashUnlocking, flashEraseUnlocking:
+		if command == 0x2aaa && value == 0x55 {
+			f.step = step + 1
+		}
+	case flashUnlocked:
+		if command != 0x5555 {
+			return
+		}
+		switch value {
+		case 0xa0:
+			f.step = flashProgram
+		case 0x80:
+			f.step = flashEraseIdle
+		case 0x90:
+			f.id = true
+		case 0xf0:
+			f.id = false
+		}
+	case flashEraseUnlocked:
+		if value == 0x30 {
+			sector := address % len(f.data) &^ 0xfff
+			f.erase(f.data[sector : sector+0x1000])
+		} else if value == 0x10 && command == 0x5555 {
+			f.erase(f.data)
+		}
+	case flashProgram:
+		f.data[address%len(f.data)] &= value
+	}
+}
+
+func (f *sstFlash) erase(data []byte) {
+	for i := range data {
+		data[i] = 0xff
+	}
+}
+
+// loadSave applies a save written by writeSave to the chip
+func (f *sstFlash) loadSave(save io.Reader) error {
+	return applyIPS(f.data, save)
+}
+
+// writeSave writes what the game has reflashed as an IPS patch against the
+// original ROM.
+func (f *sstFlash) writeSave(save io.Writer) error {
+	return patch.WriteIPS(save, f.original, f.data)
+}
+
+// serialize writes or reads the chip's contents, which the game may have
+// reprogrammed, and where it is in a command sequence
+func (f *sstFlash) serialize(s *state.Stream) {
+	s.Bytes(f.data)
+	s.Int(&f.step)
+	s.Bool(&f.id)
+}
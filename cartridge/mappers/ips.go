@@ -0,0 +1,29 @@
+package mapper
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/makononov/NESGo/cartridge/patch"
+)
+
+// applyIPS applies an IPS patch written by patch.WriteIPS to data in place.
+// Saves are diffs against the image they were made from, so a patch that
+// changes its size doesn't belong to it.
+func applyIPS(data []byte, save io.Reader) error {
+	body, err := ioutil.ReadAll(save)
+	if err != nil {
+		return err
+	}
+
+	patched, err := patch.IPS(data, body)
+	if err != nil {
+		return err
+	}
+	if len(patched) != len(data) {
+		return errors.New("IPS save is for an image of a different size")
+	}
+	copy(data, patched)
+	return nil
+}
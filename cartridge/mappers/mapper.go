@@ -1,8 +1,88 @@
 package mapper
 
+import "io"
+
 // A Mapper maps cartridge ROM into CPU ROM.
 type Mapper interface {
 	Init(prg []byte) error
 	Read(address uint16) (byte, error)
 	Write(address uint16, value byte) error
 }
+
+// A StateMapper can be saved in save states. SaveState and LoadState save
+// and restore its registers and any memory of its own. Save states can't
+// be made of a cartridge whose mapper isn't one.
+type StateMapper interface {
+	SaveState(w io.Writer) error
+	LoadState(r io.Reader) error
+}
+
+// A LowMapper decodes the cartridge space below $8000 ($4020-$7FFF) itself,
+// for boards with registers, internal RAM or banked PRG RAM there. Mappers
+// that don't implement it get the cartridge's own RAM at $6000-$7FFF.
+type LowMapper interface {
+	ReadLow(address uint16) (byte, error)
+	WriteLow(address uint16, value byte) error
+}
+
+// A PPUMapper maps CHR memory and the nametables into the PPU address space
+// ($0000-$3EFF). vram is the console's 2KB of nametable RAM, or 4KB when the
+// board provides the extra RAM for four-screen mirroring.
+type PPUMapper interface {
+	InitPPU(chr []byte, vram []byte) error
+	ReadPPU(address uint16) (byte, error)
+	WritePPU(address uint16, value byte) error
+}
+
+// A CHRRAMMapper can have CHR RAM as well as, or instead of, CHR ROM. The
+// cartridge appends the RAM to the CHR passed to InitPPU, so that banks past
+// the end of the ROM select it, and then says how much of it is ROM.
+type CHRRAMMapper interface {
+	SetCHRRAM(romSize int)
+}
+
+// A BusMonitor observes CPU writes to the PPU registers ($2000-$3FFF), which
+// are outside the cartridge's address range, as mappers that snoop them do.
+type BusMonitor interface {
+	MonitorWrite(address uint16, value byte)
+}
+
+// A ClockedMapper is clocked once per CPU cycle.
+type ClockedMapper interface {
+	Clock()
+}
+
+// An IRQMapper can assert the CPU's IRQ line. The line is level-triggered,
+// so IRQ must keep returning true until the game acknowledges the interrupt.
+type IRQMapper interface {
+	IRQ() bool
+}
+
+// An AudioMapper generates expansion audio. Output returns the current level
+// on the same scale as the 2A03's mixed output, where 1.0 is its full-scale
+// level.
+type AudioMapper interface {
+	Output() float32
+}
+
+// A SaveMapper has state that outlives a power cycle, such as battery-backed
+// RAM, flash or a writable disk, which is persisted to a save file.
+type SaveMapper interface {
+	LoadSave(r io.Reader) error
+	WriteSave(w io.Writer) error
+}
+
+// A TrainerMapper has PRG RAM of its own at $7000-$71FF that a 512-byte
+// trainer can be loaded into. A LowMapper that isn't one has no RAM there,
+// or has registers in the way.
+type TrainerMapper interface {
+	LoadTrainer(trainer []byte) error
+}
+
+// A DiskMapper is a disk drive whose disk can be ejected and flipped or
+// swapped for another side.
+type DiskMapper interface {
+	Sides() int
+	InsertDisk(side int) error
+	EjectDisk()
+}
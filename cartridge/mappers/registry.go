@@ -0,0 +1,131 @@
+package mapper
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// AnySubmapper registers a factory for every submapper of a mapper number
+// that has no factory of its own.
+const AnySubmapper = -1
+
+// A Header holds what the ROM header says about a cartridge, for factories
+// to configure the mappers they create.
+type Header struct {
+	MapperID   int
+	Submapper  int
+	Vertical   bool
+	FourScreen bool
+	Battery    bool
+
+	// PRG is the PRG ROM, for factories that have to inspect it to tell
+	// boards apart.
+	PRG []byte
+}
+
+// A Factory creates a mapper for a cartridge and describes it
+type Factory struct {
+	Name string
+
+	// Boards lists the cartridge boards that use the mapper
+	Boards []string
+
+	// Submappers lists the NES 2.0 submappers the factory tells apart
+	Submappers []int
+
+	IRQ   bool
+	Audio bool
+
+	New func(header Header) (Mapper, error)
+}
+
+// A Registration is a factory registered for a mapper number and submapper
+type Registration struct {
+	Factory
+
+	ID        int
+	Submapper int
+}
+
+type registryKey struct {
+	id        int
+	submapper int
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[registryKey]Factory)
+)
+
+// Register makes a mapper available to cartridges with the given mapper
+// number and submapper, or any submapper with AnySubmapper. It is meant to be
+// called from init functions, including those of packages outside this one
+// that add their own mappers, and panics if the mapper and submapper are
+// already registered or the factory can't create anything.
+func Register(id int, submapper int, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if factory.New == nil {
+		panic(fmt.Sprintf("mapper: Register with no New function for mapper %d", id))
+	}
+	key := registryKey{id, submapper}
+	if _, ok := registry[key]; ok {
+		panic(fmt.Sprintf("mapper: Register called twice for mapper %d, submapper %d", id, submapper))
+	}
+	registry[key] = factory
+}
+
+// An UnsupportedError is returned by New for a mapper number that has no
+// implementation registered.
+type UnsupportedError struct {
+	MapperID  int
+	Submapper int
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("Mapper %d not yet implemented", e.MapperID)
+}
+
+// Lookup returns the factory registered for a mapper number and submapper,
+// falling back to the one registered for any submapper.
+func Lookup(id int, submapper int) (Factory, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	factory, ok := registry[registryKey{id, submapper}]
+	if !ok {
+		factory, ok = registry[registryKey{id, AnySubmapper}]
+	}
+	return factory, ok
+}
+
+// New creates the mapper Lookup finds for a header's mapper number and
+// submapper.
+func New(header Header) (Mapper, error) {
+	factory, ok := Lookup(header.MapperID, header.Submapper)
+	if !ok {
+		return nil, &UnsupportedError{header.MapperID, header.Submapper}
+	}
+	return factory.New(header)
+}
+
+// Registered lists the registered mappers, ordered by mapper number and then
+// submapper.
+func Registered() []Registration {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	registrations := make([]Registration, 0, len(registry))
+	for key, factory := range registry {
+		registrations = append(registrations, Registration{factory, key.id, key.submapper})
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		if registrations[i].ID != registrations[j].ID {
+			return registrations[i].ID < registrations[j].ID
+		}
+		return registrations[i].Submapper < registrations[j].Submapper
+	})
+	return registrations
+}
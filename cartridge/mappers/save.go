@@ -0,0 +1,19 @@
+package mapper
+
+import "io"
+
+// loadRAM fills battery-backed memory from a save. An empty save leaves the
+// memory as it is.
+func loadRAM(save io.Reader, ram []byte) error {
+	_, err := io.ReadFull(save, ram)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// writeRAM writes battery-backed memory out as a save
+func writeRAM(save io.Writer, ram []byte) error {
+	_, err := save.Write(ram)
+	return err
+}
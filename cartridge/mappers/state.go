@@ -0,0 +1,29 @@
+package mapper
+
+import (
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// stateVersion is the version of the mappers' save state sections. A mapper
+// that saves more in a later version checks s.Version() when loading, so
+// that older states still load.
+const stateVersion = 1
+
+// saveState writes a mapper's save state section
+func saveState(w io.Writer, serialize func(s *state.Stream)) error {
+	return state.Save(w, stateVersion, serialize)
+}
+
+// loadState reads a mapper's save state section
+func loadState(r io.Reader, serialize func(s *state.Stream)) error {
+	return state.Load(r, stateVersion, serialize)
+}
+
+// serializeBanks writes or reads an array of bank registers
+func serializeBanks(s *state.Stream, banks []uint8) {
+	for i := range banks {
+		s.Uint8(&banks[i])
+	}
+}
@@ -0,0 +1,76 @@
+package mapper
+
+import "github.com/makononov/NESGo/state"
+
+// vrcIRQ is the IRQ counter Konami shares between the VRC4, VRC6 and VRC7.
+// It counts CPU cycles, either directly or through a prescaler that divides
+// them into scanlines of 113 2/3 cycles.
+type vrcIRQ struct {
+	latch       uint8
+	counter     uint8
+	prescaler   int
+	enabled     bool
+	enableOnAck bool
+	cycleMode   bool
+	pending     bool
+}
+
+func (q *vrcIRQ) setLatchLow(value uint8) {
+	q.latch = q.latch&0xf0 | value&0x0f
+}
+
+func (q *vrcIRQ) setLatchHigh(value uint8) {
+	q.latch = q.latch&0x0f | value<<4
+}
+
+func (q *vrcIRQ) setLatch(value uint8) {
+	q.latch = value
+}
+
+func (q *vrcIRQ) setControl(value uint8) {
+	q.enableOnAck = value&0x01 != 0
+	q.enabled = value&0x02 != 0
+	q.cycleMode = value&0x04 != 0
+	q.pending = false
+
+	if q.enabled {
+		q.counter = q.latch
+		q.prescaler = 341
+	}
+}
+
+func (q *vrcIRQ) acknowledge() {
+	q.pending = false
+	q.enabled = q.enableOnAck
+}
+
+func (q *vrcIRQ) clock() {
+	if !q.enabled {
+		return
+	}
+
+	if !q.cycleMode {
+		q.prescaler -= 3
+		if q.prescaler > 0 {
+			return
+		}
+		q.prescaler += 341
+	}
+
+	if q.counter == 0xff {
+		q.counter = q.latch
+		q.pending = true
+	} else {
+		q.counter++
+	}
+}
+
+func (q *vrcIRQ) serialize(s *state.Stream) {
+	s.Uint8(&q.latch)
+	s.Uint8(&q.counter)
+	s.Int(&q.prescaler)
+	s.Bool(&q.enabled)
+	s.Bool(&q.enableOnAck)
+	s.Bool(&q.cycleMode)
+	s.Bool(&q.pending)
+}
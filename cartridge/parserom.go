@@ -1,73 +1,164 @@
 package cartridge
 
 import (
-	"encoding/hex"
-	"errors"
-	"fmt"
+	"io"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
 
 	"github.com/makononov/NESGo/cartridge/mappers"
+	"github.com/makononov/NESGo/cartridge/patch"
 )
 
-// ParseROM parses a ROM file and returns a cartridge object for use by the
-// system.
-func ParseROM(filename string) (*Cartridge, error) {
-	fmt.Printf("Parsing %s...\n", filename)
+// ParseROM parses an iNES, NES 2.0, UNIF or FDS file and returns a cartridge
+// object for use by the system. The file may be compressed in a zip or gzip
+// archive. Its save, if the cartridge has one, is kept next to it.
+//
+// The patches, if any, are applied in order to the image before it is
+// parsed. Without any, a patch named after the ROM file is looked for next
+// to it; see AutoPatch. The files themselves are never modified.
+func ParseROM(filename string, patches ...string) (*Cartridge, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	if data, err = unpack(data); err != nil {
+		return nil, err
+	}
 
-	cart := new(Cartridge)
+	if len(patches) == 0 && AutoPatch {
+		patches = findPatches(filename)
+	}
+	for _, name := range patches {
+		if data, err = applyPatchFile(data, name); err != nil {
+			return nil, err
+		}
+	}
 
-	// Verify magic number
-	if string(data[0:4]) != "NES\u001a" {
-		return nil, fmt.Errorf("Invalid magic number in ROM file: %s", hex.Dump(data[0:4]))
+	cart, err := parse(data, filename)
+	if err != nil {
+		return nil, err
 	}
+	cart.Patches = patches
 
-	cart.SetPrgRomSize(int(data[4]))
-	fmt.Printf("Found ROM size: %d\n", cart.PrgRomSize)
-	cart.SetChrRomSize(int(data[5]))
-	fmt.Printf("Found CHR size: %d\n", cart.ChrRomSize)
+	cart.SavePath = ROMBase(filename) + ".sav"
+	if err = cart.LoadSave(); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
 
-	if err = parseFlags6(cart, data[6]); err != nil {
+// Parse reads a ROM image, which may be compressed, from r, applying any
+// patches to it in order. The cartridge has no SavePath; set one and call
+// LoadSave to persist its save.
+func Parse(r io.Reader, patches ...[]byte) (*Cartridge, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
 		return nil, err
 	}
+	return ParseBytes(data, patches...)
+}
 
-	var nes2 bool
-	if nes2, err = parseFlags7(cart, data[7]); err != nil {
+// ParseBytes parses a ROM image, which may be compressed, held in memory,
+// applying any patches to it in order.
+func ParseBytes(data []byte, patches ...[]byte) (*Cartridge, error) {
+	data, err := unpack(data)
+	if err != nil {
 		return nil, err
 	}
+	for _, p := range patches {
+		if data, err = patch.Apply(data, p); err != nil {
+			return nil, err
+		}
+	}
+	return parse(data, "")
+}
 
-	if nes2 {
-		return nil, errors.New("This in an NES2.0 ROM, which is currently not supported.")
+// parse sets up a cartridge for an uncompressed ROM or disk image. The
+// filename, if any, is used to look for the FDS BIOS next to disk images.
+func parse(data []byte, filename string) (*Cartridge, error) {
+	cart := new(Cartridge)
+	var err error
+	switch {
+	case isFDS(data):
+		err = parseFDS(cart, filename, data)
+	case isUNIF(data):
+		err = parseUNIF(cart, data)
+	default:
+		err = parseINES(cart, data)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// ROMBase returns the ROM file's name without its archive and ROM
+// extensions, which saves, patches and other files kept for the ROM are
+// named after.
+func ROMBase(filename string) string {
+	base := filename
+	switch strings.ToLower(filepath.Ext(base)) {
+	case ".zip", ".gz":
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// parseINES sets up a cartridge for an iNES or NES 2.0 ROM image.
+func parseINES(cart *Cartridge, data []byte) error {
+	header, err := ParseHeader(data)
+	if err != nil {
+		return err
+	}
+
+	cart.NES2 = header.NES2
+	cart.MapperID = header.MapperID
+	cart.Submapper = header.Submapper
+	cart.PrgRomSize = header.PrgRomSize
+	cart.ChrRomSize = header.ChrRomSize
+	cart.PrgRamSize = header.PrgRamSize
+	cart.PrgNvramSize = header.PrgNvramSize
+	cart.ChrRamSize = header.ChrRamSize
+	cart.ChrNvramSize = header.ChrNvramSize
+	cart.Mirroring = header.Mirroring
+	cart.FourScreen = header.FourScreen
+	cart.TrainerPresent = header.Trainer
+	cart.BatteryBackedSRAM = header.Battery
+	cart.TVSystemFormat = header.TVSystemFormat
+	cart.Playchoice10 = header.ConsoleType == ConsolePlaychoice10
+	cart.VsUnisystem = header.ConsoleType == ConsoleVsSystem
 
 	if cart.Playchoice10 {
-		return nil, errors.New("This is a Playchoice 10 ROM, which is currently not supported.")
+		return ErrPlaychoice10
 	}
 
 	if cart.VsUnisystem {
-		return nil, errors.New("This is a Vs Unisystem ROM, which is currently not supported.")
+		return ErrVsUnisystem
 	}
 
-	// Verify bytes 11-15 are zeroed
-	for _, num := range data[11:16] {
-		if int(num) != 0 {
-			fmt.Printf("ROM containes junk code in bytes 11-15: '%s'\n", string(data[10:16]))
-			break
-		}
+	if header.Junk != nil {
+		cart.Warnings = append(cart.Warnings, header.junkWarning())
 	}
 
-	if err = parseFlags9(cart, data[9]); err != nil {
-		return nil, err
+	// Each part is checked before the total, so that no size can overflow
+	// it or slip under the length check
+	for _, size := range []int{header.PrgRomSize, header.ChrRomSize} {
+		if size < 0 || size > maxROMSize {
+			return ErrInvalidHeader
+		}
+	}
+	if size := header.ImageSize(); size < HeaderSize {
+		return ErrInvalidHeader
+	} else if len(data) < size {
+		return ErrTruncated
 	}
 
-	position := 16
+	position := HeaderSize
 	if cart.TrainerPresent {
-		cart.Trainer = make([]byte, 512)
-		copy(cart.Trainer, data[position:position+512])
-		position = position + 512
+		cart.Trainer = make([]byte, TrainerSize)
+		copy(cart.Trainer, data[position:position+TrainerSize])
+		position = position + TrainerSize
 	}
 
 	// Initialize mapper
@@ -75,63 +166,64 @@ func ParseROM(filename string) (*Cartridge, error) {
 	prg := make([]byte, cart.PrgRomSize)
 	copy(prg, data[position:position+cart.PrgRomSize])
 
-	switch cart.MapperID {
-	case 0:
-		cart.Mapper = new(mapper.NROM)
-	case 1:
-		cart.Mapper = new(mapper.MMC1)
-	case 2:
-		cart.Mapper = new(mapper.UxROM)
-	default:
-		return nil, fmt.Errorf("Mapper %d not yet implemented", cart.MapperID)
-	}
-	cart.Mapper.Init(prg)
 	position = position + cart.PrgRomSize
 
 	cart.CHR = make([]byte, cart.ChrRomSize)
 	copy(cart.CHR, data[position:position+cart.ChrRomSize])
-	position = position + cart.ChrRomSize
 
-	return cart, nil
+	if err = identify(cart, prg); err != nil {
+		return err
+	}
+	return initMapper(cart, prg)
 }
 
-func parseFlags6(cart *Cartridge, flagByte byte) error {
-	flag := int(flagByte)
-	// bits 0-4 are the low nibble of the mapper ID
-	cart.MapperID = (cart.MapperID & 0xf0) | (flag&0xf0)>>4
-	cart.FourScreen = flag&0x08 == 1
-	cart.TrainerPresent = flag&0x04 == 1
-	cart.BatteryBackedSRAM = flag&0x02 == 1
-
-	if flag&0x01 == 0 {
-		cart.Mirroring = MirrorHorizontal
-	} else {
-		cart.Mirroring = MirrorVertical
+// chrRAMSize is how much CHR RAM the board has. NES 2.0 headers and the ROM
+// database give it; otherwise boards without CHR ROM are assumed to have 8KB.
+func (cartridge *Cartridge) chrRAMSize() int {
+	size := cartridge.ChrRamSize + cartridge.ChrNvramSize
+	if size == 0 && cartridge.ChrRomSize == 0 {
+		size = chrRomBlockSize
 	}
-
-	return nil
+	return size
 }
 
-func parseFlags7(cart *Cartridge, flagByte byte) (bool, error) {
-	flag := int(flagByte)
-	cart.MapperID = (cart.MapperID & 0x0f) | (flag & 0xf0)
-	cart.Playchoice10 = flag&0x02 == 1
-	cart.VsUnisystem = flag&0x01 == 1
+// initMapper creates the cartridge's mapper from the header fields already
+// parsed and hands it the PRG and CHR ROM.
+func initMapper(cart *Cartridge, prg []byte) error {
+	if cart.FourScreen {
+		cart.VRAM = make([]byte, 4096)
+	} else {
+		cart.VRAM = make([]byte, 2048)
+	}
 
-	return flag&0x0c == 0x08, nil
-}
+	if size := cart.chrRAMSize(); size > 0 {
+		cart.CHR = append(cart.CHR[:cart.ChrRomSize:cart.ChrRomSize], make([]byte, size)...)
+	}
 
-func parseFlags9(cart *Cartridge, flagByte byte) error {
-	flag := int(flagByte)
-	if flag&0xfe != 0 {
-		return errors.New("Invalid file format (flag 9)")
+	// Each mapper gets its own copy of the PRG ROM, as boards with flash
+	// reprogram it
+	cart.newMapper = func() (mapper.Mapper, error) {
+		m, err := mapper.New(mapper.Header{
+			MapperID:   cart.MapperID,
+			Submapper:  cart.Submapper,
+			Vertical:   cart.Mirroring == MirrorVertical,
+			FourScreen: cart.FourScreen,
+			Battery:    cart.BatteryBackedSRAM,
+			PRG:        prg,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return m, m.Init(append([]byte(nil), prg...))
 	}
 
-	if flag&0x01 == 0 {
-		cart.TVSystemFormat = NTSC
-	} else {
-		cart.TVSystemFormat = PAL
+	var err error
+	if cart.Mapper, err = cart.createMapper(); err != nil {
+		return err
 	}
 
+	if err = cart.Init(); err != nil {
+		cart.Warnings = append(cart.Warnings, err.Error()+", so it is ignored")
+	}
 	return nil
 }
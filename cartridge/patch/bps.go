@@ -0,0 +1,109 @@
+package patch
+
+const bpsMagic = "BPS1"
+
+// BPS actions, from the low two bits of each action number
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+// BPS applies a BPS patch to data, returning the patched copy. After the
+// source and target sizes and any metadata, the target is built by a series
+// of actions that copy from the source at the same position, take bytes from
+// the patch, or copy from elsewhere in the source or the target built so
+// far. The footer holds the CRC32s of the source, the target and the patch
+// itself, all of which are checked.
+func BPS(data []byte, patch []byte) ([]byte, error) {
+	if len(patch) < len(bpsMagic)+12 || string(patch[:len(bpsMagic)]) != bpsMagic {
+		return nil, ErrUnknownFormat
+	}
+	footer := patch[len(patch)-12:]
+	if err := verify("patch", patch[:len(patch)-4], footer[8:]); err != nil {
+		return nil, err
+	}
+	if err := verify("source ROM", data, footer[0:]); err != nil {
+		return nil, err
+	}
+
+	d := &decoder{patch: patch, position: len(bpsMagic), end: len(patch) - 12}
+	var sizes [3]int
+	for i := range sizes {
+		size, err := d.number()
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = size
+	}
+	sourceSize, targetSize, metadataSize := sizes[0], sizes[1], sizes[2]
+	if sourceSize != len(data) || metadataSize > d.end-d.position {
+		return nil, ErrOutOfRange
+	}
+	d.position += metadataSize
+	if err := checkSize(targetSize, sourceSize); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, targetSize)
+	var sourceOffset, targetOffset int
+	for d.position < d.end {
+		action, err := d.number()
+		if err != nil {
+			return nil, err
+		}
+		length := action>>2 + 1
+		if len(out)+length > targetSize {
+			return nil, ErrOutOfRange
+		}
+
+		switch action & 0x03 {
+		case bpsSourceRead:
+			if len(out)+length > len(data) {
+				return nil, ErrOutOfRange
+			}
+			out = append(out, data[len(out):len(out)+length]...)
+		case bpsTargetRead:
+			if d.position+length > d.end {
+				return nil, ErrTruncated
+			}
+			out = append(out, patch[d.position:d.position+length]...)
+			d.position += length
+		case bpsSourceCopy:
+			offset, err := d.offset()
+			if err != nil {
+				return nil, err
+			}
+			sourceOffset += offset
+			if sourceOffset < 0 || sourceOffset+length > len(data) {
+				return nil, ErrOutOfRange
+			}
+			out = append(out, data[sourceOffset:sourceOffset+length]...)
+			sourceOffset += length
+		case bpsTargetCopy:
+			offset, err := d.offset()
+			if err != nil {
+				return nil, err
+			}
+			targetOffset += offset
+			if targetOffset < 0 || targetOffset >= len(out) {
+				return nil, ErrOutOfRange
+			}
+			// The copy may overlap what it writes, repeating a pattern, so
+			// it has to go a byte at a time
+			for i := 0; i < length; i++ {
+				out = append(out, out[targetOffset])
+				targetOffset++
+			}
+		}
+	}
+
+	if len(out) != targetSize {
+		return nil, ErrTruncated
+	}
+	if err := verify("patched ROM", out, footer[4:]); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
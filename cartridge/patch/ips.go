@@ -0,0 +1,103 @@
+package patch
+
+import (
+	"bufio"
+	"io"
+)
+
+const ipsMagic = "PATCH"
+
+// ipsEOF is the marker ending an IPS patch, which can't be used as an offset
+const ipsEOF = 0x454f46
+
+// IPS applies an IPS patch to data, returning the patched copy. Each record
+// is a 24-bit offset and 16-bit size followed by that many bytes, or a size
+// of zero followed by a 16-bit run length and the byte to repeat. Records may
+// extend the ROM, and the truncate extension's 24-bit size after the EOF
+// marker cuts it short.
+func IPS(data []byte, patch []byte) ([]byte, error) {
+	if len(patch) < len(ipsMagic) || string(patch[:len(ipsMagic)]) != ipsMagic {
+		return nil, ErrUnknownFormat
+	}
+	out := append([]byte(nil), data...)
+
+	position := len(ipsMagic)
+	for {
+		if position+3 > len(patch) {
+			return nil, ErrTruncated
+		}
+		offset := int(patch[position])<<16 | int(patch[position+1])<<8 | int(patch[position+2])
+		position += 3
+		if offset == ipsEOF {
+			break
+		}
+
+		if position+2 > len(patch) {
+			return nil, ErrTruncated
+		}
+		size := int(patch[position])<<8 | int(patch[position+1])
+		position += 2
+
+		var record []byte
+		if size == 0 {
+			if position+3 > len(patch) {
+				return nil, ErrTruncated
+			}
+			size = int(patch[position])<<8 | int(patch[position+1])
+			record = make([]byte, size)
+			for i := range record {
+				record[i] = patch[position+2]
+			}
+			position += 3
+		} else {
+			if position+size > len(patch) {
+				return nil, ErrTruncated
+			}
+			record = patch[position : position+size]
+			position += size
+		}
+
+		if offset+size > len(out) {
+			out = append(out, make([]byte, offset+size-len(out))...)
+		}
+		copy(out[offset:], record)
+	}
+
+	if position+3 <= len(patch) {
+		if size := int(patch[position])<<16 | int(patch[position+1])<<8 | int(patch[position+2]); size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
+
+// WriteIPS writes an IPS patch that turns original into modified, which must
+// be the same length.
+func WriteIPS(w io.Writer, original []byte, modified []byte) error {
+	out := bufio.NewWriter(w)
+	out.WriteString(ipsMagic)
+
+	for i := 0; i < len(modified); {
+		if original[i] == modified[i] {
+			i++
+			continue
+		}
+
+		// "EOF" as an offset would end the patch early
+		start := i
+		if start == ipsEOF {
+			start--
+		}
+		end := i
+		for end < len(modified) && end-start < 0xffff && original[end] != modified[end] {
+			end++
+		}
+
+		out.Write([]byte{byte(start >> 16), byte(start >> 8), byte(start), byte((end - start) >> 8), byte(end - start)})
+		out.Write(modified[start:end])
+		i = end
+	}
+
+	out.WriteString("EOF")
+	return out.Flush()
+}
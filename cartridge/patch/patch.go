@@ -0,0 +1,124 @@
+// Package patch applies the IPS, UPS and BPS patches that translations and
+// ROM hacks are distributed as.
+package patch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+var (
+	// ErrUnknownFormat is returned for a patch that isn't IPS, UPS or BPS.
+	ErrUnknownFormat = errors.New("Unrecognized patch format")
+
+	// ErrTruncated is returned for a patch that ends partway through.
+	ErrTruncated = errors.New("Patch is truncated")
+
+	// ErrOutOfRange is returned for a patch that reads or writes past the
+	// end of the ROM it is patching.
+	ErrOutOfRange = errors.New("Patch refers past the end of the ROM")
+
+	// ErrTooLarge is returned for a UPS or BPS patch whose output is larger
+	// than any ROM it could make.
+	ErrTooLarge = errors.New("Patch output is too large")
+)
+
+// maxROMSize is more than the largest NES ROM. A patch's output is allowed to
+// be a few times its source plus this much, so that a corrupt size can't make
+// it allocate without bound.
+const maxROMSize = 64 << 20
+
+// checkSize checks the output size a patch gives before it is allocated
+func checkSize(output int, source int) error {
+	if output > 4*source+maxROMSize {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// A ChecksumError is returned when a UPS or BPS patch was made for a
+// different ROM, produces something other than what it was made to, or is
+// itself corrupt.
+type ChecksumError struct {
+	// Of is what failed to match: "source ROM", "patched ROM" or "patch"
+	Of       string
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("CRC32 of the %s is %08x, the patch expects %08x", e.Of, e.Actual, e.Expected)
+}
+
+// Apply applies a patch in any supported format to data, returning the
+// patched copy. data itself is left untouched.
+func Apply(data []byte, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, []byte(ipsMagic)):
+		return IPS(data, patch)
+	case bytes.HasPrefix(patch, []byte(upsMagic)):
+		return UPS(data, patch)
+	case bytes.HasPrefix(patch, []byte(bpsMagic)):
+		return BPS(data, patch)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// verify checks a CRC32 stored little endian in a UPS or BPS footer
+func verify(of string, data []byte, stored []byte) error {
+	expected := uint32(stored[0]) | uint32(stored[1])<<8 | uint32(stored[2])<<16 | uint32(stored[3])<<24
+	if actual := crc32.ChecksumIEEE(data); actual != expected {
+		return &ChecksumError{of, expected, actual}
+	}
+	return nil
+}
+
+// A decoder reads the body of a UPS or BPS patch
+type decoder struct {
+	patch    []byte
+	position int
+	end      int
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.position >= d.end {
+		return 0, ErrTruncated
+	}
+	d.position++
+	return d.patch[d.position-1], nil
+}
+
+// number reads a variable length number. Each byte holds seven bits, least
+// significant first, with the top bit set on the last byte. Every byte
+// after the first also adds one to what it encodes, so no number has two
+// encodings.
+func (d *decoder) number() (int, error) {
+	value, shift := 0, 1
+	for {
+		x, err := d.byte()
+		if err != nil {
+			return 0, err
+		}
+		value += int(x&0x7f) * shift
+		if x&0x80 != 0 {
+			return value, nil
+		}
+		if shift >= 1<<48 {
+			return 0, ErrOutOfRange
+		}
+		shift <<= 7
+		value += shift
+	}
+}
+
+// offset reads a signed relative offset, stored as a number with the sign
+// in its low bit
+func (d *decoder) offset() (int, error) {
+	value, err := d.number()
+	if value&0x01 != 0 {
+		return -(value >> 1), err
+	}
+	return value >> 1, err
+}
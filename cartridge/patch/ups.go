@@ -0,0 +1,68 @@
+package patch
+
+const upsMagic = "UPS1"
+
+// UPS applies a UPS patch to data, returning the patched copy. After the
+// input and output sizes, each hunk skips ahead a number of bytes and XORs
+// the ROM with patch bytes up to and including a zero. The footer holds the
+// CRC32s of the input, the output and the patch itself, all of which are
+// checked.
+func UPS(data []byte, patch []byte) ([]byte, error) {
+	if len(patch) < len(upsMagic)+12 || string(patch[:len(upsMagic)]) != upsMagic {
+		return nil, ErrUnknownFormat
+	}
+	footer := patch[len(patch)-12:]
+	if err := verify("patch", patch[:len(patch)-4], footer[8:]); err != nil {
+		return nil, err
+	}
+	if err := verify("source ROM", data, footer[0:]); err != nil {
+		return nil, err
+	}
+
+	d := &decoder{patch: patch, position: len(upsMagic), end: len(patch) - 12}
+	inputSize, err := d.number()
+	if err != nil {
+		return nil, err
+	}
+	outputSize, err := d.number()
+	if err != nil {
+		return nil, err
+	}
+	if inputSize != len(data) {
+		return nil, ErrOutOfRange
+	}
+	if err := checkSize(outputSize, inputSize); err != nil {
+		return nil, err
+	}
+
+	// Bytes past the end of the input read as zero
+	out := make([]byte, outputSize)
+	copy(out, data)
+
+	for offset := 0; d.position < d.end; {
+		skip, err := d.number()
+		if err != nil {
+			return nil, err
+		}
+		offset += skip
+
+		for {
+			x, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			if offset < len(out) {
+				out[offset] ^= x
+			}
+			offset++
+			if x == 0 {
+				break
+			}
+		}
+	}
+
+	if err := verify("patched ROM", out, footer[4:]); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
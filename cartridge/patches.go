@@ -0,0 +1,43 @@
+package cartridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/makononov/NESGo/cartridge/patch"
+)
+
+// AutoPatch controls whether ParseROM, when given no patches, applies one
+// named after the ROM file, such as game.bps next to game.nes or game.zip.
+var AutoPatch = true
+
+// patchExtensions are tried in order when looking for a patch next to a ROM.
+// BPS and UPS come first as their checksums catch a patch meant for another
+// dump.
+var patchExtensions = []string{".bps", ".ups", ".ips"}
+
+// findPatches returns the patch named after the ROM file, if there is one
+func findPatches(filename string) []string {
+	base := ROMBase(filename)
+	for _, extension := range patchExtensions {
+		if _, err := os.Stat(base + extension); err == nil {
+			return []string{base + extension}
+		}
+	}
+	return nil
+}
+
+// applyPatchFile applies the patch in a file to data
+func applyPatchFile(data []byte, filename string) ([]byte, error) {
+	body, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	patched, err := patch.Apply(data, body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return patched, nil
+}
@@ -0,0 +1,209 @@
+// Package romdb identifies known ROM dumps by the hash of their PRG and CHR
+// ROM, giving the header they should have in NES 2.0 terms along with their
+// title, region and board, so that dumps with bad iNES headers still load.
+//
+// Databases are text files with one dump per line, identified by the CRC32
+// and SHA-1 of its PRG ROM followed by its CHR ROM, without any header or
+// trainer. Columns are separated by tabs:
+//
+//	crc32 sha1 mapper submapper mirroring battery prgram prgnvram chrram
+//	chrnvram timing region board title
+//
+// mirroring is H, V, 4 or - when the mapper controls it, battery is 0 or 1,
+// RAM sizes are in bytes and timing is NTSC, PAL, Multi or Dendy. A - leaves
+// the sha1 or a text column unknown. Lines starting with # are comments.
+package romdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// An Entry describes a known dump
+type Entry struct {
+	CRC32 uint32
+
+	// SHA1 is all zero when only the CRC32 is known
+	SHA1 [20]byte
+
+	MapperID  int
+	Submapper int
+
+	// Mirroring is 'H' for horizontal, 'V' for vertical, '4' for four-screen
+	// or zero when the mapper controls it
+	Mirroring byte
+
+	Battery bool
+
+	// RAM sizes in bytes, volatile and battery-backed
+	PrgRAM   int
+	PrgNVRAM int
+	ChrRAM   int
+	ChrNVRAM int
+
+	// Timing is "NTSC", "PAL", "Multi" or "Dendy"
+	Timing string
+
+	Region string
+	Board  string
+	Title  string
+}
+
+// A Database holds known dumps, keyed by their hashes
+type Database struct {
+	entries []*Entry
+	bySHA1  map[[20]byte]*Entry
+	byCRC32 map[uint32]*Entry
+}
+
+// A ParseError reports a malformed line in a database file
+type ParseError struct {
+	Line   int
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("ROM database line %d: %s", e.Line, e.Reason)
+}
+
+// columns of a database line, which are separated by tabs
+var columns = []string{"crc32", "sha1", "mapper", "submapper", "mirroring", "battery",
+	"prgram", "prgnvram", "chrram", "chrnvram", "timing", "region", "board", "title"}
+
+// New returns an empty database
+func New() *Database {
+	return &Database{
+		bySHA1:  make(map[[20]byte]*Entry),
+		byCRC32: make(map[uint32]*Entry),
+	}
+}
+
+// Parse reads a database file. Each line describes one dump with the
+// tab-separated columns crc32, sha1, mapper, submapper, mirroring (H, V, 4
+// or -), battery (0 or 1), the PRG RAM, PRG NVRAM, CHR RAM and CHR NVRAM
+// sizes in bytes, timing, region, board and title. A - leaves the SHA-1 or a
+// text column unknown. Blank lines and lines starting with # are ignored.
+func Parse(r io.Reader) (*Database, error) {
+	db := New()
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		entry, reason := parseEntry(strings.Split(text, "\t"))
+		if reason != "" {
+			return nil, &ParseError{line, reason}
+		}
+		db.Add(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// parseEntry parses the columns of one line, returning why it is malformed
+// if it is
+func parseEntry(fields []string) (Entry, string) {
+	var entry Entry
+	if len(fields) != len(columns) {
+		return entry, fmt.Sprintf("expected %d columns, found %d", len(columns), len(fields))
+	}
+
+	crc, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return entry, "invalid crc32 " + strconv.Quote(fields[0])
+	}
+	entry.CRC32 = uint32(crc)
+
+	if fields[1] != "-" {
+		sha, err := hex.DecodeString(fields[1])
+		if err != nil || len(sha) != len(entry.SHA1) {
+			return entry, "invalid sha1 " + strconv.Quote(fields[1])
+		}
+		copy(entry.SHA1[:], sha)
+	}
+
+	numbers := []*int{&entry.MapperID, &entry.Submapper, nil, nil, &entry.PrgRAM, &entry.PrgNVRAM, &entry.ChrRAM, &entry.ChrNVRAM}
+	for i, number := range numbers {
+		if number == nil {
+			continue
+		}
+		value, err := strconv.Atoi(fields[2+i])
+		if err != nil || value < 0 {
+			return entry, fmt.Sprintf("invalid %s %q", columns[2+i], fields[2+i])
+		}
+		*number = value
+	}
+
+	switch fields[4] {
+	case "H", "V", "4":
+		entry.Mirroring = fields[4][0]
+	case "-":
+	default:
+		return entry, "invalid mirroring " + strconv.Quote(fields[4])
+	}
+
+	switch fields[5] {
+	case "0":
+	case "1":
+		entry.Battery = true
+	default:
+		return entry, "invalid battery " + strconv.Quote(fields[5])
+	}
+
+	switch fields[10] {
+	case "NTSC", "PAL", "Multi", "Dendy", "-":
+	default:
+		return entry, "invalid timing " + strconv.Quote(fields[10])
+	}
+
+	text := []*string{&entry.Timing, &entry.Region, &entry.Board, &entry.Title}
+	for i, field := range text {
+		if value := fields[10+i]; value != "-" {
+			*field = value
+		}
+	}
+	return entry, ""
+}
+
+// Add adds an entry, replacing any with the same hashes
+func (db *Database) Add(entry Entry) {
+	e := &entry
+	db.entries = append(db.entries, e)
+
+	// An entry giving only a CRC32 replaces one with the same CRC32 that
+	// also gives a SHA-1, or the older entry would still be found by it
+	if old, ok := db.byCRC32[e.CRC32]; ok && e.SHA1 == [20]byte{} {
+		delete(db.bySHA1, old.SHA1)
+	}
+	db.byCRC32[e.CRC32] = e
+	if e.SHA1 != [20]byte{} {
+		db.bySHA1[e.SHA1] = e
+	}
+}
+
+// Merge adds every entry in other, replacing those with the same hashes
+func (db *Database) Merge(other *Database) {
+	for _, e := range other.entries {
+		db.Add(*e)
+	}
+}
+
+// Lookup finds a dump by its SHA-1, or by its CRC32 for entries that don't
+// give one.
+func (db *Database) Lookup(crc uint32, sha [20]byte) (*Entry, bool) {
+	if e, ok := db.bySHA1[sha]; ok {
+		return e, true
+	}
+	if e, ok := db.byCRC32[crc]; ok && (e.SHA1 == [20]byte{} || e.SHA1 == sha) {
+		return e, true
+	}
+	return nil, false
+}
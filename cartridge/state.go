@@ -0,0 +1,28 @@
+package cartridge
+
+import (
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// stateVersion is the version of the cartridge's save state section
+const stateVersion = 1
+
+// SaveState writes the cartridge's PRG RAM, CHR RAM and the nametable RAM it
+// maps. The mapper's registers are saved separately, by its own SaveState.
+func (cartridge *Cartridge) SaveState(w io.Writer) error {
+	return state.Save(w, stateVersion, cartridge.serialize)
+}
+
+// LoadState restores the cartridge's memory from a state written by
+// SaveState
+func (cartridge *Cartridge) LoadState(r io.Reader) error {
+	return state.Load(r, stateVersion, cartridge.serialize)
+}
+
+func (cartridge *Cartridge) serialize(s *state.Stream) {
+	s.Bytes(cartridge.RAM)
+	s.Bytes(cartridge.VRAM)
+	s.Bytes(cartridge.CHR[cartridge.ChrRomSize:])
+}
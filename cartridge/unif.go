@@ -0,0 +1,189 @@
+package cartridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/makononov/NESGo/cartridge/mappers"
+)
+
+const unifHeaderSize = 32
+
+// A unifBoard is the iNES mapper a UNIF board name translates to
+type unifBoard struct {
+	mapperID  int
+	submapper int
+}
+
+// unifBoards translates UNIF board names, without their NES-, HVC-, UNL- or
+// similar prefix, to mapper numbers. Only boards whose mapper is implemented
+// are listed, so that the rest are reported as unknown boards rather than
+// unsupported mappers.
+var unifBoards = map[string]unifBoard{
+	"NROM":     {0, 0},
+	"NROM-128": {0, 0},
+	"NROM-256": {0, 0},
+	"RROM":     {0, 0},
+	"RROM-128": {0, 0},
+
+	"SAROM":  {1, 0},
+	"SBROM":  {1, 0},
+	"SCROM":  {1, 0},
+	"SEROM":  {1, 0},
+	"SGROM":  {1, 0},
+	"SKROM":  {1, 0},
+	"SL1ROM": {1, 0},
+	"SLROM":  {1, 0},
+	"SLRROM": {1, 0},
+	"SNROM":  {1, 0},
+	"SOROM":  {1, 0},
+	"SUROM":  {1, 0},
+	"SXROM":  {1, 0},
+
+	"UNROM": {2, 0},
+	"UOROM": {2, 0},
+
+	"EKROM": {5, 0},
+	"ELROM": {5, 0},
+	"ETROM": {5, 0},
+	"EWROM": {5, 0},
+
+	"UNROM-512-8":  {30, 0},
+	"UNROM-512-16": {30, 0},
+	"UNROM-512-32": {30, 0},
+
+	"BTR":   {69, 0},
+	"JLROM": {69, 0},
+	"JSROM": {69, 0},
+
+	"DEROM":  {206, 0},
+	"DE1ROM": {206, 0},
+	"DRROM":  {206, 0},
+}
+
+// lookupUNIFBoard finds a board by its full name, then without its prefix
+func lookupUNIFBoard(name string) (unifBoard, bool) {
+	name = strings.ToUpper(name)
+	if board, ok := unifBoards[name]; ok {
+		return board, true
+	}
+	if dash := strings.Index(name, "-"); dash >= 0 {
+		board, ok := unifBoards[name[dash+1:]]
+		return board, ok
+	}
+	return unifBoard{}, false
+}
+
+// registered reports whether a mapper is implemented for a mapper number
+func registered(mapperID int) bool {
+	for _, registration := range mapper.Registered() {
+		if registration.ID == mapperID {
+			return true
+		}
+	}
+	return false
+}
+
+// isUNIF reports whether data is a UNIF image
+func isUNIF(data []byte) bool {
+	return len(data) >= unifHeaderSize && string(data[0:4]) == "UNIF"
+}
+
+// parseUNIF sets up a cartridge for a UNIF image
+func parseUNIF(cart *Cartridge, data []byte) error {
+	prg, err := readUNIF(cart, data)
+	if err != nil {
+		return err
+	}
+
+	// The ROM database can also place boards missing from the table, but
+	// only on a mapper that is implemented
+	board, ok := lookupUNIFBoard(cart.Board)
+	cart.MapperID = board.mapperID
+	cart.Submapper = board.submapper
+	if err = identify(cart, prg); err != nil {
+		return err
+	}
+	if !ok && cart.DatabaseEntry == nil || !registered(cart.MapperID) {
+		return &UnknownBoardError{cart.Board}
+	}
+	return initMapper(cart, prg)
+}
+
+// readUNIF reads a UNIF image's chunks into the cartridge, returning the PRG
+// ROM. After a 32-byte header, the image is a series of chunks, each a four
+// character ID and a little endian length followed by the data. The board
+// name is in MAPR, and the ROM is split across PRG0-PRGF and CHR0-CHRF
+// chunks that are concatenated in order. Chunks that don't affect emulation,
+// like NAME and the ROM checksums, are skipped.
+func readUNIF(cart *Cartridge, data []byte) ([]byte, error) {
+	var prgChunks, chrChunks [16][]byte
+	cart.Mirroring = MirrorHorizontal
+
+	for position := unifHeaderSize; position < len(data); {
+		if position+8 > len(data) {
+			return nil, ErrTruncated
+		}
+		id := string(data[position : position+4])
+		length := int(binary.LittleEndian.Uint32(data[position+4 : position+8]))
+		position += 8
+		if length < 0 || length > len(data)-position {
+			return nil, ErrTruncated
+		}
+		chunk := data[position : position+length]
+		position += length
+
+		switch {
+		case id == "MAPR":
+			if end := bytes.IndexByte(chunk, 0); end >= 0 {
+				chunk = chunk[:end]
+			}
+			cart.Board = string(chunk)
+		case strings.HasPrefix(id, "PRG") && unifChunkIndex(id) >= 0:
+			prgChunks[unifChunkIndex(id)] = chunk
+		case strings.HasPrefix(id, "CHR") && unifChunkIndex(id) >= 0:
+			chrChunks[unifChunkIndex(id)] = chunk
+		case len(chunk) == 0:
+		case id == "MIRR":
+			// 2 and 3 are hardwired one-screen mirroring and 5 is mapper
+			// controlled, all of which the mapper takes care of
+			switch chunk[0] {
+			case 0:
+				cart.Mirroring = MirrorHorizontal
+			case 1:
+				cart.Mirroring = MirrorVertical
+			case 4:
+				cart.FourScreen = true
+			}
+		case id == "BATR":
+			cart.BatteryBackedSRAM = chunk[0] != 0
+		case id == "TVCI":
+			if chunk[0] < 3 {
+				cart.TVSystemFormat = []int{NTSC, PAL, MultiRegion}[chunk[0]]
+			}
+		case id == "CTRL":
+			cart.Controllers = int(chunk[0])
+		}
+	}
+
+	prg := bytes.Join(prgChunks[:], nil)
+	cart.CHR = bytes.Join(chrChunks[:], nil)
+	cart.PrgRomSize = len(prg)
+	cart.ChrRomSize = len(cart.CHR)
+	if len(prg) == 0 {
+		return nil, ErrTruncated
+	}
+	return prg, nil
+}
+
+// unifChunkIndex returns the hex digit numbering a PRG or CHR chunk, or -1
+func unifChunkIndex(id string) int {
+	switch digit := id[3]; {
+	case digit >= '0' && digit <= '9':
+		return int(digit - '0')
+	case digit >= 'A' && digit <= 'F':
+		return int(digit-'A') + 10
+	}
+	return -1
+}
@@ -2,8 +2,10 @@ package cpu
 
 import (
 	"fmt"
-	"log"
+	"io"
 	"math"
+
+	"github.com/makononov/NESGo/input"
 )
 
 type op struct {
@@ -13,8 +15,24 @@ type op struct {
 	s uint16
 }
 
+// An APU is the audio processing unit alongside the CPU on the 2A03, with
+// registers at $4000-$4013, $4015 and $4017.
+type APU interface {
+	WriteRegister(address uint16, value uint8)
+	ReadStatus() uint8
+}
+
+// A BusMonitor observes the CPU's writes to the PPU registers, as cartridges
+// that decode them from the bus for themselves do
+type BusMonitor interface {
+	MonitorWrite(address uint16, value uint8)
+}
+
 // CPU emulates the 6502 processor
 type CPU struct {
+	// Trace, if set, is written a line for each instruction executed
+	Trace io.Writer
+
 	ram []byte
 
 	// special Registers
@@ -37,6 +55,11 @@ type CPU struct {
 	dmcstart uint8
 	dmclen   uint8
 
+	apu     APU
+	ports   [2]input.Device
+	irq     IRQSource
+	monitor BusMonitor
+
 	// Communication busses
 	cartridgeControlBus chan uint16
 	ppuControlBus       chan uint16
@@ -44,24 +67,51 @@ type CPU struct {
 	dataBus             chan uint8
 	vblankBus           chan bool
 
+	// faultBus carries errors from devices, which are raised as faults
+	faultBus chan error
+	fault    *Fault
+
+	// instructionPC and opcode are the instruction being executed, and
+	// current records it for the history as its operands are read
+	instructionPC Address
+	opcode        uint8
+	current       Instruction
+	history       [HistoryLength]Instruction
+	historyCount  int
+
 	vblank     bool
 	cycleCount int
+	frame      int
+	executed   int
+
+	// openBus is the last value on the data bus, which reads from addresses
+	// nothing drives return
+	openBus uint8
 
 	operations map[uint8]op
 }
 
-// Init sets the CPU values to their initial power-up state.
-func (c *CPU) Init(ppuControlBus chan uint16, cartridgeControlBus chan uint16, readWriteBus chan int, dataBus chan uint8, vblankBus chan bool) {
+// Init sets the CPU values to their initial power-up state. faultBus should
+// be buffered, so that devices can report an error without blocking.
+func (c *CPU) Init(ppuControlBus chan uint16, cartridgeControlBus chan uint16, readWriteBus chan int, dataBus chan uint8, vblankBus chan bool, faultBus chan error) {
 	c.ppuControlBus = ppuControlBus
 	c.cartridgeControlBus = cartridgeControlBus
 	c.readWriteBus = readWriteBus
 	c.dataBus = dataBus
 	c.vblankBus = vblankBus
+	c.faultBus = faultBus
+	c.fault = nil
+	c.historyCount = 0
 	c.vblank = false
+	c.cycleCount = 0
 	c.ram = make([]byte, 2048)
 	c.pc = 0
-	c.sp = 0xff
+	c.sp = 0
+	c.a, c.x, c.y = 0, 0, 0
+	c.openBus = 0
+	c.frame = 0
 
+	c.carry, c.zero, c.decimal, c.overflow, c.negative = false, false, false, false, false
 	c.interruptDisable = true
 
 	c.operations = map[uint8]op{
@@ -92,12 +142,14 @@ func (c *CPU) Init(ppuControlBus chan uint16, cartridgeControlBus chan uint16, r
 		0x39: {f: c.and, a: c.absoluteY, c: 4, s: 3},
 		0x3d: {f: c.and, a: c.absoluteX, c: 4, s: 3},
 		0x3e: {f: c.rol, a: c.absoluteX, c: 7, s: 3},
+		0x40: {f: c.rti, a: c.implied, c: 6, s: 0},
 		0x46: {f: c.lsr, a: c.zeropage, c: 5, s: 2},
 		0x48: {f: c.pha, a: c.implied, c: 3, s: 1},
 		0x4a: {f: c.lsr, a: nil, c: 2, s: 1},
 		0x4c: {f: c.jmp, a: c.absolute, c: 3, s: 0},
 		0x4e: {f: c.lsr, a: c.absolute, c: 6, s: 3},
 		0x56: {f: c.lsr, a: c.zeropageX, c: 6, s: 2},
+		0x58: {f: c.cli, a: c.implied, c: 2, s: 1},
 		0x5e: {f: c.lsr, a: c.absoluteX, c: 7, s: 3},
 		0x60: {f: c.rts, a: c.implied, c: 6, s: 0},
 		0x65: {f: c.adc, a: c.zeropage, c: 3, s: 2},
@@ -164,49 +216,143 @@ func (c *CPU) Init(ppuControlBus chan uint16, cartridgeControlBus chan uint16, r
 	}
 }
 
-// Run is the main function that processes through the PRG ROM.
-func (c *CPU) Run() {
-	fmt.Println("CPU spawned, getting initial PC...")
+// ConnectAPU connects the APU's registers to the bus
+func (c *CPU) ConnectAPU(apu APU) {
+	c.apu = apu
+}
+
+// ConnectIRQ connects a device to the IRQ line, or disconnects it if source
+// is nil
+func (c *CPU) ConnectIRQ(source IRQSource) {
+	c.irq = source
+}
+
+// ConnectMonitor lets a device watch writes to the PPU registers, or stops
+// it if monitor is nil
+func (c *CPU) ConnectMonitor(monitor BusMonitor) {
+	c.monitor = monitor
+}
+
+// ConnectInput plugs a device into controller port 0 or 1, or unplugs it
+// if device is nil.
+func (c *CPU) ConnectInput(port int, device input.Device) {
+	c.ports[port] = device
+}
+
+// Reset starts execution from the reset vector, as the reset line does. The
+// stack pointer is decremented as if three bytes were pushed, though
+// nothing is written.
+func (c *CPU) Reset() error {
+	c.instructionPC, c.opcode = 0xfffc, 0
 	c.pc = Address(c.readBytes(0xfffc))
+	c.sp -= 3
+	c.interruptDisable = true
+	return c.takeFault()
+}
 
-	fmt.Printf("Beginning execution loop at $%04x\n", c.pc)
-	var opcode uint8
-	executed := 0
-	for {
-		executed++
-		opcode = c.readMem(c.pc)
-		fmt.Printf("%d 0x%04x A:0x%02x X:0x%02x Y:0x%02x SP:0x%02x OP:%02x\n", executed, c.pc, c.a, c.x, c.y, c.sp, opcode)
+// Step executes the next instruction, or enters the IRQ handler if the IRQ
+// line is asserted, and returns the number of cycles it took. An error is a
+// *Fault, after which the CPU's state is undefined.
+func (c *CPU) Step() (int, error) {
+	start := c.cycleCount
+	if !c.pollIRQ() {
 		c.executeNext()
-
-		// VBLANK
-		if !c.vblank && c.cycleCount >= 27507 {
-			c.startVBlank()
+		if c.Trace != nil {
+			c.executed++
+			r := c.current.Registers
+			fmt.Fprintf(c.Trace, "%d 0x%04x A:0x%02x X:0x%02x Y:0x%02x SP:0x%02x OP:%02x\n", c.executed, c.current.PC, r.A, r.X, r.Y, r.SP, c.current.Opcode)
 		}
+	}
+	cycles := c.cycleCount - start
 
-		if c.vblank && c.cycleCount >= 29780 {
-			c.endVBlank()
-			c.cycleCount = 0
-		}
+	// VBLANK
+	if !c.vblank && c.cycleCount >= 27507 {
+		c.startVBlank()
 	}
+
+	if c.vblank && c.cycleCount >= 29780 {
+		c.endVBlank()
+		c.cycleCount = 0
+		c.frame++
+	}
+	return cycles, c.takeFault()
+}
+
+// Frame returns the number of frames completed since power-up
+func (c *CPU) Frame() int {
+	return c.frame
+}
+
+// RAM returns the CPU's 2KB of internal RAM
+func (c *CPU) RAM() []byte {
+	return c.ram
 }
 
+// ReadMemory reads a byte from the CPU address space, with the same side
+// effects as the CPU reading it
+func (c *CPU) ReadMemory(address uint16) (uint8, error) {
+	value := c.readMem(Address(address))
+	return value, c.takeFault()
+}
+
+// WriteMemory writes a byte to the CPU address space
+func (c *CPU) WriteMemory(address uint16, value uint8) error {
+	c.writeMem(Address(address), value)
+	return c.takeFault()
+}
+
+// readMem reads from the bus, noting the operands of the instruction being
+// executed as they are fetched
 func (c *CPU) readMem(address Address) uint8 {
+	value := c.read(address)
+	if offset := address - c.instructionPC; offset == 1 || offset == 2 {
+		c.current.Operands[offset-1] = value
+	}
+	return value
+}
+
+// read reads from the bus. The cartridge and PPU are sent the value left on
+// the data bus and send back what they drive onto it, which is that value if
+// they don't respond to the address.
+func (c *CPU) read(address Address) uint8 {
 	var controlBus chan uint16
+	subsystem := SubsystemCartridge
 
 	switch {
-	case address >= 0x8000:
+	case address >= 0x4020:
 		controlBus = c.cartridgeControlBus
+	case address == 0x4015:
+		// APU status is read internally and doesn't reach the data bus, so
+		// bit 5, which it doesn't drive, is open bus
+		var status uint8
+		if c.apu != nil {
+			status = c.apu.ReadStatus()
+		}
+		return status&^0x20 | c.openBus&0x20
+	case address == 0x4016 || address == 0x4017:
+		// Controllers only drive the low bits
+		var value uint8
+		if device := c.ports[address-0x4016]; device != nil {
+			value = device.Read()
+		}
+		c.openBus = c.openBus&0xe0 | value&0x1f
+		return c.openBus
+	case address >= 0x4000 && address < 0x4020:
+		return c.openBus
 	case address >= 0x2000 && address < 0x4000:
 		controlBus = c.ppuControlBus
+		subsystem = SubsystemPPU
 	case address < 0x2000:
-		return c.ram[address%0x0800]
-	default:
-		log.Panicf("attempt to read invalid memory location: 0x%x", address)
+		c.openBus = c.ram[address%0x0800]
+		return c.openBus
 	}
 
 	controlBus <- uint16(address)
 	c.readWriteBus <- 0 // read
-	return <-c.dataBus
+	c.dataBus <- c.openBus
+	c.openBus = <-c.dataBus
+	c.checkBus(subsystem, address)
+	return c.openBus
 }
 
 func (c *CPU) readBytes(address Address) uint16 {
@@ -215,47 +361,67 @@ func (c *CPU) readBytes(address Address) uint16 {
 	return highbyte<<8 | lowbyte
 }
 
-func (c *CPU) writeMem(address Address, val uint8) error {
+// writeMem writes to the bus, raising a fault if the write fails
+func (c *CPU) writeMem(address Address, val uint8) {
 	var controlBus chan uint16
+	subsystem := SubsystemCartridge
+	c.openBus = val
 
 	switch {
-	case address >= 0x8000:
+	case address >= 0x4020:
 		controlBus = c.cartridgeControlBus
-	case address >= 0x4016 && address < 0x4018:
-		return fmt.Errorf("Controller functions not implemented")
-	case address == 0x4015:
-		fmt.Printf("Write to pAPU address: $%04x\n", address)
-		return nil
+	case address == 0x4016:
+		for _, device := range c.ports {
+			if device != nil {
+				device.Strobe(val)
+			}
+		}
+		return
 	case address == 0x4014:
-		return fmt.Errorf("OAMDMA not implemented")
-	case address >= 0x4000 && address < 0x4014:
-		fmt.Printf("Write to pAPU address: $%04x\n", address)
-		return nil
+		c.raise(SubsystemCPU, address, fmt.Errorf("OAMDMA not implemented"))
+		return
+	case address >= 0x4000 && address < 0x4018:
+		if c.apu != nil {
+			c.apu.WriteRegister(uint16(address), val)
+		}
+		return
 	case address >= 0x2000 && address < 0x4000:
 		controlBus = c.ppuControlBus
+		subsystem = SubsystemPPU
+		if c.monitor != nil {
+			c.monitor.MonitorWrite(uint16(address), val)
+		}
 	case address >= 0x0000 && address < 0x2000:
 		// 0x0800 bytes mirrored four times
 		c.ram[address%0x0800] = val
-		return nil
+		return
 	default:
-		return fmt.Errorf("attempt to write to an invalid memory location: 0x%x", address)
+		// $4018-$401F are unmapped
+		return
 	}
 
+	// The device sends the value back once it has been written
 	controlBus <- uint16(address)
 	c.readWriteBus <- 1 // write
 	c.dataBus <- val
-
-	return nil
+	<-c.dataBus
+	c.checkBus(subsystem, address)
 }
 
 func (c *CPU) executeNext() {
 
 	// Read next opcode at the PC
+	c.instructionPC = c.pc
+	c.current = Instruction{PC: uint16(c.pc), Registers: c.Registers()}
 	opcode := c.readMem(c.pc)
+	c.opcode = opcode
+	c.current.Opcode = opcode
+	defer c.record()
 	inst := c.operations[opcode]
 
 	if inst.f == nil {
-		log.Panicf("unimplemented opcode: $%02x", opcode)
+		c.raise(SubsystemCPU, c.pc, fmt.Errorf("Unimplemented opcode $%02x", opcode))
+		return
 	}
 
 	inst.f(inst.a)
@@ -283,14 +449,18 @@ func (c *CPU) setZero(value uint8) {
 	c.zero = (value == 0)
 }
 
+// startVBlank and endVBlank signal the PPU, which acknowledges the signal
+// once it has been handled
 func (c *CPU) startVBlank() {
 	c.vblank = true
 	c.vblankBus <- true
+	<-c.vblankBus
 }
 
 func (c *CPU) endVBlank() {
 	c.vblank = false
 	c.vblankBus <- false
+	<-c.vblankBus
 }
 
 func binToBcd(val uint8) int8 {
@@ -0,0 +1,142 @@
+package cpu
+
+import "fmt"
+
+// Addressing modes, for disassembly
+const (
+	modeImplied = iota
+	modeAccumulator
+	modeImmediate
+	modeZeropage
+	modeZeropageX
+	modeZeropageY
+	modeAbsolute
+	modeAbsoluteX
+	modeAbsoluteY
+	modeIndirect
+	modeIndirectX
+	modeIndirectY
+	modeRelative
+)
+
+type mnemonic struct {
+	name string
+	mode int
+}
+
+// mnemonics are the official 6502 opcodes
+var mnemonics = map[uint8]mnemonic{}
+
+func init() {
+	// The eight addressing modes of the ALU instructions, in the order the
+	// opcodes are listed below
+	alu := []int{modeImmediate, modeZeropage, modeZeropageX, modeAbsolute, modeAbsoluteX, modeAbsoluteY, modeIndirectX, modeIndirectY}
+	for name, opcodes := range map[string][]uint8{
+		"ADC": {0x69, 0x65, 0x75, 0x6d, 0x7d, 0x79, 0x61, 0x71},
+		"AND": {0x29, 0x25, 0x35, 0x2d, 0x3d, 0x39, 0x21, 0x31},
+		"CMP": {0xc9, 0xc5, 0xd5, 0xcd, 0xdd, 0xd9, 0xc1, 0xd1},
+		"EOR": {0x49, 0x45, 0x55, 0x4d, 0x5d, 0x59, 0x41, 0x51},
+		"LDA": {0xa9, 0xa5, 0xb5, 0xad, 0xbd, 0xb9, 0xa1, 0xb1},
+		"ORA": {0x09, 0x05, 0x15, 0x0d, 0x1d, 0x19, 0x01, 0x11},
+		"SBC": {0xe9, 0xe5, 0xf5, 0xed, 0xfd, 0xf9, 0xe1, 0xf1},
+		"STA": {0, 0x85, 0x95, 0x8d, 0x9d, 0x99, 0x81, 0x91},
+	} {
+		for i, opcode := range opcodes {
+			if opcode != 0 {
+				mnemonics[opcode] = mnemonic{name, alu[i]}
+			}
+		}
+	}
+
+	// Shifts, rotates, increments and decrements
+	rmw := []int{modeAccumulator, modeZeropage, modeZeropageX, modeAbsolute, modeAbsoluteX}
+	for name, opcodes := range map[string][]uint8{
+		"ASL": {0x0a, 0x06, 0x16, 0x0e, 0x1e},
+		"LSR": {0x4a, 0x46, 0x56, 0x4e, 0x5e},
+		"ROL": {0x2a, 0x26, 0x36, 0x2e, 0x3e},
+		"ROR": {0x6a, 0x66, 0x76, 0x6e, 0x7e},
+		"DEC": {0, 0xc6, 0xd6, 0xce, 0xde},
+		"INC": {0, 0xe6, 0xf6, 0xee, 0xfe},
+	} {
+		for i, opcode := range opcodes {
+			if opcode != 0 {
+				mnemonics[opcode] = mnemonic{name, rmw[i]}
+			}
+		}
+	}
+
+	for opcode, m := range map[uint8]mnemonic{
+		0x90: {"BCC", modeRelative}, 0xb0: {"BCS", modeRelative}, 0xf0: {"BEQ", modeRelative}, 0x30: {"BMI", modeRelative},
+		0xd0: {"BNE", modeRelative}, 0x10: {"BPL", modeRelative}, 0x50: {"BVC", modeRelative}, 0x70: {"BVS", modeRelative},
+		0x24: {"BIT", modeZeropage}, 0x2c: {"BIT", modeAbsolute},
+		0xe0: {"CPX", modeImmediate}, 0xe4: {"CPX", modeZeropage}, 0xec: {"CPX", modeAbsolute},
+		0xc0: {"CPY", modeImmediate}, 0xc4: {"CPY", modeZeropage}, 0xcc: {"CPY", modeAbsolute},
+		0x4c: {"JMP", modeAbsolute}, 0x6c: {"JMP", modeIndirect}, 0x20: {"JSR", modeAbsolute},
+		0xa2: {"LDX", modeImmediate}, 0xa6: {"LDX", modeZeropage}, 0xb6: {"LDX", modeZeropageY}, 0xae: {"LDX", modeAbsolute}, 0xbe: {"LDX", modeAbsoluteY},
+		0xa0: {"LDY", modeImmediate}, 0xa4: {"LDY", modeZeropage}, 0xb4: {"LDY", modeZeropageX}, 0xac: {"LDY", modeAbsolute}, 0xbc: {"LDY", modeAbsoluteX},
+		0x86: {"STX", modeZeropage}, 0x96: {"STX", modeZeropageY}, 0x8e: {"STX", modeAbsolute},
+		0x84: {"STY", modeZeropage}, 0x94: {"STY", modeZeropageX}, 0x8c: {"STY", modeAbsolute},
+		0x00: {"BRK", modeImplied}, 0x40: {"RTI", modeImplied}, 0x60: {"RTS", modeImplied}, 0xea: {"NOP", modeImplied},
+		0x18: {"CLC", modeImplied}, 0xd8: {"CLD", modeImplied}, 0x58: {"CLI", modeImplied}, 0xb8: {"CLV", modeImplied},
+		0x38: {"SEC", modeImplied}, 0xf8: {"SED", modeImplied}, 0x78: {"SEI", modeImplied},
+		0xca: {"DEX", modeImplied}, 0x88: {"DEY", modeImplied}, 0xe8: {"INX", modeImplied}, 0xc8: {"INY", modeImplied},
+		0x48: {"PHA", modeImplied}, 0x08: {"PHP", modeImplied}, 0x68: {"PLA", modeImplied}, 0x28: {"PLP", modeImplied},
+		0xaa: {"TAX", modeImplied}, 0xa8: {"TAY", modeImplied}, 0xba: {"TSX", modeImplied},
+		0x8a: {"TXA", modeImplied}, 0x9a: {"TXS", modeImplied}, 0x98: {"TYA", modeImplied},
+	} {
+		mnemonics[opcode] = m
+	}
+}
+
+// Length returns the length in bytes of an instruction, counting the
+// opcode. Opcodes that aren't official 6502 instructions count as one byte.
+func Length(opcode uint8) int {
+	switch mnemonics[opcode].mode {
+	case modeImplied, modeAccumulator:
+		return 1
+	case modeAbsolute, modeAbsoluteX, modeAbsoluteY, modeIndirect:
+		return 3
+	}
+	if _, ok := mnemonics[opcode]; !ok {
+		return 1
+	}
+	return 2
+}
+
+// Disassemble returns an instruction in assembly language. Branch targets
+// are given as absolute addresses.
+func Disassemble(pc uint16, opcode uint8, operands [2]uint8) string {
+	m, ok := mnemonics[opcode]
+	if !ok {
+		return fmt.Sprintf(".db $%02x", opcode)
+	}
+
+	word := uint16(operands[1])<<8 | uint16(operands[0])
+	switch m.mode {
+	case modeAccumulator:
+		return m.name + " A"
+	case modeImmediate:
+		return fmt.Sprintf("%s #$%02x", m.name, operands[0])
+	case modeZeropage:
+		return fmt.Sprintf("%s $%02x", m.name, operands[0])
+	case modeZeropageX:
+		return fmt.Sprintf("%s $%02x,X", m.name, operands[0])
+	case modeZeropageY:
+		return fmt.Sprintf("%s $%02x,Y", m.name, operands[0])
+	case modeAbsolute:
+		return fmt.Sprintf("%s $%04x", m.name, word)
+	case modeAbsoluteX:
+		return fmt.Sprintf("%s $%04x,X", m.name, word)
+	case modeAbsoluteY:
+		return fmt.Sprintf("%s $%04x,Y", m.name, word)
+	case modeIndirect:
+		return fmt.Sprintf("%s ($%04x)", m.name, word)
+	case modeIndirectX:
+		return fmt.Sprintf("%s ($%02x,X)", m.name, operands[0])
+	case modeIndirectY:
+		return fmt.Sprintf("%s ($%02x),Y", m.name, operands[0])
+	case modeRelative:
+		return fmt.Sprintf("%s $%04x", m.name, pc+2+uint16(int8(operands[0])))
+	}
+	return m.name
+}
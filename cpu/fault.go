@@ -0,0 +1,70 @@
+package cpu
+
+import "fmt"
+
+// Subsystems a Fault can come from
+const (
+	SubsystemCPU       = "cpu"
+	SubsystemPPU       = "ppu"
+	SubsystemAPU       = "apu"
+	SubsystemCartridge = "cartridge"
+)
+
+// A Fault is an error that stops emulation: an instruction the CPU can't
+// execute, or a device on the bus failing while an instruction accessed it.
+type Fault struct {
+	// Subsystem is where the error happened
+	Subsystem string
+
+	// PC and Opcode are the address and opcode of the instruction executing
+	PC     uint16
+	Opcode uint8
+
+	// Address is the bus address being accessed
+	Address uint16
+
+	Err error
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("%s: %v at $%04x (PC $%04x, opcode $%02x)", f.Subsystem, f.Err, f.Address, f.PC, f.Opcode)
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+// Fault returns a fault in a subsystem during the instruction executing, for
+// errors that happen outside the CPU's bus accesses, such as while clocking
+// other devices.
+func (c *CPU) Fault(subsystem string, err error) *Fault {
+	return &Fault{subsystem, uint16(c.instructionPC), c.opcode, uint16(c.instructionPC), err}
+}
+
+// raise records a fault for Step to return, keeping the first if there are
+// several
+func (c *CPU) raise(subsystem string, address Address, err error) {
+	if c.fault == nil {
+		c.fault = &Fault{subsystem, uint16(c.instructionPC), c.opcode, uint16(address), err}
+	}
+}
+
+// checkBus raises any fault a device reported for the bus cycle just
+// completed. Devices report them before acknowledging the cycle.
+func (c *CPU) checkBus(subsystem string, address Address) {
+	select {
+	case err := <-c.faultBus:
+		c.raise(subsystem, address, err)
+	default:
+	}
+}
+
+// takeFault returns the fault raised, if any, and clears it
+func (c *CPU) takeFault() error {
+	if c.fault == nil {
+		return nil
+	}
+	fault := c.fault
+	c.fault = nil
+	return fault
+}
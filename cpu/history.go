@@ -0,0 +1,71 @@
+package cpu
+
+// HistoryLength is how many of the last instructions executed are kept
+const HistoryLength = 64
+
+// Registers is the state of the CPU's registers. P is the status flags as
+// PHP pushes them, with the B flag clear.
+type Registers struct {
+	PC uint16
+	A  uint8
+	X  uint8
+	Y  uint8
+	SP uint8
+	P  uint8
+}
+
+// An Instruction is one that was executed, with the registers as they were
+// before it
+type Instruction struct {
+	PC       uint16
+	Opcode   uint8
+	Operands [2]uint8
+	Registers
+}
+
+// String disassembles the instruction
+func (i Instruction) String() string {
+	return Disassemble(i.PC, i.Opcode, i.Operands)
+}
+
+// Registers returns the CPU's registers
+func (c *CPU) Registers() Registers {
+	return Registers{uint16(c.pc), c.a, c.x, c.y, c.sp, c.status()}
+}
+
+// status packs the flags into the processor status register, NV1BDIZC
+func (c *CPU) status() uint8 {
+	p := uint8(0x20)
+	for bit, flag := range []bool{c.carry, c.zero, c.interruptDisable, c.decimal, false, false, c.overflow, c.negative} {
+		if flag {
+			p |= 1 << uint(bit)
+		}
+	}
+	return p
+}
+
+// setStatus unpacks the processor status register into the flags. The B and
+// unused bits don't exist in the CPU, so they are ignored.
+func (c *CPU) setStatus(p uint8) {
+	for bit, flag := range []*bool{&c.carry, &c.zero, &c.interruptDisable, &c.decimal, nil, nil, &c.overflow, &c.negative} {
+		if flag != nil {
+			*flag = p&(1<<uint(bit)) != 0
+		}
+	}
+}
+
+// History returns the last instructions executed, oldest first. The last
+// may be an instruction that faulted partway through.
+func (c *CPU) History() []Instruction {
+	if c.historyCount < HistoryLength {
+		return append([]Instruction(nil), c.history[:c.historyCount]...)
+	}
+	start := c.historyCount % HistoryLength
+	return append(append([]Instruction(nil), c.history[start:]...), c.history[:start]...)
+}
+
+// record adds the instruction just executed to the history
+func (c *CPU) record() {
+	c.history[c.historyCount%HistoryLength] = c.current
+	c.historyCount++
+}
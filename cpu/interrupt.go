@@ -0,0 +1,33 @@
+package cpu
+
+// irqVector is where the address of the IRQ handler is read from
+const irqVector = 0xfffe
+
+// interruptCycles is how long the CPU takes to enter an interrupt handler
+const interruptCycles = 7
+
+// An IRQSource drives the CPU's IRQ line. The line is level-triggered: the
+// interrupt is taken before every instruction for as long as IRQ returns
+// true and interrupts are enabled, so the source must stop asserting it once
+// the handler acknowledges it.
+type IRQSource interface {
+	IRQ() bool
+}
+
+// pollIRQ enters the IRQ handler if the line is asserted and interrupts are
+// enabled, pushing the return address and status as BRK does but with the B
+// flag clear. It reports whether it did.
+func (c *CPU) pollIRQ() bool {
+	if c.irq == nil || c.interruptDisable || !c.irq.IRQ() {
+		return false
+	}
+
+	c.instructionPC, c.opcode = c.pc, 0
+	c.stackPush(uint8(c.pc >> 8))
+	c.stackPush(uint8(c.pc & 0xff))
+	c.stackPush(c.status())
+	c.interruptDisable = true
+	c.pc = Address(c.readBytes(irqVector))
+	c.cycleCount += interruptCycles
+	return true
+}
@@ -88,6 +88,10 @@ func (c *CPU) cld(_ AddressingMode) {
 	c.decimal = false
 }
 
+func (c *CPU) cli(_ AddressingMode) {
+	c.interruptDisable = false
+}
+
 func (c *CPU) cmp(address AddressingMode) {
 	value := c.readMem(address())
 	result := c.a - value
@@ -245,6 +249,15 @@ func (c *CPU) rol(address AddressingMode) {
 	}
 }
 
+// rti returns from an interrupt handler, restoring the status and return
+// address the interrupt pushed
+func (c *CPU) rti(_ AddressingMode) {
+	c.setStatus(c.stackPop())
+	lowByte := uint16(c.stackPop())
+	highByte := uint16(c.stackPop())
+	c.pc = Address(highByte<<8 | lowByte)
+}
+
 func (c *CPU) rts(_ AddressingMode) {
 	highByte := uint16(c.stackPop())
 	lowByte := uint16(c.stackPop())
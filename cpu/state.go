@@ -0,0 +1,40 @@
+package cpu
+
+import (
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// stateVersion is the version of the CPU's save state section
+const stateVersion = 1
+
+// SaveState writes the CPU's registers, RAM and frame timing
+func (c *CPU) SaveState(w io.Writer) error {
+	return state.Save(w, stateVersion, c.serialize)
+}
+
+// LoadState restores the CPU from a state written by SaveState
+func (c *CPU) LoadState(r io.Reader) error {
+	return state.Load(r, stateVersion, c.serialize)
+}
+
+func (c *CPU) serialize(s *state.Stream) {
+	pc := uint16(c.pc)
+	s.Uint16(&pc)
+	c.pc = Address(pc)
+	s.Uint8(&c.sp)
+	s.Uint8(&c.a)
+	s.Uint8(&c.x)
+	s.Uint8(&c.y)
+	for _, flag := range []*bool{&c.carry, &c.zero, &c.interruptDisable, &c.decimal, &c.overflow, &c.negative} {
+		s.Bool(flag)
+	}
+	s.Bytes(c.ram)
+
+	s.Bool(&c.vblank)
+	s.Int(&c.cycleCount)
+	s.Int(&c.frame)
+	s.Int(&c.executed)
+	s.Uint8(&c.openBus)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/makononov/NESGo/cartridge"
+	"github.com/makononov/NESGo/cartridge/mappers"
+)
+
+// rewriteHeader writes a copy of a ROM with its header converted to NES 2.0,
+// taking corrections from the ROM database and then from flags, and checks
+// the copy loads with the header intended.
+func rewriteHeader(args []string) error {
+	flags := flag.NewFlagSet("header", flag.ExitOnError)
+	output := flags.String("o", "", "file to write the rewritten ROM to (required)")
+	flags.StringVar(&cartridge.DatabasePath, "db", "", "ROM database to identify dumps and correct their headers with")
+	noDatabase := flags.Bool("no-db", false, "don't take corrections from the ROM database")
+	mapperID := flags.Int("mapper", 0, "mapper number")
+	submapper := flags.Int("submapper", 0, "submapper number")
+	prgRAM := flags.Int("prg-ram", 0, "PRG RAM size in bytes")
+	prgNVRAM := flags.Int("prg-nvram", 0, "battery-backed PRG RAM size in bytes")
+	chrRAM := flags.Int("chr-ram", 0, "CHR RAM size in bytes")
+	chrNVRAM := flags.Int("chr-nvram", 0, "battery-backed CHR RAM size in bytes")
+	battery := flags.Bool("battery", false, "whether the cartridge has a battery")
+	timing := flags.String("timing", "", "CPU/PPU timing: ntsc, pal, multi or dendy")
+	mirroring := flags.String("mirroring", "", "hardwired mirroring: h, v or 4")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *output == "" {
+		return errors.New("usage: nesgo header -o output [flags] ROM")
+	}
+	input := flags.Arg(0)
+	if input == *output {
+		return errors.New("the rewritten ROM must go to a new file")
+	}
+
+	data, err := ioutil.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	report, err := cartridge.Inspect(data)
+	if err != nil {
+		return err
+	}
+	if report.Header == nil {
+		return fmt.Errorf("%s is a %s image, which has no iNES header", input, report.Format)
+	}
+
+	header := *report.Header
+	upgradeHeader(&header)
+	if report.DatabaseEntry != nil && !*noDatabase {
+		header.Correct(report.DatabaseEntry)
+		fmt.Printf("Using ROM database entry for %s\n", report.DatabaseEntry.Title)
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "mapper":
+			header.MapperID = *mapperID
+		case "submapper":
+			header.Submapper = *submapper
+		case "prg-ram":
+			header.PrgRamSize = *prgRAM
+		case "prg-nvram":
+			header.PrgNvramSize = *prgNVRAM
+		case "chr-ram":
+			header.ChrRamSize = *chrRAM
+		case "chr-nvram":
+			header.ChrNvramSize = *chrNVRAM
+		case "battery":
+			header.Battery = *battery
+		case "timing":
+			format, ok := map[string]int{"ntsc": cartridge.NTSC, "pal": cartridge.PAL,
+				"multi": cartridge.MultiRegion, "dendy": cartridge.Dendy}[strings.ToLower(*timing)]
+			if !ok {
+				flagErr = fmt.Errorf("unknown timing %q", *timing)
+			}
+			header.TVSystemFormat = format
+		case "mirroring":
+			switch strings.ToLower(*mirroring) {
+			case "h":
+				header.Mirroring, header.FourScreen = cartridge.MirrorHorizontal, false
+			case "v":
+				header.Mirroring, header.FourScreen = cartridge.MirrorVertical, false
+			case "4":
+				header.FourScreen = true
+			default:
+				flagErr = fmt.Errorf("unknown mirroring %q", *mirroring)
+			}
+		}
+	})
+	if flagErr != nil {
+		return flagErr
+	}
+
+	rewritten, err := cartridge.RewriteHeader(data, &header)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(*output, rewritten, 0644); err != nil {
+		return err
+	}
+
+	if err = verifyHeader(*output, &header); err != nil {
+		os.Remove(*output)
+		return fmt.Errorf("rewritten ROM failed verification: %w", err)
+	}
+	fmt.Printf("Wrote %s with NES 2.0 mapper %d.%d\n", *output, header.MapperID, header.Submapper)
+	return nil
+}
+
+// upgradeHeader converts a header to NES 2.0, giving an iNES 1.0 header the
+// RAM it assumes: 8KB of PRG RAM unless byte 8 gives more, battery-backed if
+// the battery bit is set, and 8KB of CHR RAM when there's no CHR ROM
+func upgradeHeader(header *cartridge.Header) {
+	if !header.NES2 {
+		if header.PrgRamSize == 0 {
+			header.PrgRamSize = 8192
+		}
+		if header.Battery {
+			header.PrgNvramSize, header.PrgRamSize = header.PrgRamSize, 0
+		}
+		if header.ChrRomSize == 0 {
+			header.ChrRamSize = 8192
+		}
+	}
+	header.NES2 = true
+	header.Junk = nil
+}
+
+// verifyHeader checks that a rewritten ROM decodes to the header intended
+// and loads. A mapper NESGo doesn't implement yet isn't a failure.
+func verifyHeader(filename string, want *cartridge.Header) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	got, err := cartridge.ParseHeader(data)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("header decodes as %+v, expected %+v", *got, *want)
+	}
+
+	cartridge.AutoPatch = false
+	_, err = cartridge.ParseROM(filename)
+	var unsupported *mapper.UnsupportedError
+	if errors.As(err, &unsupported) {
+		fmt.Printf("Note: %v, so the ROM was only checked as far as its header\n", err)
+		return nil
+	}
+	return err
+}
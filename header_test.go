@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/makononov/NESGo/cartridge"
+)
+
+func TestUpgradeHeaderPRGRAM(t *testing.T) {
+	tests := []struct {
+		name         string
+		battery      bool
+		prgRAM       int
+		wantPrgRAM   int
+		wantPrgNVRAM int
+	}{
+		{"no battery", false, 0, 8192, 0},
+		{"battery", true, 0, 0, 8192},
+		{"no battery, byte 8 set", false, 32768, 32768, 0},
+		{"battery, byte 8 set", true, 32768, 0, 32768},
+	}
+	for _, test := range tests {
+		header := cartridge.Header{Battery: test.battery, PrgRamSize: test.prgRAM, PrgRomSize: 32768, ChrRomSize: 8192}
+		upgradeHeader(&header)
+		if !header.NES2 {
+			t.Errorf("%s: header isn't NES 2.0", test.name)
+		}
+		if header.PrgRamSize != test.wantPrgRAM || header.PrgNvramSize != test.wantPrgNVRAM {
+			t.Errorf("%s: PRG RAM %d, NVRAM %d, want %d and %d", test.name,
+				header.PrgRamSize, header.PrgNvramSize, test.wantPrgRAM, test.wantPrgNVRAM)
+		}
+		if header.ChrRamSize != 0 {
+			t.Errorf("%s: CHR RAM %d with CHR ROM present", test.name, header.ChrRamSize)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"github.com/makononov/NESGo/cartridge"
+)
+
+// romInfo is what the info command reports about a ROM file
+type romInfo struct {
+	File   string
+	Format string
+
+	Header    *cartridge.Header `json:",omitempty"`
+	Board     string            `json:",omitempty"`
+	DiskSides int               `json:",omitempty"`
+
+	CRC32    string
+	SHA1     string
+	Database *databaseInfo `json:",omitempty"`
+
+	FileSize     int
+	ExpectedSize int
+	Lint         []string
+}
+
+// databaseInfo is the ROM database's entry for a known dump
+type databaseInfo struct {
+	Title     string
+	Region    string
+	Board     string
+	MapperID  int
+	Submapper int
+}
+
+// showInfo prints everything decoded from the headers of ROM files, their
+// hashes and database match, and anything wrong with them. With -json, each
+// file is printed as a JSON object on its own line.
+func showInfo(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print each file as a line of JSON")
+	flags.StringVar(&cartridge.DatabasePath, "db", "", "ROM database to identify dumps and correct their headers with")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: nesgo info [-json] [-db file] ROM...")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for i, filename := range flags.Args() {
+		info, err := inspect(filename)
+		if err != nil {
+			return err
+		}
+
+		if *asJSON {
+			if err = encoder.Encode(info); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+		if err = printInfo(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func inspect(filename string) (*romInfo, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	report, err := cartridge.Inspect(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	info := &romInfo{
+		File:         filename,
+		Format:       report.Format,
+		Header:       report.Header,
+		Board:        report.Board,
+		DiskSides:    report.DiskSides,
+		CRC32:        fmt.Sprintf("%08X", report.CRC32),
+		SHA1:         hex.EncodeToString(report.SHA1[:]),
+		FileSize:     report.FileSize,
+		ExpectedSize: report.ExpectedSize,
+		Lint:         report.Lint,
+	}
+	if entry := report.DatabaseEntry; entry != nil {
+		info.Database = &databaseInfo{entry.Title, entry.Region, entry.Board, entry.MapperID, entry.Submapper}
+	}
+	return info, nil
+}
+
+func printInfo(info *romInfo) error {
+	table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	row := func(name string, value interface{}) {
+		fmt.Fprintf(table, "%s:\t%v\n", name, value)
+	}
+
+	row("File", info.File)
+	row("Format", info.Format)
+
+	if h := info.Header; h != nil {
+		row("Mapper", fmt.Sprintf("%d.%d", h.MapperID, h.Submapper))
+		row("PRG ROM", h.PrgRomSize)
+		row("CHR ROM", h.ChrRomSize)
+		row("PRG RAM", h.PrgRamSize)
+		if h.NES2 {
+			row("PRG NVRAM", h.PrgNvramSize)
+			row("CHR RAM", h.ChrRamSize)
+			row("CHR NVRAM", h.ChrNvramSize)
+		}
+		row("Mirroring", mirroringName(h))
+		row("Battery", yesNo(h.Battery))
+		row("Trainer", yesNo(h.Trainer))
+		row("Console", consoleName(h))
+		row("Timing", timingName(h.TVSystemFormat))
+		if h.NES2 {
+			row("Misc ROMs", h.MiscROMs)
+			row("Expansion device", h.ExpansionDevice)
+		}
+		if h.Junk != nil {
+			row("Junk", fmt.Sprintf("%q", string(h.Junk)))
+		}
+	}
+	if info.Board != "" {
+		row("Board", info.Board)
+	}
+	if info.DiskSides > 0 {
+		row("Disk sides", info.DiskSides)
+	}
+
+	row("CRC32", info.CRC32)
+	row("SHA-1", info.SHA1)
+	if db := info.Database; db != nil {
+		row("Database", fmt.Sprintf("%s (%s), board %s, mapper %d.%d", db.Title, db.Region, db.Board, db.MapperID, db.Submapper))
+	} else {
+		row("Database", "no match")
+	}
+	row("File size", info.FileSize)
+	row("Expected size", info.ExpectedSize)
+	for _, finding := range info.Lint {
+		row("Lint", finding)
+	}
+
+	return table.Flush()
+}
+
+func mirroringName(h *cartridge.Header) string {
+	switch {
+	case h.FourScreen:
+		return "four-screen"
+	case h.Mirroring == cartridge.MirrorVertical:
+		return "vertical"
+	}
+	return "horizontal"
+}
+
+func consoleName(h *cartridge.Header) string {
+	switch h.ConsoleType {
+	case cartridge.ConsoleVsSystem:
+		return fmt.Sprintf("Vs. System (PPU %d, hardware %d)", h.VsPPU, h.VsHardware)
+	case cartridge.ConsolePlaychoice10:
+		return "PlayChoice-10"
+	case cartridge.ConsoleExtended:
+		return fmt.Sprintf("extended type %d", h.ExtendedConsole)
+	}
+	return "NES/Famicom"
+}
+
+func timingName(format int) string {
+	switch format {
+	case cartridge.PAL:
+		return "PAL"
+	case cartridge.MultiRegion:
+		return "multi-region"
+	case cartridge.Dendy:
+		return "Dendy"
+	}
+	return "NTSC"
+}
@@ -0,0 +1,58 @@
+package input
+
+// Buttons is the state of a standard controller, one bit per button in the
+// order the controller reports them.
+type Buttons uint8
+
+// The buttons of a standard controller
+const (
+	ButtonA Buttons = 1 << iota
+	ButtonB
+	ButtonSelect
+	ButtonStart
+	ButtonUp
+	ButtonDown
+	ButtonLeft
+	ButtonRight
+)
+
+// A Device is plugged into one of the two controller ports, which the CPU
+// reads through $4016 and $4017.
+type Device interface {
+	// Strobe is sent what the CPU writes to $4016, whose bit 0 drives the
+	// strobe line of both ports.
+	Strobe(value uint8)
+
+	// Read returns what the device puts on the low data lines when its port
+	// is read.
+	Read() uint8
+}
+
+// A Controller is the standard controller. While the strobe line is high it
+// reloads a shift register with its buttons, which are then read out one
+// bit at a time.
+type Controller struct {
+	Buttons Buttons
+
+	strobe bool
+	shift  uint8
+}
+
+// Strobe implements input.Device
+func (c *Controller) Strobe(value uint8) {
+	c.strobe = value&0x01 != 0
+	if c.strobe {
+		c.shift = uint8(c.Buttons)
+	}
+}
+
+// Read implements input.Device. Once all eight buttons have been read, an
+// official controller reports 1s.
+func (c *Controller) Read() uint8 {
+	if c.strobe {
+		return uint8(c.Buttons) & 0x01
+	}
+	value := c.shift & 0x01
+	c.shift = c.shift>>1 | 0x80
+	return value
+}
@@ -0,0 +1,29 @@
+package input
+
+import (
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// stateVersion is the version of a controller's save state section
+const stateVersion = 1
+
+// SaveState writes the controller's shift register. The buttons are saved
+// too, though they are normally set again before the next frame.
+func (c *Controller) SaveState(w io.Writer) error {
+	return state.Save(w, stateVersion, c.serialize)
+}
+
+// LoadState restores the controller from a state written by SaveState
+func (c *Controller) LoadState(r io.Reader) error {
+	return state.Load(r, stateVersion, c.serialize)
+}
+
+func (c *Controller) serialize(s *state.Stream) {
+	buttons := uint8(c.Buttons)
+	s.Uint8(&buttons)
+	c.Buttons = Buttons(buttons)
+	s.Bool(&c.strobe)
+	s.Uint8(&c.shift)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/makononov/NESGo/cartridge"
+	"github.com/makononov/NESGo/cartridge/mappers"
+)
+
+// listMappers prints the mappers NESGo supports
+func listMappers(args []string) error {
+	table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "MAPPER\tNAME\tBOARDS\tSUBMAPPERS\tIRQ\tAUDIO")
+
+	for _, registration := range mapper.Registered() {
+		id := fmt.Sprint(registration.ID)
+		if registration.Submapper != mapper.AnySubmapper {
+			id = fmt.Sprintf("%d.%d", registration.ID, registration.Submapper)
+		}
+
+		submappers := make([]string, len(registration.Submappers))
+		for i, submapper := range registration.Submappers {
+			submappers[i] = fmt.Sprint(submapper)
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", id, registration.Name,
+			strings.Join(registration.Boards, ", "), strings.Join(submappers, ", "),
+			yesNo(registration.IRQ), yesNo(registration.Audio))
+	}
+
+	return table.Flush()
+}
+
+// mapperName returns the name of the mapper a cartridge was loaded with
+func mapperName(cart *cartridge.Cartridge) string {
+	if factory, ok := mapper.Lookup(cart.MapperID, cart.Submapper); ok {
+		return factory.Name
+	}
+	return "unknown"
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,242 @@
+package movie
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/makononov/NESGo/input"
+)
+
+// fm2Buttons are the buttons of a gamepad in the order an FM2 input line
+// gives them, with the letter each is written as
+var fm2Buttons = []struct {
+	button input.Buttons
+	letter byte
+}{
+	{input.ButtonRight, 'R'},
+	{input.ButtonLeft, 'L'},
+	{input.ButtonDown, 'D'},
+	{input.ButtonUp, 'U'},
+	{input.ButtonStart, 'T'},
+	{input.ButtonSelect, 'S'},
+	{input.ButtonB, 'B'},
+	{input.ButtonA, 'A'},
+}
+
+// A ParseError reports a malformed line in an FM2 movie
+type ParseError struct {
+	Line   int
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("FM2 line %d: %s", e.Line, e.Reason)
+}
+
+// Read reads a movie in FCEUX's FM2 format: a header of "key value" lines,
+// followed by a line per frame of the form |commands|port0|port1|port2|.
+// Binary FM2 input, Four Score movies and input devices other than gamepads
+// aren't supported.
+func Read(r io.Reader) (*Movie, error) {
+	m := &Movie{Ports: [2]int{PortGamepad, PortGamepad}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+
+		var reason string
+		if text[0] == '|' {
+			var frame Frame
+			if frame, reason = m.parseFrame(text); reason == "" {
+				m.Frames = append(m.Frames, frame)
+			}
+		} else {
+			key, value, _ := strings.Cut(text, " ")
+			reason = m.parseHeader(key, value)
+		}
+		if reason != "" {
+			return nil, &ParseError{line, reason}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseHeader sets a header field, returning why it is invalid if it is.
+// Keys NESGo has no use for are ignored.
+func (m *Movie) parseHeader(key string, value string) string {
+	number := func(v *int) string {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Sprintf("%s %q isn't a number", key, value)
+		}
+		*v = n
+		return ""
+	}
+	flag := func(v *bool) string {
+		var n int
+		reason := number(&n)
+		*v = n != 0
+		return reason
+	}
+
+	switch key {
+	case "version":
+		var version int
+		if reason := number(&version); reason != "" {
+			return reason
+		}
+		if version != 3 {
+			return fmt.Sprintf("FM2 version %d isn't supported", version)
+		}
+	case "emuVersion":
+		return number(&m.EmuVersion)
+	case "rerecordCount":
+		return number(&m.RerecordCount)
+	case "palFlag":
+		return flag(&m.PAL)
+	case "romFilename":
+		m.ROMFilename = value
+	case "romChecksum":
+		checksum, err := decodeBinary(value)
+		if err != nil || len(checksum) != len(m.ROMChecksum) {
+			return fmt.Sprintf("Invalid ROM checksum %q", value)
+		}
+		copy(m.ROMChecksum[:], checksum)
+	case "guid":
+		m.GUID = value
+	case "comment":
+		m.Comments = append(m.Comments, value)
+	case "subtitle":
+		m.Subtitles = append(m.Subtitles, value)
+	case "port0", "port1":
+		port := &m.Ports[key[4]-'0']
+		if reason := number(port); reason != "" {
+			return reason
+		}
+		if *port != PortNone && *port != PortGamepad {
+			return fmt.Sprintf("Input device %d in %s isn't supported", *port, key)
+		}
+	case "fourscore", "binary":
+		var set bool
+		if reason := flag(&set); reason != "" || !set {
+			return reason
+		}
+		if key == "binary" {
+			return "Binary input isn't supported"
+		}
+		return "Four Score movies aren't supported"
+	case "savestate":
+		state, err := decodeBinary(value)
+		if err != nil {
+			return fmt.Sprintf("Invalid save state: %v", err)
+		}
+		m.SaveState = state
+	}
+	return ""
+}
+
+// parseFrame parses an input line, returning why it is invalid if it is
+func (m *Movie) parseFrame(text string) (Frame, string) {
+	var frame Frame
+	fields := strings.Split(text, "|")
+	if len(fields) < 5 {
+		return frame, "Input line has too few fields"
+	}
+
+	commands, err := strconv.Atoi(fields[1])
+	if err != nil || commands < 0 || commands > 0xff {
+		return frame, fmt.Sprintf("Invalid commands %q", fields[1])
+	}
+	frame.Commands = Command(commands)
+
+	for port := range frame.Buttons {
+		field := fields[port+2]
+		if m.Ports[port] == PortNone {
+			continue
+		}
+		if len(field) != len(fm2Buttons) {
+			return frame, fmt.Sprintf("Gamepad input %q isn't %d buttons", field, len(fm2Buttons))
+		}
+		for i, b := range fm2Buttons {
+			if field[i] != ' ' && field[i] != '.' {
+				frame.Buttons[port] |= b.button
+			}
+		}
+	}
+	return frame, ""
+}
+
+// Write writes the movie in FM2 format
+func (m *Movie) Write(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "version 3\n")
+	fmt.Fprintf(out, "emuVersion %d\n", m.EmuVersion)
+	fmt.Fprintf(out, "rerecordCount %d\n", m.RerecordCount)
+	fmt.Fprintf(out, "palFlag %d\n", flag(m.PAL))
+	fmt.Fprintf(out, "romFilename %s\n", m.ROMFilename)
+	fmt.Fprintf(out, "romChecksum base64:%s\n", base64.StdEncoding.EncodeToString(m.ROMChecksum[:]))
+	fmt.Fprintf(out, "guid %s\n", m.GUID)
+	fmt.Fprintf(out, "fourscore 0\n")
+	fmt.Fprintf(out, "microphone 0\n")
+	fmt.Fprintf(out, "port0 %d\nport1 %d\nport2 0\n", m.Ports[0], m.Ports[1])
+	fmt.Fprintf(out, "FDS 0\n")
+	fmt.Fprintf(out, "NewPPU 0\n")
+	for _, comment := range m.Comments {
+		fmt.Fprintf(out, "comment %s\n", comment)
+	}
+	for _, subtitle := range m.Subtitles {
+		fmt.Fprintf(out, "subtitle %s\n", subtitle)
+	}
+	if m.SaveState != nil {
+		fmt.Fprintf(out, "savestate base64:%s\n", base64.StdEncoding.EncodeToString(m.SaveState))
+	}
+
+	for _, frame := range m.Frames {
+		fmt.Fprintf(out, "|%d|", frame.Commands)
+		for port, buttons := range frame.Buttons {
+			if m.Ports[port] != PortNone {
+				for _, b := range fm2Buttons {
+					if buttons&b.button != 0 {
+						out.WriteByte(b.letter)
+					} else {
+						out.WriteByte('.')
+					}
+				}
+			}
+			out.WriteByte('|')
+		}
+		out.WriteString("|\n")
+	}
+	return out.Flush()
+}
+
+// decodeBinary decodes binary data in a header, which FCEUX writes either
+// as base64 with a "base64:" prefix or as hex with a "0x" prefix
+func decodeBinary(value string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(value, "base64:"):
+		return base64.StdEncoding.DecodeString(value[len("base64:"):])
+	case strings.HasPrefix(value, "0x"):
+		return hex.DecodeString(value[2:])
+	}
+	return nil, errors.New("Unknown encoding")
+}
+
+func flag(set bool) int {
+	if set {
+		return 1
+	}
+	return 0
+}
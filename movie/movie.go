@@ -0,0 +1,88 @@
+// Package movie holds input movies: the controller input for each frame of
+// a run, with the resets and power cycles made during it, which replay the
+// run exactly when played back from the same start. Movies are read and
+// written in FCEUX's FM2 text format, so existing TAS movies can be
+// replayed.
+package movie
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/makononov/NESGo/input"
+)
+
+// A Command is something done to the console at the start of a frame,
+// FCEUX's bit for it
+type Command uint8
+
+// Commands an FM2 movie can give
+const (
+	CommandReset Command = 1 << iota
+	CommandPower
+	CommandFDSInsert
+	CommandFDSSelect
+	CommandVSCoin
+)
+
+// What can be plugged into a port, as FM2 numbers them
+const (
+	PortNone    = 0
+	PortGamepad = 1
+	PortZapper  = 2
+)
+
+// A Frame is the input for one frame
+type Frame struct {
+	Commands Command
+	Buttons  [2]input.Buttons
+}
+
+// A Movie is a recorded run
+type Movie struct {
+	// EmuVersion is the version of the emulator that recorded the movie.
+	// NESGo records 0.
+	EmuVersion int
+
+	// RerecordCount is how many times a save state was loaded while the
+	// movie was being recorded
+	RerecordCount int
+
+	// PAL is whether the movie was recorded on a PAL console
+	PAL bool
+
+	// ROMFilename and ROMChecksum identify the ROM the movie was recorded
+	// with. The checksum is an MD5 hash of the PRG and CHR ROM, or zero if
+	// it isn't known.
+	ROMFilename string
+	ROMChecksum [16]byte
+
+	// GUID identifies the movie, so save states can be matched to it
+	GUID string
+
+	// Comments and Subtitles are kept as they are, one per line
+	Comments  []string
+	Subtitles []string
+
+	// Ports is what is plugged into each controller port. Only gamepads are
+	// supported.
+	Ports [2]int
+
+	// SaveState is the save state the movie starts from, or nil if it
+	// starts from power-on
+	SaveState []byte
+
+	Frames []Frame
+}
+
+// New returns an empty movie with two gamepads and a new GUID
+func New() *Movie {
+	return &Movie{Ports: [2]int{PortGamepad, PortGamepad}, GUID: newGUID()}
+}
+
+// newGUID returns a random GUID in the form FCEUX writes
+func newGUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,325 @@
+// Package nes assembles the CPU, PPU, APU, cartridge and controllers into a
+// console that can be embedded in other programs and run a frame at a time.
+package nes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"runtime/debug"
+	"time"
+
+	"github.com/makononov/NESGo/apu"
+	"github.com/makononov/NESGo/cartridge"
+	"github.com/makononov/NESGo/cartridge/mappers"
+	"github.com/makononov/NESGo/cpu"
+	"github.com/makononov/NESGo/input"
+	"github.com/makononov/NESGo/movie"
+	"github.com/makononov/NESGo/ppu"
+)
+
+// Options configures a console
+type Options struct {
+	// SampleRate is the audio sample rate in Hz, apu.DefaultSampleRate if
+	// zero
+	SampleRate int
+
+	// Trace, if set, is written a line for each instruction executed
+	Trace io.Writer
+
+	// RewindBudget is how many bytes of memory to keep snapshots for Rewind
+	// in, or zero to disable it. RewindInterval is how many frames apart
+	// the snapshots are, DefaultRewindInterval if zero. Rewinding needs
+	// save states, which the cartridge's mapper must support.
+	RewindBudget   int
+	RewindInterval int
+}
+
+// A Console is an NES with a cartridge inserted and standard controllers
+// plugged into both ports. Its methods must not be called concurrently.
+//
+// Emulation errors are returned as a *cpu.Fault, which gives the subsystem
+// that failed and the instruction executing. After one, the console should
+// be reset or closed.
+type Console struct {
+	Cartridge *cartridge.Cartridge
+
+	cpu         *cpu.CPU
+	ppu         *ppu.PPU
+	apu         *apu.APU
+	controllers [2]*input.Controller
+
+	// clocked is the cartridge's mapper if it counts CPU cycles
+	clocked mapper.ClockedMapper
+
+	trace io.Writer
+
+	// rewind is nil unless Options.RewindBudget is set
+	rewind *rewindBuffer
+
+	// movie is being recorded or played back, as movieMode says, and
+	// movieFrame frames of it have been. movieCommands are the resets and
+	// power cycles to record in the next frame.
+	movie         *movie.Movie
+	movieMode     int
+	movieReadOnly bool
+	movieFrame    int
+	movieCommands movie.Command
+
+	// The buses between the CPU and the PPU and cartridge, which run in
+	// their own goroutines until stop is called
+	dataBus             chan uint8
+	readWriteBus        chan int
+	cartridgeControlBus chan uint16
+	ppuControlBus       chan uint16
+	vblankBus           chan bool
+	faultBus            chan error
+	stop                context.CancelFunc
+}
+
+// New powers up a console with a cartridge, which must have been loaded by
+// one of the cartridge package's parse functions. It must be closed when no
+// longer needed.
+func New(cart *cartridge.Cartridge, opts Options) (*Console, error) {
+	if cart == nil || cart.Mapper == nil {
+		return nil, errors.New("Cartridge has no mapper")
+	}
+
+	c := &Console{
+		Cartridge:           cart,
+		cpu:                 new(cpu.CPU),
+		ppu:                 new(ppu.PPU),
+		apu:                 new(apu.APU),
+		dataBus:             make(chan uint8),
+		readWriteBus:        make(chan int),
+		cartridgeControlBus: make(chan uint16),
+		ppuControlBus:       make(chan uint16),
+		vblankBus:           make(chan bool),
+		faultBus:            make(chan error, 1),
+		trace:               opts.Trace,
+	}
+
+	clockRate := apu.ClockNTSC
+	if cart.TVSystemFormat == cartridge.PAL {
+		clockRate = apu.ClockPAL
+	}
+	c.apu.Init(clockRate, opts.SampleRate)
+	c.connectMapper()
+
+	for port := range c.controllers {
+		c.controllers[port] = new(input.Controller)
+	}
+	c.initCPU()
+	c.ppu.Init(c.ppuControlBus, c.readWriteBus, c.dataBus, c.vblankBus, c.faultBus)
+	c.ppu.ConnectMemory(cart)
+
+	ctx, stop := context.WithCancel(context.Background())
+	c.stop = stop
+	go cart.WaitForReadWrite(ctx, c.cartridgeControlBus, c.readWriteBus, c.dataBus, c.faultBus)
+	go c.ppu.Run(ctx)
+
+	if err := c.cpu.Reset(); err != nil {
+		stop()
+		return nil, err
+	}
+
+	if opts.RewindBudget > 0 {
+		c.rewind = &rewindBuffer{budget: opts.RewindBudget, interval: opts.RewindInterval}
+		if c.rewind.interval <= 0 {
+			c.rewind.interval = DefaultRewindInterval
+		}
+		if err := c.rewind.record(c); err != nil {
+			stop()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// connectMapper connects the mapper's clock and expansion audio. The CPU's
+// connections to it are made by initCPU.
+func (c *Console) connectMapper() {
+	c.clocked, _ = c.Cartridge.Mapper.(mapper.ClockedMapper)
+	c.apu.Expansion = nil
+	if audio, ok := c.Cartridge.Mapper.(mapper.AudioMapper); ok {
+		c.apu.Expansion = audio.Output
+	}
+}
+
+// initCPU puts the CPU in its power-up state, connected to the rest of the
+// console
+func (c *Console) initCPU() {
+	c.cpu.Init(c.ppuControlBus, c.cartridgeControlBus, c.readWriteBus, c.dataBus, c.vblankBus, c.faultBus)
+	c.cpu.Trace = c.trace
+	c.cpu.ConnectAPU(c.apu)
+	for port, controller := range c.controllers {
+		c.cpu.ConnectInput(port, controller)
+	}
+	if irq, ok := c.Cartridge.Mapper.(mapper.IRQMapper); ok {
+		c.cpu.ConnectIRQ(irq)
+	}
+	if monitor, ok := c.Cartridge.Mapper.(mapper.BusMonitor); ok {
+		c.cpu.ConnectMonitor(monitor)
+	}
+}
+
+// Reset presses the reset button
+func (c *Console) Reset() error {
+	if c.movieMode == MovieRecording {
+		c.movieCommands |= movie.CommandReset
+	}
+	c.ppu.Reset()
+	c.apu.Reset()
+	c.Cartridge.Reset()
+	return c.cpu.Reset()
+}
+
+// PowerCycle turns the console off and on again, with the cartridge's
+// mapper replaced by a new one in its power-up state
+func (c *Console) PowerCycle() error {
+	if err := c.Cartridge.PowerCycle(); err != nil {
+		return err
+	}
+	if c.movieMode == MovieRecording {
+		c.movieCommands |= movie.CommandPower
+	}
+	c.ppu.PowerUp()
+	c.apu.Init(c.apu.ClockRate(), c.apu.SampleRate())
+	c.connectMapper()
+	c.initCPU()
+	return c.cpu.Reset()
+}
+
+// StepFrame runs the console until the PPU finishes a frame, returning the
+// frame and the audio samples generated while it ran. Both are only valid
+// until the next call. If emulation fails, the frame is left unfinished.
+func (c *Console) StepFrame() (picture *image.Paletted, audio []float32, err error) {
+	subsystem := cpu.SubsystemCPU
+	defer func() {
+		if r := recover(); r != nil {
+			err = c.cpu.Fault(subsystem, fmt.Errorf("Panic: %v\n%s", r, debug.Stack()))
+		}
+	}()
+
+	if err := c.stepMovie(); err != nil {
+		return nil, nil, err
+	}
+
+	frame := c.cpu.Frame()
+	for c.cpu.Frame() == frame {
+		subsystem = cpu.SubsystemCPU
+		cycles, err := c.cpu.Step()
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := 0; i < cycles; i++ {
+			subsystem = cpu.SubsystemPPU
+			c.ppu.Clock()
+			if c.clocked != nil {
+				subsystem = cpu.SubsystemCartridge
+				c.clocked.Clock()
+			}
+			subsystem = cpu.SubsystemAPU
+			c.apu.Clock()
+		}
+	}
+
+	if c.rewind != nil {
+		subsystem = cpu.SubsystemCPU
+		if err := c.rewind.record(c); err != nil {
+			return nil, nil, err
+		}
+	}
+	return c.ppu.Frame(), c.apu.Samples(), nil
+}
+
+// Run steps frames until ctx is done or emulation fails, passing each frame
+// and its audio to handle if it isn't nil. It returns ctx.Err() once ctx is
+// done.
+func (c *Console) Run(ctx context.Context, handle func(picture *image.Paletted, audio []float32)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		picture, audio, err := c.StepFrame()
+		if err != nil {
+			return err
+		}
+		if handle != nil {
+			handle(picture, audio)
+		}
+	}
+}
+
+// FrameDuration is how long a frame takes on the console being emulated
+func (c *Console) FrameDuration() time.Duration {
+	// 29780.5 CPU cycles per NTSC frame; PAL consoles use 33247.5
+	cycles := 29780.5
+	if c.apu.ClockRate() == apu.ClockPAL {
+		cycles = 33247.5
+	}
+	return time.Duration(cycles / float64(c.apu.ClockRate()) * float64(time.Second))
+}
+
+// Close shuts the console down: it stops the goroutines running the PPU and
+// cartridge, writes the cartridge's save and flushes the trace writer if it
+// is buffered. It may be called more than once.
+func (c *Console) Close() error {
+	if c.stop == nil {
+		return nil
+	}
+	c.stop()
+	c.stop = nil
+
+	err := c.Cartridge.Save()
+	if flusher, ok := c.trace.(interface{ Flush() error }); ok {
+		if flushErr := flusher.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// Frame returns the number of frames run since power-up
+func (c *Console) Frame() int {
+	return c.cpu.Frame()
+}
+
+// SetInput sets the buttons held on the controller in port 0 or 1. While a
+// movie is playing back, it drives the controllers instead.
+func (c *Console) SetInput(port int, buttons input.Buttons) error {
+	if port < 0 || port >= len(c.controllers) {
+		return fmt.Errorf("No controller port %d", port)
+	}
+	if c.movieMode == MoviePlaying {
+		return nil
+	}
+	c.controllers[port].Buttons = buttons
+	return nil
+}
+
+// SampleRate is the rate StepFrame's audio samples are generated at in Hz
+func (c *Console) SampleRate() int {
+	return c.apu.SampleRate()
+}
+
+// RAM returns the console's 2KB of internal RAM, which may be modified
+func (c *Console) RAM() []byte {
+	return c.cpu.RAM()
+}
+
+// ReadMemory reads a byte from the CPU address space, with the same side
+// effects as the CPU reading it
+func (c *Console) ReadMemory(address uint16) (uint8, error) {
+	return c.cpu.ReadMemory(address)
+}
+
+// WriteMemory writes a byte to the CPU address space
+func (c *Console) WriteMemory(address uint16, value uint8) error {
+	return c.cpu.WriteMemory(address, value)
+}
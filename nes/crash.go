@@ -0,0 +1,214 @@
+package nes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/makononov/NESGo/cpu"
+)
+
+// CrashReport is the name of the summary in a crash report directory
+const CrashReport = "report.txt"
+
+// WriteCrashReport writes a crash report for err, normally the fault that
+// stopped emulation, to a new directory under dir and returns its path.
+//
+// The directory holds report.txt, with the error, the ROM's hashes, the CPU
+// registers, the last instructions executed, disassembled, and the state of
+// the PPU, APU and mapper; and dumps of the console's memory: ram.bin,
+// prg-ram.bin, chr-ram.bin, vram.bin and oam.bin, those the cartridge has.
+func (c *Console) WriteCrashReport(dir string, err error) (string, error) {
+	if mkErr := os.MkdirAll(dir, 0755); mkErr != nil {
+		return "", mkErr
+	}
+	path, mkErr := os.MkdirTemp(dir, "crash-"+time.Now().Format("20060102-150405")+"-")
+	if mkErr != nil {
+		return "", mkErr
+	}
+
+	cart := c.Cartridge
+	dumps := []struct {
+		name string
+		data []byte
+	}{
+		{"ram.bin", c.cpu.RAM()},
+		{"prg-ram.bin", c.prgRAM()},
+		{"chr-ram.bin", cart.CHR[cart.ChrRomSize:]},
+		{"vram.bin", cart.VRAM},
+		{"oam.bin", c.ppu.OAM()},
+	}
+	var written []string
+	for _, dump := range dumps {
+		if len(dump.data) == 0 {
+			continue
+		}
+		if writeErr := os.WriteFile(filepath.Join(path, dump.name), dump.data, 0644); writeErr != nil {
+			return path, writeErr
+		}
+		written = append(written, dump.name)
+	}
+
+	file, createErr := os.Create(filepath.Join(path, CrashReport))
+	if createErr != nil {
+		return path, createErr
+	}
+	c.writeReport(file, err, written)
+	return path, file.Close()
+}
+
+// writeReport writes the summary of a crash report
+func (c *Console) writeReport(w io.Writer, err error, dumps []string) {
+	cart := c.Cartridge
+	fmt.Fprintf(w, "NESGo crash report, %s\n\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(w, "Error: %v\n", err)
+	var fault *cpu.Fault
+	if errors.As(err, &fault) {
+		fmt.Fprintf(w, "Subsystem: %s\nAddress: $%04x\n", fault.Subsystem, fault.Address)
+	}
+
+	fmt.Fprintf(w, "\nROM\n")
+	fmt.Fprintf(w, "  CRC32: %08x\n  SHA-1: %x\n", cart.CRC32, cart.SHA1)
+	if entry := cart.DatabaseEntry; entry != nil {
+		fmt.Fprintf(w, "  Title: %s (%s), board %s\n", entry.Title, entry.Region, entry.Board)
+	}
+	if cart.Board != "" {
+		fmt.Fprintf(w, "  Board: %s\n", cart.Board)
+	}
+	fmt.Fprintf(w, "  Mapper: %d, submapper %d\n", cart.MapperID, cart.Submapper)
+	fmt.Fprintf(w, "  PRG ROM: %d, CHR ROM: %d, PRG RAM: %d, CHR RAM: %d\n", cart.PrgRomSize, cart.ChrRomSize, cart.PrgRamSize+cart.PrgNvramSize, len(cart.CHR)-cart.ChrRomSize)
+	for _, patch := range cart.Patches {
+		fmt.Fprintf(w, "  Patch: %s\n", patch)
+	}
+
+	r := c.cpu.Registers()
+	fmt.Fprintf(w, "\nCPU, frame %d\n", c.cpu.Frame())
+	fmt.Fprintf(w, "  PC:%04x A:%02x X:%02x Y:%02x SP:%02x P:%02x %s\n", r.PC, r.A, r.X, r.Y, r.SP, r.P, flags(r.P))
+
+	fmt.Fprintf(w, "\nLast instructions, oldest first\n")
+	for _, inst := range c.cpu.History() {
+		bytes := fmt.Sprintf("%02x", inst.Opcode)
+		for i := 1; i < cpu.Length(inst.Opcode); i++ {
+			bytes += fmt.Sprintf(" %02x", inst.Operands[i-1])
+		}
+		fmt.Fprintf(w, "  %04x  %-8s  %-14s  A:%02x X:%02x Y:%02x SP:%02x P:%02x\n", inst.PC, bytes, inst, inst.A, inst.X, inst.Y, inst.SP, inst.P)
+	}
+
+	fmt.Fprintf(w, "\nPPU\n")
+	describe(w, "  ", reflect.ValueOf(c.ppu), 0)
+	fmt.Fprintf(w, "\nAPU\n")
+	describe(w, "  ", reflect.ValueOf(c.apu), 0)
+	fmt.Fprintf(w, "\nMapper %T\n", cart.Mapper)
+	describe(w, "  ", reflect.ValueOf(cart.Mapper), 0)
+
+	fmt.Fprintf(w, "\nDumps: %s\n", strings.Join(dumps, ", "))
+}
+
+// flags spells out the processor status, capitals for flags that are set
+func flags(p uint8) string {
+	names := []byte("NV-BDIZC")
+	for i := range names {
+		if p&(0x80>>uint(i)) == 0 {
+			names[i] |= 0x20 // lower case
+		}
+	}
+	return string(names)
+}
+
+// prgRAM returns the cartridge's PRG RAM. Mappers that decode PRG RAM
+// themselves keep it in a RAM field in place of the cartridge's.
+func (c *Console) prgRAM() []byte {
+	v := reflect.Indirect(reflect.ValueOf(c.Cartridge.Mapper))
+	if v.Kind() == reflect.Struct {
+		if field := v.FieldByName("RAM"); field.IsValid() && field.Type() == reflect.TypeOf([]byte(nil)) && field.Len() > 0 {
+			return field.Bytes()
+		}
+	}
+	return c.Cartridge.RAM
+}
+
+// describe writes out the fields of emulator state, one per line, including
+// unexported ones. Large arrays and slices are summarized, and channels and
+// functions left out.
+func describe(w io.Writer, indent string, v reflect.Value, depth int) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			fmt.Fprintf(w, "%snil\n", indent)
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		fmt.Fprintf(w, "%s%s\n", indent, value(v))
+		return
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if kind := field.Type.Kind(); kind == reflect.Chan || kind == reflect.Func {
+			continue
+		}
+		fmt.Fprintf(w, "%s%s: %s\n", indent, field.Name, value(v.Field(i)))
+		if inner := reflect.Indirect(v.Field(i)); inner.Kind() == reflect.Struct && depth < 3 && !v.Field(i).IsZero() {
+			describe(w, indent+"  ", inner, depth+1)
+		}
+	}
+}
+
+// value formats one field for describe. Structs are described on the lines
+// that follow.
+func value(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Bool:
+		return fmt.Sprint(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprint(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fmt.Sprintf("$%x", v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Float())
+	case reflect.String:
+		return fmt.Sprintf("%q", v.String())
+	case reflect.Array, reflect.Slice:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return "nil"
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && v.Len() <= 32 {
+			return fmt.Sprintf("% x", toBytes(v))
+		}
+		if v.Len() > 16 {
+			return fmt.Sprintf("[%d elements]", v.Len())
+		}
+		elements := make([]string, v.Len())
+		for i := range elements {
+			elements[i] = value(v.Index(i))
+		}
+		return "[" + strings.Join(elements, " ") + "]"
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "nil"
+		}
+		if v.Elem().Kind() == reflect.Struct {
+			return v.Elem().Type().String()
+		}
+		return value(v.Elem())
+	case reflect.Struct:
+		return v.Type().String()
+	case reflect.Map:
+		return fmt.Sprintf("[%d entries]", v.Len())
+	}
+	return v.Type().String()
+}
+
+// toBytes copies a byte array or slice, which may be unexported
+func toBytes(v reflect.Value) []byte {
+	bytes := make([]byte, v.Len())
+	for i := range bytes {
+		bytes[i] = uint8(v.Index(i).Uint())
+	}
+	return bytes
+}
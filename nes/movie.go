@@ -0,0 +1,197 @@
+package nes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/makononov/NESGo/cartridge"
+	"github.com/makononov/NESGo/movie"
+	"github.com/makononov/NESGo/state"
+)
+
+// movieStateVersion is the version of the save state section giving the
+// movie frame
+const movieStateVersion = 1
+
+// What the console is doing with a movie
+const (
+	MovieOff = iota
+	MovieRecording
+	MoviePlaying
+)
+
+// Record starts recording a movie of the console's input, from power-on if
+// fromPowerOn is set, which power cycles the console, or from a save state
+// of it as it is. Each frame stepped appends the controller input set with
+// SetInput to the movie, along with any Reset or PowerCycle before it. The
+// movie is recorded until StopMovie is called, and may be written out at
+// any time.
+//
+// Loading a save state made during the recording goes back to the frame it
+// was made at, discarding the frames after it and counting a rerecord.
+func (c *Console) Record(fromPowerOn bool) (*movie.Movie, error) {
+	m := movie.New()
+	m.PAL = c.Cartridge.TVSystemFormat == cartridge.PAL
+	m.ROMChecksum = c.Cartridge.MD5
+	if c.Cartridge.SavePath != "" {
+		m.ROMFilename = strings.TrimSuffix(filepath.Base(c.Cartridge.SavePath), ".sav")
+	}
+
+	c.StopMovie()
+	if fromPowerOn {
+		if err := c.PowerCycle(); err != nil {
+			return nil, err
+		}
+	} else {
+		var start bytes.Buffer
+		if err := c.SaveState(&start); err != nil {
+			return nil, err
+		}
+		m.SaveState = start.Bytes()
+	}
+
+	c.movie, c.movieMode, c.movieFrame = m, MovieRecording, 0
+	return m, nil
+}
+
+// Play starts playing a movie back from its start, which drives the
+// controllers in place of SetInput. A movie with a ROM checksum must be of
+// the cartridge's ROM. Movies starting from a save state must have been
+// recorded by NESGo, as FCEUX's save states can't be loaded.
+//
+// In read-only mode, playback ends with the movie, and loading a save state
+// made during it just moves playback to the state's frame. Otherwise, it
+// continues by recording, and loading a save state takes over the movie
+// from there, as Record does.
+func (c *Console) Play(m *movie.Movie, readOnly bool) error {
+	if m.ROMChecksum != ([16]byte{}) && m.ROMChecksum != c.Cartridge.MD5 {
+		return fmt.Errorf("Movie is of a different ROM, %s", m.ROMFilename)
+	}
+
+	c.StopMovie()
+	if m.SaveState != nil {
+		if err := c.LoadState(bytes.NewReader(m.SaveState)); err != nil {
+			return fmt.Errorf("Loading movie's save state: %v", err)
+		}
+	} else if err := c.PowerCycle(); err != nil {
+		return err
+	}
+
+	c.movie, c.movieMode, c.movieFrame = m, MoviePlaying, 0
+	c.movieReadOnly = readOnly
+	return nil
+}
+
+// StopMovie stops recording or playing a movie
+func (c *Console) StopMovie() {
+	c.movie, c.movieMode, c.movieFrame = nil, MovieOff, 0
+	c.movieCommands = 0
+}
+
+// MovieMode returns whether a movie is being recorded or played back
+func (c *Console) MovieMode() int {
+	return c.movieMode
+}
+
+// MovieFrame returns how many frames of the movie have been recorded or
+// played back
+func (c *Console) MovieFrame() int {
+	return c.movieFrame
+}
+
+// stepMovie records the input for the frame about to be run, or plays it
+// back
+func (c *Console) stepMovie() error {
+	if c.movieMode == MoviePlaying && c.movieFrame == len(c.movie.Frames) {
+		if c.movieReadOnly {
+			c.StopMovie()
+			return nil
+		}
+		c.movieMode = MovieRecording
+	}
+
+	switch c.movieMode {
+	case MovieRecording:
+		frame := movie.Frame{Commands: c.movieCommands}
+		for port, controller := range c.controllers {
+			frame.Buttons[port] = controller.Buttons
+		}
+		c.movie.Frames = append(c.movie.Frames, frame)
+		c.movieCommands = 0
+
+	case MoviePlaying:
+		frame := c.movie.Frames[c.movieFrame]
+		if commands := frame.Commands &^ (movie.CommandReset | movie.CommandPower); commands != 0 {
+			return fmt.Errorf("Movie frame %d has unsupported commands $%02x", c.movieFrame, uint8(commands))
+		}
+		if frame.Commands&movie.CommandPower != 0 {
+			if err := c.PowerCycle(); err != nil {
+				return err
+			}
+		} else if frame.Commands&movie.CommandReset != 0 {
+			if err := c.Reset(); err != nil {
+				return err
+			}
+		}
+		for port, controller := range c.controllers {
+			controller.Buttons = frame.Buttons[port]
+		}
+
+	default:
+		return nil
+	}
+	c.movieFrame++
+	return nil
+}
+
+// serializeMovie saves the movie frame in save states, which is -1 if no
+// movie was running
+func (c *Console) serializeMovie(s *state.Stream) {
+	frame := -1
+	if c.movie != nil {
+		frame = c.movieFrame
+	}
+	s.Int(&frame)
+}
+
+// loadMovieFrame reads the movie frame a save state was made at, -1 if it
+// wasn't made during a movie
+func loadMovieFrame(chunks map[string][]byte) (int, error) {
+	frame := -1
+	data, ok := chunks["MOVI"]
+	if !ok {
+		return frame, nil
+	}
+	err := state.Load(bytes.NewReader(data), movieStateVersion, func(s *state.Stream) {
+		s.Int(&frame)
+	})
+	return frame, err
+}
+
+// checkMovieFrame returns why the running movie can't be moved to the frame
+// of a save state, if it can't
+func (c *Console) checkMovieFrame(frame int) error {
+	if frame < 0 {
+		return errors.New("Save state wasn't made during the movie")
+	}
+	if frame > len(c.movie.Frames) {
+		return fmt.Errorf("Save state is from frame %d, after the movie's end at %d", frame, len(c.movie.Frames))
+	}
+	return nil
+}
+
+// seekMovie moves the running movie to the frame of a save state that has
+// been loaded. Unless it is being played back read-only, that takes the
+// movie over from there.
+func (c *Console) seekMovie(frame int) {
+	if c.movieMode == MovieRecording || !c.movieReadOnly {
+		c.movie.Frames = c.movie.Frames[:frame]
+		c.movie.RerecordCount++
+		c.movieMode = MovieRecording
+	}
+	c.movieFrame = frame
+	c.movieCommands = 0
+}
@@ -0,0 +1,107 @@
+package nes
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// DefaultRewindInterval is how many frames apart rewind snapshots are taken
+// when Options.RewindInterval is zero
+const DefaultRewindInterval = 1
+
+// rewindBuffer holds the console's recent history for rewinding. The newest
+// snapshot is kept whole, and each older one as a delta against the one
+// after it, so stepping back undoes one delta at a time and the oldest can
+// be dropped on its own when the buffer goes over its memory budget.
+type rewindBuffer struct {
+	budget   int
+	interval int
+
+	// frame counts the frames stepped, and rewinding winds it back. It is
+	// kept apart from the CPU's frame count, which loading a state or power
+	// cycling changes.
+	frame int
+
+	// latest is the newest snapshot, taken at frame latestFrame
+	latest      []byte
+	latestFrame int
+
+	// deltas turn each snapshot into the one before it, oldest first, and
+	// frames are the frames they were taken at
+	deltas [][]byte
+	frames []int
+	size   int
+}
+
+// record counts a frame and takes a snapshot of the console if one is due
+func (b *rewindBuffer) record(c *Console) error {
+	if b.latest != nil {
+		b.frame++
+		if b.frame-b.latestFrame < b.interval {
+			return nil
+		}
+	}
+
+	var snapshot bytes.Buffer
+	if err := c.SaveState(&snapshot); err != nil {
+		return err
+	}
+	if b.latest != nil {
+		delta := state.Delta(snapshot.Bytes(), b.latest)
+		b.deltas = append(b.deltas, delta)
+		b.frames = append(b.frames, b.latestFrame)
+		b.size += len(delta)
+	}
+	b.latest = snapshot.Bytes()
+	b.latestFrame = b.frame
+
+	for len(b.deltas) > 0 && b.size+len(b.latest) > b.budget {
+		b.size -= len(b.deltas[0])
+		b.deltas[0] = nil
+		b.deltas = b.deltas[1:]
+		b.frames = b.frames[1:]
+	}
+	return nil
+}
+
+// Rewind restores the console to how it was the given number of frames
+// ago, or as near as it can with snapshots taken every RewindInterval
+// frames: to the newest snapshot at least that old, or the oldest kept if
+// there is none. It returns how many frames it went back. Given the same
+// input from there, the console runs as it did before.
+//
+// Snapshots newer than the one restored are discarded, so that the history
+// follows the new course of the game.
+func (c *Console) Rewind(frames int) (int, error) {
+	b := c.rewind
+	if b == nil {
+		return 0, errors.New("Rewind isn't enabled")
+	}
+	if b.latest == nil {
+		return 0, nil
+	}
+
+	current := b.frame
+	target := current - frames
+	snapshot, frame := b.latest, b.latestFrame
+	deltas, frameList, size := b.deltas, b.frames, b.size
+	for frame > target && len(deltas) > 0 {
+		last := len(deltas) - 1
+		older, err := state.ApplyDelta(snapshot, deltas[last])
+		if err != nil {
+			return 0, err
+		}
+		size -= len(deltas[last])
+		snapshot, frame = older, frameList[last]
+		deltas, frameList = deltas[:last], frameList[:last]
+	}
+
+	if err := c.LoadState(bytes.NewReader(snapshot)); err != nil {
+		return 0, err
+	}
+	b.latest, b.latestFrame, b.frame = snapshot, frame, frame
+	b.deltas, b.frames, b.size = deltas, frameList, size
+	return current - frame, nil
+}
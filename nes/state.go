@@ -0,0 +1,151 @@
+package nes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/cartridge/mappers"
+	"github.com/makononov/NESGo/state"
+)
+
+// romStateVersion is the version of the save state section identifying the
+// ROM
+const romStateVersion = 1
+
+// A section is one component's chunk of a save state
+type section struct {
+	id   string
+	name string
+	save func(w io.Writer) error
+	load func(r io.Reader) error
+}
+
+// sections lists the components saved in a save state, after the ROM. It
+// fails if the mapper can't be saved, as a state without it couldn't be
+// restored.
+func (c *Console) sections() ([]section, error) {
+	m, ok := c.Cartridge.Mapper.(mapper.StateMapper)
+	if !ok {
+		return nil, fmt.Errorf("Mapper %d doesn't support save states", c.Cartridge.MapperID)
+	}
+	return []section{
+		{"CPU ", "CPU", c.cpu.SaveState, c.cpu.LoadState},
+		{"PPU ", "PPU", c.ppu.SaveState, c.ppu.LoadState},
+		{"APU ", "APU", c.apu.SaveState, c.apu.LoadState},
+		{"PAD1", "controller 1", c.controllers[0].SaveState, c.controllers[0].LoadState},
+		{"PAD2", "controller 2", c.controllers[1].SaveState, c.controllers[1].LoadState},
+		{"CART", "cartridge", c.Cartridge.SaveState, c.Cartridge.LoadState},
+		{"MAPR", "mapper", m.SaveState, m.LoadState},
+	}, nil
+}
+
+// A romID identifies the ROM a save state was saved from
+type romID struct {
+	crc32 uint32
+	sha1  [20]byte
+}
+
+func (id *romID) serialize(s *state.Stream) {
+	s.Uint32(&id.crc32)
+	s.Bytes(id.sha1[:])
+}
+
+// SaveState writes a save state of the whole console, which LoadState
+// restores: the CPU, PPU, APU, controllers, cartridge memory and mapper, and
+// the hashes of the ROM it was saved from. It is written in the format of
+// state.WriteFile, with a chunk per component. States can't be saved if the
+// mapper isn't a mapper.StateMapper.
+func (c *Console) SaveState(w io.Writer) error {
+	sections, err := c.sections()
+	if err != nil {
+		return err
+	}
+
+	id := romID{c.Cartridge.CRC32, c.Cartridge.SHA1}
+	var rom bytes.Buffer
+	if err := state.Save(&rom, romStateVersion, id.serialize); err != nil {
+		return err
+	}
+	var frame bytes.Buffer
+	if err := state.Save(&frame, movieStateVersion, c.serializeMovie); err != nil {
+		return err
+	}
+	chunks := []state.Chunk{{ID: "ROM ", Data: rom.Bytes()}, {ID: "MOVI", Data: frame.Bytes()}}
+
+	for _, section := range sections {
+		var data bytes.Buffer
+		if err := section.save(&data); err != nil {
+			return fmt.Errorf("Saving %s state: %v", section.name, err)
+		}
+		chunks = append(chunks, state.Chunk{ID: section.id, Data: data.Bytes()})
+	}
+	return state.WriteFile(w, chunks)
+}
+
+// LoadState restores a save state written by SaveState, which must be of
+// the same ROM. Components the state has no chunk for, as when it was saved
+// by an older release, are left as they are. If the state can't be loaded,
+// the console is left as it was.
+//
+// While a movie is running, the state must have been made during it, and
+// the movie goes back to the frame it was made at.
+func (c *Console) LoadState(r io.Reader) error {
+	return c.loadState(r, c.movie != nil)
+}
+
+// loadState restores a save state, moving the running movie to its frame if
+// seek is set
+func (c *Console) loadState(r io.Reader, seek bool) error {
+	chunks, err := state.ReadFile(r)
+	if err != nil {
+		return err
+	}
+
+	rom, ok := chunks["ROM "]
+	if !ok {
+		return errors.New("Save state doesn't say which ROM it is of")
+	}
+	var id romID
+	if err := state.Load(bytes.NewReader(rom), romStateVersion, id.serialize); err != nil {
+		return fmt.Errorf("Loading ROM state: %v", err)
+	}
+	if id.crc32 != c.Cartridge.CRC32 || id.sha1 != c.Cartridge.SHA1 {
+		return fmt.Errorf("Save state is of a different ROM, CRC32 %08x", id.crc32)
+	}
+
+	frame, err := loadMovieFrame(chunks)
+	if err != nil {
+		return fmt.Errorf("Loading movie state: %v", err)
+	}
+	if seek {
+		if err := c.checkMovieFrame(frame); err != nil {
+			return err
+		}
+	}
+
+	var backup bytes.Buffer
+	if err := c.SaveState(&backup); err != nil {
+		return err
+	}
+	sections, err := c.sections()
+	if err != nil {
+		return err
+	}
+	for _, section := range sections {
+		data, ok := chunks[section.id]
+		if !ok {
+			continue
+		}
+		if err := section.load(bytes.NewReader(data)); err != nil {
+			c.loadState(&backup, false)
+			return fmt.Errorf("Loading %s state: %v", section.name, err)
+		}
+	}
+
+	if seek {
+		c.seekMovie(frame)
+	}
+	return nil
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"image"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/makononov/NESGo/cartridge"
-	"github.com/makononov/NESGo/cpu"
-	"github.com/makononov/NESGo/ppu"
+	"github.com/makononov/NESGo/movie"
+	"github.com/makononov/NESGo/nes"
 	// "github.com/go-gl/glfw/v3.1/glfw"
 )
 
@@ -20,50 +27,61 @@ func init() {
 	runtime.LockOSThread()
 }
 
+// check exits with the error, if there is one
 func check(e error) {
 	if e != nil {
-		panic(e)
+		fmt.Fprintln(os.Stderr, "nesgo:", e)
+		os.Exit(1)
 	}
 }
 
-func main() {
-	fmt.Println("Initializing console...")
-
-	if len(os.Args) != 2 {
-		panic(errors.New("you must provide a ROM file to run"))
-	}
+// patchList collects the -patch flags, which may be repeated
+type patchList []string
 
-	romFile := os.Args[1]
-	_, err := ioutil.ReadFile(romFile)
-	check(err)
+func (p *patchList) String() string {
+	return strings.Join(*p, ",")
+}
 
-	// Initialize cartridge
-	fmt.Println("Reading ROM file and initializing cartridge...")
-	cart, err := cartridge.ParseROM(romFile)
-	check(err)
+func (p *patchList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
 
-	dataBus := make(chan uint8)
-	readWriteBus := make(chan int)
-	cartridgeControlBus := make(chan uint16)
-	ppuControlBus := make(chan uint16)
-	vblankBus := make(chan bool)
+var (
+	patches     patchList
+	noAutoPatch = flag.Bool("no-auto-patch", false, "don't apply a patch named after the ROM file")
+	database    = flag.String("db", "", "ROM database to identify dumps and correct their headers with")
+	trace       = flag.Bool("trace", false, "print each instruction as it is executed")
+	crashDir    = flag.String("crash-dir", "crashes", "directory to write crash reports to")
+	loadSlot    = flag.Int("load-state", -1, "load the save state in a numbered slot at startup")
+	saveSlot    = flag.Int("save-state", -1, "save state to a numbered slot on exit")
+	playMovie   = flag.String("play", "", "play back an FM2 movie")
+	recordMovie = flag.String("record", "", "record an FM2 movie, written on exit; with -play, continue the movie played")
+	readWrite   = flag.Bool("read-write", false, "play the movie in read-write mode, recording once it ends")
+)
 
-	// Initialize cpu
-	fmt.Println("Initializing CPU...")
-	cpu := new(cpu.CPU)
-	cpu.Init(ppuControlBus, cartridgeControlBus, readWriteBus, dataBus, vblankBus)
+func init() {
+	flag.Var(&patches, "patch", "apply an IPS, UPS or BPS patch to the ROM; repeat to stack patches in order")
+}
 
-	fmt.Println("Initializing PPU...")
-	ppu := new(ppu.PPU)
-	ppu.Init(ppuControlBus, readWriteBus, dataBus, vblankBus)
+// commands are run by name in place of a ROM file
+var commands = map[string]func(args []string) error{
+	"header":  rewriteHeader,
+	"info":    showInfo,
+	"mappers": listMappers,
+}
 
-	fmt.Println("Spawning threads...")
-	go cart.WaitForReadWrite(cartridgeControlBus, readWriteBus, dataBus)
-	go cpu.Run()
-	go ppu.Run()
-	for {
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			check(command(os.Args[2:]))
+			return
+		}
 	}
 
+	flag.Parse()
+	check(run())
+
 	// if err := glfw.Init(); err != nil {
 	// 	panic(err)
 	// }
@@ -81,3 +99,168 @@ func main() {
 	// 	glfw.PollEvents()
 	// }
 }
+
+// run loads the ROM and runs it at the console's frame rate until
+// interrupted, writing the save on the way out.
+func run() error {
+	fmt.Println("Initializing console...")
+
+	if flag.NArg() != 1 {
+		return errors.New("you must provide a ROM file to run")
+	}
+
+	fmt.Println("Reading ROM file and initializing cartridge...")
+	cartridge.AutoPatch = !*noAutoPatch
+	cartridge.DatabasePath = *database
+	cart, err := cartridge.ParseROM(flag.Arg(0), patches...)
+	if err != nil {
+		return err
+	}
+	for _, patch := range cart.Patches {
+		fmt.Println("Applied patch", patch)
+	}
+	for _, warning := range cart.Warnings {
+		fmt.Println("Warning:", warning)
+	}
+	if entry := cart.DatabaseEntry; entry != nil {
+		fmt.Printf("Identified %s (%s), board %s\n", entry.Title, entry.Region, entry.Board)
+	}
+	fmt.Printf("Found mapper %d (%s), PRG ROM size: %d, CHR ROM size: %d\n", cart.MapperID, mapperName(cart), cart.PrgRomSize, cart.ChrRomSize)
+
+	var opts nes.Options
+	if *trace {
+		opts.Trace = bufio.NewWriter(os.Stdout)
+	}
+	console, err := nes.New(cart, opts)
+	if err != nil {
+		return err
+	}
+	if *loadSlot >= 0 {
+		if err = loadState(console, statePath(flag.Arg(0), *loadSlot)); err != nil {
+			console.Close()
+			return err
+		}
+	}
+	recording, err := startMovie(console)
+	if err != nil {
+		console.Close()
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The main thread is kept for the window, so the console runs in a
+	// goroutine of its own
+	ticker := time.NewTicker(console.FrameDuration())
+	defer ticker.Stop()
+	done := make(chan error, 1)
+	go func() {
+		done <- console.Run(ctx, func(*image.Paletted, []float32) {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	err = <-done
+	if errors.Is(err, context.Canceled) {
+		err = nil
+		if *saveSlot >= 0 {
+			err = saveState(console, statePath(flag.Arg(0), *saveSlot))
+		}
+	} else if err != nil && *crashDir != "" {
+		if path, reportErr := console.WriteCrashReport(*crashDir, err); reportErr != nil {
+			fmt.Fprintln(os.Stderr, "nesgo: Could not write crash report:", reportErr)
+		} else {
+			fmt.Fprintln(os.Stderr, "nesgo: Crash report written to", path)
+		}
+	}
+	if recording != nil {
+		if movieErr := writeMovie(*recordMovie, recording); err == nil {
+			err = movieErr
+		}
+	}
+	if closeErr := console.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// statePath returns the file for a numbered save state slot, next to the ROM
+func statePath(rom string, slot int) string {
+	return fmt.Sprintf("%s.ss%d", cartridge.ROMBase(rom), slot)
+}
+
+// loadState restores the console from a save state file
+func loadState(console *nes.Console, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = console.LoadState(bufio.NewReader(file)); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	fmt.Println("Loaded state from", path)
+	return nil
+}
+
+// saveState writes a save state of the console to a file
+func saveState(console *nes.Console, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = console.SaveState(file); err != nil {
+		file.Close()
+		return err
+	}
+	fmt.Println("Saved state to", path)
+	return file.Close()
+}
+
+// startMovie plays back or records a movie as the flags say, returning the
+// movie to write out on exit if one is being recorded. Recording starts from
+// power-on, unless a save state was loaded.
+func startMovie(console *nes.Console) (*movie.Movie, error) {
+	if *playMovie == "" {
+		if *recordMovie == "" {
+			return nil, nil
+		}
+		return console.Record(*loadSlot < 0)
+	}
+
+	file, err := os.Open(*playMovie)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	m, err := movie.Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", *playMovie, err)
+	}
+	if err = console.Play(m, !*readWrite && *recordMovie == ""); err != nil {
+		return nil, fmt.Errorf("%s: %v", *playMovie, err)
+	}
+	fmt.Printf("Playing %s, %d frames\n", *playMovie, len(m.Frames))
+	if *recordMovie == "" {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// writeMovie writes a movie to an FM2 file
+func writeMovie(path string, m *movie.Movie) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = m.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	fmt.Printf("Recorded %d frames to %s\n", len(m.Frames), path)
+	return file.Close()
+}
@@ -1,6 +1,23 @@
 package ppu
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"image"
+	"runtime/debug"
+)
+
+// latchDecayFrames is how long a bit of the I/O latch holds its value without
+// being refreshed, about 600ms, before decaying to 0
+const latchDecayFrames = 36
+
+// Memory is the part of the PPU address space the cartridge decodes,
+// $0000-$3EFF: the pattern tables in CHR ROM or RAM, and the nametables
+// mapped onto VRAM as the board is wired.
+type Memory interface {
+	ReadPPU(address uint16) (uint8, error)
+	WritePPU(address uint16, value uint8) error
+}
 
 // PPU emulates the Picture Processing Unit of the NES
 type PPU struct {
@@ -8,6 +25,41 @@ type PPU struct {
 	readWriteBus chan int
 	dataBus      chan uint8
 	vblankBus    chan bool
+	faultBus     chan error
+
+	// memory is the cartridge's side of the address space, and palette the
+	// PPU's own palette RAM at $3F00-$3F1F
+	memory  Memory
+	palette [32]uint8
+
+	// v is the VRAM address and t the one PPUSCROLL and PPUADDR write, which
+	// is copied to v as rendering needs it. w selects which half of t the
+	// next write goes to. readBuffer holds what the next PPUDATA read
+	// returns from below the palettes.
+	v          uint16
+	t          uint16
+	fineX      uint8
+	w          bool
+	readBuffer uint8
+
+	// line and dot are the position in the frame, line -1 being the
+	// pre-render line
+	line int
+	dot  int
+
+	// The background tile being fetched and the shift registers the pixels
+	// are drawn from, and the sprites on the line being drawn, which are
+	// found and fetched on the line before
+	nametableByte  uint8
+	attributeByte  uint8
+	patternLow     uint8
+	patternHigh    uint8
+	backgroundLow  uint16
+	backgroundHigh uint16
+	attributeLow   uint16
+	attributeHigh  uint16
+	sprites        [8]sprite
+	spriteCount    int
 
 	// PPUCTRL flags
 	baseNametableAddress          uint16
@@ -32,36 +84,128 @@ type PPU struct {
 	vblank         bool
 	sprite0Hit     bool
 	spriteOverflow bool
+
+	// latch is the PPU's own data bus to the CPU. Register writes fill it,
+	// and reads return it for the bits the register doesn't drive. Each bit
+	// decays to 0 some time after it was last refreshed, counted in frames.
+	latch          uint8
+	latchRefreshed [8]int
+	frame          int
+
+	// oam is the sprite memory, accessed through OAMADDR and OAMDATA
+	oam        [256]uint8
+	oamAddress uint8
+
+	// picture is the frame being generated
+	picture *image.Paletted
 }
 
 // Init initializes a PPU struct with default values and the passed in
-// bus channel.
-func (p *PPU) Init(controlBus chan uint16, readWriteBus chan int, dataBus chan uint8, vblankBus chan bool) {
+// bus channels. Errors are reported on faultBus.
+func (p *PPU) Init(controlBus chan uint16, readWriteBus chan int, dataBus chan uint8, vblankBus chan bool, faultBus chan error) {
 	p.controlBus = controlBus
 	p.readWriteBus = readWriteBus
 	p.dataBus = dataBus
 	p.vblankBus = vblankBus
+	p.faultBus = faultBus
+	p.PowerUp()
+}
+
+// ConnectMemory connects the cartridge's side of the PPU address space
+func (p *PPU) ConnectMemory(memory Memory) {
+	p.memory = memory
+}
+
+// PowerUp puts the PPU in its power-up state
+func (p *PPU) PowerUp() {
+	p.Reset()
+	p.vblank, p.sprite0Hit, p.spriteOverflow = false, false, false
+	p.latch = 0
+	p.latchRefreshed = [8]int{}
+	p.frame = 0
+	p.oam = [256]uint8{}
+	p.oamAddress = 0
+	p.palette = [32]uint8{}
+	p.v, p.t, p.fineX, p.readBuffer = 0, 0, 0, 0
+	p.line, p.dot = -1, 0
+	p.spriteCount = 0
+	p.picture = image.NewPaletted(image.Rect(0, 0, Width, Height), Palette)
+}
+
+// Reset clears PPUCTRL, PPUMASK, PPUSCROLL and the read buffer, as the
+// reset line does
+func (p *PPU) Reset() {
+	p.setPPUCTRL(0)
+	p.setPPUMASK(0)
+	p.t &^= 0x0c00
+	p.w = false
+	p.readBuffer = 0
+}
+
+// OAM returns the PPU's sprite memory
+func (p *PPU) OAM() []uint8 {
+	return p.oam[:]
+}
+
+// Frame returns the last frame generated
+func (p *PPU) Frame() *image.Paletted {
+	return p.picture
 }
 
 func (p *PPU) readMem(address uint16) (uint8, error) {
-	if address == 0x2002 {
-		return p.ppuSTATUS(), nil
+	switch (address - 0x2000) % 8 {
+	case 2: // PPUSTATUS drives the top three bits
+		p.w = false
+		p.refreshLatch(p.ppuSTATUS(), 0xe0)
+		return p.readLatch(), nil
+	case 4: // OAMDATA
+		p.refreshLatch(p.oam[p.oamAddress], 0xff)
+		return p.readLatch(), nil
+	case 7: // PPUDATA
+		p.refreshLatch(p.readData(), 0xff)
+		return p.readLatch(), nil
 	}
 
-	return 0, fmt.Errorf("Attempt to read PPU memory at 0x%x - not implemented", address)
+	// Write-only registers
+	return p.readLatch(), nil
 }
 
 func (p *PPU) writeMem(address uint16, value uint8) error {
+	p.refreshLatch(value, 0xff)
 	if address >= 0x2000 && address < 0x4000 { // register write channel
 		// each register interface is mirrored every 8 bytes.
 		registerNumber := (address - 0x2000) % 8
 		switch registerNumber {
 		case 0: // PPUCTRL
 			p.setPPUCTRL(value)
+			p.t = p.t&^0x0c00 | uint16(value&0x03)<<10
 			break
 		case 1:
 			p.setPPUMASK(value)
 			break
+		case 3: // OAMADDR
+			p.oamAddress = value
+		case 4: // OAMDATA
+			p.oam[p.oamAddress] = value
+			p.oamAddress++
+		case 5: // PPUSCROLL, X then Y
+			if !p.w {
+				p.t = p.t&^0x001f | uint16(value>>3)
+				p.fineX = value & 0x07
+			} else {
+				p.t = p.t&^0x73e0 | uint16(value&0x07)<<12 | uint16(value>>3)<<5
+			}
+			p.w = !p.w
+		case 6: // PPUADDR, high byte then low
+			if !p.w {
+				p.t = p.t&0x00ff | uint16(value&0x3f)<<8
+			} else {
+				p.t = p.t&0xff00 | uint16(value)
+				p.v = p.t
+			}
+			p.w = !p.w
+		case 7: // PPUDATA
+			p.writeData(value)
 		default:
 			return fmt.Errorf("Attempt to write to PPU Register #%d - not implemented", registerNumber)
 		}
@@ -70,33 +214,65 @@ func (p *PPU) writeMem(address uint16, value uint8) error {
 	return fmt.Errorf("Attempt to write to PPU memory at 0x%x - not implemented", address)
 }
 
-// Run executes the threaded main loop of the PPU
-func (p *PPU) Run() {
-	fmt.Println("PPU spawned, awaiting instructions...")
+// Run executes the threaded main loop of the PPU until ctx is done. Errors
+// accessing registers are reported on the fault bus before the access is
+// completed.
+func (p *PPU) Run(ctx context.Context) {
 	for {
 		select {
+		case <-ctx.Done():
+			return
+
 		case p.vblank = <-p.vblankBus:
 			if p.vblank {
-				fmt.Println("*** VBLANK ***")
-				// VBLANK CODE
+				p.frame++
+			} else {
+				p.startFrame()
 			}
+			p.vblankBus <- p.vblank
 
 		case address := <-p.controlBus:
 			if <-p.readWriteBus == 0 { // read
-				val, err := p.readMem(address)
-				if err != nil {
-					panic(err)
-				}
+				// The PPU drives every bit, so the CPU's open bus is unused
+				<-p.dataBus
+				var val uint8
+				p.report(func() (err error) {
+					val, err = p.readMem(address)
+					return err
+				})
 				p.dataBus <- val
 			} else { // write
-				if err := p.writeMem(address, <-p.dataBus); err != nil {
-					panic(err)
-				}
+				val := <-p.dataBus
+				p.report(func() error {
+					return p.writeMem(address, val)
+				})
+				p.dataBus <- val
 			}
 		}
 	}
 }
 
+// report runs a register access, sending any error it returns or panic it
+// raises to the fault bus
+func (p *PPU) report(access func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.fault(fmt.Errorf("Panic: %v\n%s", r, debug.Stack()))
+		}
+	}()
+	if err := access(); err != nil {
+		p.fault(err)
+	}
+}
+
+// fault reports an error unless one is already waiting to be raised
+func (p *PPU) fault(err error) {
+	select {
+	case p.faultBus <- err:
+	default:
+	}
+}
+
 func (p *PPU) setPPUCTRL(val uint8) {
 	p.baseNametableAddress = 0x2000 + (uint16(val&0x03) * 0x0400)
 
@@ -135,6 +311,27 @@ func (p *PPU) setPPUMASK(val uint8) {
 	p.emphasizeRed = (val&0x80 != 0)
 }
 
+// refreshLatch sets the bits of the I/O latch in mask
+func (p *PPU) refreshLatch(value uint8, mask uint8) {
+	p.latch = p.latch&^mask | value&mask
+	for bit := range p.latchRefreshed {
+		if mask&(1<<uint(bit)) != 0 {
+			p.latchRefreshed[bit] = p.frame
+		}
+	}
+}
+
+// readLatch returns the I/O latch, with the bits that haven't been refreshed
+// recently decayed
+func (p *PPU) readLatch() uint8 {
+	for bit, refreshed := range p.latchRefreshed {
+		if p.frame-refreshed >= latchDecayFrames {
+			p.latch &^= 1 << uint(bit)
+		}
+	}
+	return p.latch
+}
+
 func (p *PPU) ppuSTATUS() uint8 {
 	value := uint8(0)
 	if p.vblank {
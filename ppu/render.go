@@ -0,0 +1,375 @@
+package ppu
+
+// dotsPerLine is the length of a scanline in PPU dots, three to a CPU cycle
+const dotsPerLine = 341
+
+// A sprite is one of the eight found for a line. row is the row of its
+// pattern on the line, which is fetched and flipped so that the leftmost
+// pixel is in bit 7.
+type sprite struct {
+	x           uint8
+	tile        uint8
+	attributes  uint8
+	row         int
+	patternLow  uint8
+	patternHigh uint8
+	zero        bool
+}
+
+// Clock advances the PPU by one CPU cycle, three dots, making the fetches
+// the rendering hardware makes through the cartridge as it draws the
+// picture. Fetches follow the 2C02's order, as mappers that count scanlines
+// or switch banks partway through a line watch them: on each visible line
+// and the pre-render line, 32 tiles' nametable, attribute and pattern
+// bytes, then two nametable and two pattern fetches for each of eight
+// sprites, the first two tiles of the next line and two more nametable
+// fetches.
+//
+// The frame timing comes from the CPU, which ends VBLANK as the pre-render
+// line starts.
+func (p *PPU) Clock() {
+	for i := 0; i < 3; i++ {
+		p.tick()
+		p.dot++
+		if p.dot == dotsPerLine {
+			p.dot = 0
+			p.line++
+		}
+	}
+}
+
+// startFrame moves to the start of the pre-render line, which clears the
+// sprite flags
+func (p *PPU) startFrame() {
+	p.line, p.dot = -1, 0
+	p.sprite0Hit, p.spriteOverflow = false, false
+}
+
+// rendering reports whether the background or sprites are enabled, without
+// which the PPU makes no fetches and leaves the VRAM address alone
+func (p *PPU) rendering() bool {
+	return p.showBackground || p.showSprites
+}
+
+func (p *PPU) tick() {
+	if p.line >= Height || p.dot == 0 {
+		return
+	}
+	if !p.rendering() {
+		if p.line >= 0 && p.dot <= Width {
+			p.picture.SetColorIndex(p.dot-1, p.line, p.color(p.palette[0]))
+		}
+		return
+	}
+
+	dot := p.dot
+	switch {
+	case dot <= 256 || (dot >= 321 && dot <= 336):
+		if dot != 1 && dot != 321 {
+			p.shiftBackground()
+		}
+		p.fetchBackground(dot)
+		if dot == 256 {
+			p.incrementY()
+		}
+	case dot == 257:
+		p.shiftBackground()
+		p.loadBackground()
+		p.v = p.v&^0x041f | p.t&0x041f
+		p.evaluateSprites()
+		p.fetchSprite(dot)
+	case dot <= 320:
+		p.fetchSprite(dot)
+		if p.line == -1 && dot >= 280 && dot <= 304 {
+			p.v = p.v&^0x7be0 | p.t&0x7be0
+		}
+	case dot == 337:
+		p.shiftBackground()
+		p.loadBackground()
+		p.read(0x2000 | p.v&0x0fff)
+	case dot == 339:
+		p.read(0x2000 | p.v&0x0fff)
+	}
+
+	if p.line >= 0 && dot <= Width {
+		p.drawPixel(dot - 1)
+	}
+}
+
+// fetchBackground makes one step of the four fetches for a tile, each of
+// which takes two dots
+func (p *PPU) fetchBackground(dot int) {
+	switch (dot - 1) % 8 {
+	case 0:
+		if dot >= 9 {
+			p.loadBackground()
+		}
+		p.nametableByte = p.read(0x2000 | p.v&0x0fff)
+	case 2:
+		attribute := p.read(0x23c0 | p.v&0x0c00 | (p.v>>4)&0x38 | (p.v>>2)&0x07)
+		shift := (p.v>>4)&0x04 | p.v&0x02
+		p.attributeByte = (attribute >> shift) & 0x03
+	case 4:
+		p.patternLow = p.read(p.backgroundPatternTableAddress + uint16(p.nametableByte)*16 + p.v>>12)
+	case 6:
+		p.patternHigh = p.read(p.backgroundPatternTableAddress + uint16(p.nametableByte)*16 + p.v>>12 + 8)
+	case 7:
+		p.incrementX()
+	}
+}
+
+// loadBackground moves the tile just fetched into the low byte of the shift
+// registers
+func (p *PPU) loadBackground() {
+	p.backgroundLow = p.backgroundLow&0xff00 | uint16(p.patternLow)
+	p.backgroundHigh = p.backgroundHigh&0xff00 | uint16(p.patternHigh)
+	p.attributeLow = p.attributeLow & 0xff00
+	p.attributeHigh = p.attributeHigh & 0xff00
+	if p.attributeByte&0x01 != 0 {
+		p.attributeLow |= 0x00ff
+	}
+	if p.attributeByte&0x02 != 0 {
+		p.attributeHigh |= 0x00ff
+	}
+}
+
+func (p *PPU) shiftBackground() {
+	p.backgroundLow <<= 1
+	p.backgroundHigh <<= 1
+	p.attributeLow <<= 1
+	p.attributeHigh <<= 1
+}
+
+// incrementX moves v to the next tile, wrapping into the next nametable
+// across
+func (p *PPU) incrementX() {
+	if p.v&0x001f == 31 {
+		p.v &^= 0x001f
+		p.v ^= 0x0400
+	} else {
+		p.v++
+	}
+}
+
+// incrementY moves v down a row of pixels, wrapping into the next nametable
+// down after the 30th row of tiles
+func (p *PPU) incrementY() {
+	if p.v&0x7000 != 0x7000 {
+		p.v += 0x1000
+		return
+	}
+
+	p.v &^= 0x7000
+	switch y := (p.v & 0x03e0) >> 5; y {
+	case 29:
+		p.v &^= 0x03e0
+		p.v ^= 0x0800
+	case 31:
+		p.v &^= 0x03e0
+	default:
+		p.v += 0x0020
+	}
+}
+
+// spriteHeight is 8 or 16, depending on the sprite size PPUCTRL selects
+func (p *PPU) spriteHeight() int {
+	if p.doubleHeightSprites {
+		return 16
+	}
+	return 8
+}
+
+// evaluateSprites finds the first eight sprites in OAM on the next line,
+// setting the overflow flag if there are more. Sprites are drawn a line
+// below their Y coordinate, and none are found on the pre-render line.
+func (p *PPU) evaluateSprites() {
+	p.spriteCount = 0
+	if p.line < 0 {
+		return
+	}
+
+	for i := 0; i < 64; i++ {
+		row := p.line - int(p.oam[i*4])
+		if row < 0 || row >= p.spriteHeight() {
+			continue
+		}
+		if p.spriteCount == len(p.sprites) {
+			p.spriteOverflow = true
+			return
+		}
+		p.sprites[p.spriteCount] = sprite{
+			x:          p.oam[i*4+3],
+			tile:       p.oam[i*4+1],
+			attributes: p.oam[i*4+2],
+			row:        row,
+			zero:       i == 0,
+		}
+		p.spriteCount++
+	}
+}
+
+// fetchSprite makes one step of the fetches for the eight sprite slots: two
+// nametable bytes, which are unused, and the two bytes of the pattern row.
+// Empty slots fetch tile $FF.
+func (p *PPU) fetchSprite(dot int) {
+	slot := (dot - 257) / 8
+	switch (dot - 257) % 8 {
+	case 0, 2:
+		p.read(0x2000 | p.v&0x0fff)
+	case 4:
+		p.sprites[slot].patternLow = p.spritePattern(slot, 0)
+	case 6:
+		p.sprites[slot].patternHigh = p.spritePattern(slot, 8)
+	}
+}
+
+// spritePattern fetches a plane of the row of a sprite's pattern on the next
+// line
+func (p *PPU) spritePattern(slot int, plane uint16) uint8 {
+	tile, row, attributes := uint8(0xff), 0, uint8(0)
+	if slot < p.spriteCount {
+		s := p.sprites[slot]
+		tile, row, attributes = s.tile, s.row, s.attributes
+		if attributes&0x80 != 0 {
+			row = p.spriteHeight() - 1 - row
+		}
+	}
+
+	table := p.spritePatternTableAddress
+	if p.doubleHeightSprites {
+		table = uint16(tile&0x01) * 0x1000
+		tile &^= 0x01
+		if row >= 8 {
+			tile++
+			row -= 8
+		}
+	}
+
+	value := p.read(table + uint16(tile)*16 + uint16(row) + plane)
+	if attributes&0x40 != 0 {
+		value = reverse(value)
+	}
+	return value
+}
+
+// drawPixel draws the pixel at x on the current line from the background
+// shift registers and the line's sprites
+func (p *PPU) drawPixel(x int) {
+	var background, palette uint8
+	if p.showBackground && (x >= 8 || p.showLeftBackground) {
+		bit := uint16(0x8000) >> p.fineX
+		if p.backgroundLow&bit != 0 {
+			background |= 0x01
+		}
+		if p.backgroundHigh&bit != 0 {
+			background |= 0x02
+		}
+		if p.attributeLow&bit != 0 {
+			palette |= 0x01
+		}
+		if p.attributeHigh&bit != 0 {
+			palette |= 0x02
+		}
+	}
+
+	color := p.palette[0]
+	if background != 0 {
+		color = p.palette[palette<<2|background]
+	}
+
+	if p.showSprites && (x >= 8 || p.showLeftSprites) {
+		for i := 0; i < p.spriteCount; i++ {
+			s := p.sprites[i]
+			offset := x - int(s.x)
+			if offset < 0 || offset >= 8 {
+				continue
+			}
+			pixel := (s.patternLow>>(7-uint(offset)))&0x01 | (s.patternHigh>>(7-uint(offset)))<<1&0x02
+			if pixel == 0 {
+				continue
+			}
+			if s.zero && background != 0 && x != 255 {
+				p.sprite0Hit = true
+			}
+			if background == 0 || s.attributes&0x20 == 0 {
+				color = p.palette[0x10|(s.attributes&0x03)<<2|pixel]
+			}
+			break
+		}
+	}
+
+	p.picture.SetColorIndex(x, p.line, p.color(color))
+}
+
+// color converts a palette entry to an index into Palette
+func (p *PPU) color(entry uint8) uint8 {
+	if p.grayscale {
+		return entry & 0x30
+	}
+	return entry & 0x3f
+}
+
+// readData returns the PPUDATA read at v and moves v on. Reads below the
+// palettes return the buffer, which is then filled from the address; palette
+// reads return directly, and fill it from the nametable underneath.
+func (p *PPU) readData() uint8 {
+	address := p.v & 0x3fff
+	value := p.readBuffer
+	if address >= 0x3f00 {
+		value = p.palette[paletteIndex(address)]
+		p.readBuffer = p.read(address - 0x1000)
+	} else {
+		p.readBuffer = p.read(address)
+	}
+	p.v += uint16(p.vramAddressIncrement)
+	return value
+}
+
+// writeData writes PPUDATA at v and moves v on
+func (p *PPU) writeData(value uint8) {
+	address := p.v & 0x3fff
+	if address >= 0x3f00 {
+		p.palette[paletteIndex(address)] = value & 0x3f
+	} else {
+		p.write(address, value)
+	}
+	p.v += uint16(p.vramAddressIncrement)
+}
+
+// paletteIndex maps a palette address onto palette RAM, where the sprite
+// palettes' transparent entries are the background's
+func paletteIndex(address uint16) uint16 {
+	index := address & 0x1f
+	if index&0x13 == 0x10 {
+		index &^= 0x10
+	}
+	return index
+}
+
+// read fetches from the cartridge. What it doesn't respond to reads as 0.
+func (p *PPU) read(address uint16) uint8 {
+	if p.memory == nil {
+		return 0
+	}
+	value, err := p.memory.ReadPPU(address)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// write writes to the cartridge, which ignores writes to ROM as the
+// hardware does
+func (p *PPU) write(address uint16, value uint8) {
+	if p.memory != nil {
+		p.memory.WritePPU(address, value)
+	}
+}
+
+// reverse reverses the bits of a pattern byte, for horizontally flipped
+// sprites
+func reverse(value uint8) uint8 {
+	value = value&0xf0>>4 | value&0x0f<<4
+	value = value&0xcc>>2 | value&0x33<<2
+	return value&0xaa>>1 | value&0x55<<1
+}
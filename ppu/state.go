@@ -0,0 +1,78 @@
+package ppu
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/makononov/NESGo/state"
+)
+
+// stateVersion is the version of the PPU's save state section. Version 2
+// added palette RAM, the VRAM address and the rendering position.
+const stateVersion = 2
+
+// SaveState writes the PPU's registers, I/O latch, palette and sprite memory
+// and where it is in the frame. The picture isn't saved, as the next frame
+// replaces it.
+func (p *PPU) SaveState(w io.Writer) error {
+	return state.Save(w, stateVersion, p.serialize)
+}
+
+// LoadState restores the PPU from a state written by SaveState
+func (p *PPU) LoadState(r io.Reader) error {
+	return state.Load(r, stateVersion, p.serialize)
+}
+
+func (p *PPU) serialize(s *state.Stream) {
+	s.Uint16(&p.baseNametableAddress)
+	s.Int(&p.vramAddressIncrement)
+	s.Uint16(&p.spritePatternTableAddress)
+	s.Uint16(&p.backgroundPatternTableAddress)
+	for _, flag := range []*bool{
+		&p.doubleHeightSprites, &p.ppuMaster, &p.vblankNMI,
+		&p.grayscale, &p.showLeftBackground, &p.showLeftSprites, &p.showBackground, &p.showSprites,
+		&p.emphasizeRed, &p.emphasizeGreen, &p.emphasizeBlue,
+		&p.vblank, &p.sprite0Hit, &p.spriteOverflow,
+	} {
+		s.Bool(flag)
+	}
+
+	s.Uint8(&p.latch)
+	s.Ints(p.latchRefreshed[:])
+	s.Int(&p.frame)
+
+	s.Bytes(p.oam[:])
+	s.Uint8(&p.oamAddress)
+
+	if s.Version() < 2 {
+		return
+	}
+	s.Bytes(p.palette[:])
+	s.Uint16(&p.v)
+	s.Uint16(&p.t)
+	s.Uint8(&p.fineX)
+	s.Bool(&p.w)
+	s.Uint8(&p.readBuffer)
+	s.Int(&p.line)
+	s.Int(&p.dot)
+
+	for _, b := range []*uint8{&p.nametableByte, &p.attributeByte, &p.patternLow, &p.patternHigh} {
+		s.Uint8(b)
+	}
+	for _, shift := range []*uint16{&p.backgroundLow, &p.backgroundHigh, &p.attributeLow, &p.attributeHigh} {
+		s.Uint16(shift)
+	}
+	s.Int(&p.spriteCount)
+	if p.spriteCount < 0 || p.spriteCount > len(p.sprites) {
+		s.Fail(fmt.Errorf("Invalid sprite count %d", p.spriteCount))
+		return
+	}
+	for i := range p.sprites {
+		sprite := &p.sprites[i]
+		for _, b := range []*uint8{&sprite.x, &sprite.tile, &sprite.attributes, &sprite.patternLow, &sprite.patternHigh} {
+			s.Uint8(b)
+		}
+		s.Int(&sprite.row)
+		s.Bool(&sprite.zero)
+	}
+}
@@ -0,0 +1,75 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Delta encodes the difference between two states, which ApplyDelta turns
+// back into next given base. The states are XORed, which leaves zeros
+// wherever they match, and then run-length encoded as the length of next
+// followed by pairs of a run of zeros and a run of literal bytes. Lengths
+// are varints. States of different lengths are XORed as if the shorter were
+// padded with zeros.
+func Delta(base []byte, next []byte) []byte {
+	delta := binary.AppendUvarint(nil, uint64(len(next)))
+	for i := 0; i < len(next); {
+		zeros := i
+		for i < len(next) && next[i] == at(base, i) {
+			i++
+		}
+		literal := i
+		for i < len(next) && next[i] != at(base, i) {
+			i++
+		}
+		delta = binary.AppendUvarint(delta, uint64(literal-zeros))
+		delta = binary.AppendUvarint(delta, uint64(i-literal))
+		for j := literal; j < i; j++ {
+			delta = append(delta, next[j]^at(base, j))
+		}
+	}
+	return delta
+}
+
+// ApplyDelta returns the state a delta from Delta was made of, given the
+// base it was made against
+func ApplyDelta(base []byte, delta []byte) ([]byte, error) {
+	length, n := binary.Uvarint(delta)
+	if n <= 0 || length > maxChunk {
+		return nil, errDelta
+	}
+	delta = delta[n:]
+
+	next := make([]byte, length)
+	copy(next, base)
+	for i := 0; len(delta) > 0; {
+		zeros, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, errDelta
+		}
+		delta = delta[n:]
+		literal, n := binary.Uvarint(delta)
+		if n <= 0 || literal > uint64(len(delta)-n) || uint64(i)+zeros+literal > length {
+			return nil, errDelta
+		}
+		delta = delta[n:]
+
+		i += int(zeros)
+		for _, x := range delta[:literal] {
+			next[i] = x ^ at(base, i)
+			i++
+		}
+		delta = delta[literal:]
+	}
+	return next, nil
+}
+
+var errDelta = errors.New("Corrupt state delta")
+
+// at returns a byte of a state, or 0 past its end
+func at(state []byte, i int) byte {
+	if i < len(state) {
+		return state[i]
+	}
+	return 0
+}
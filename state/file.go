@@ -0,0 +1,90 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic starts every save state file
+const Magic = "NESS"
+
+// FormatVersion is the version of the file layout written. Sections carry
+// versions of their own.
+const FormatVersion = 1
+
+// A Chunk is one section of a save state file, named by a four character ID
+type Chunk struct {
+	ID   string
+	Data []byte
+}
+
+// WriteFile writes a save state file: the magic number and format version,
+// followed by each chunk's ID, its length in bytes as 32 bits and its data.
+// Integers are little-endian.
+func WriteFile(w io.Writer, chunks []Chunk) error {
+	var header bytes.Buffer
+	header.WriteString(Magic)
+	binary.Write(&header, binary.LittleEndian, uint16(FormatVersion))
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if len(chunk.ID) != 4 {
+			return fmt.Errorf("Chunk ID %q isn't four characters", chunk.ID)
+		}
+		var length [4]byte
+		binary.LittleEndian.PutUint32(length[:], uint32(len(chunk.Data)))
+		for _, data := range [][]byte{[]byte(chunk.ID), length[:], chunk.Data} {
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadFile reads the chunks of a save state file by ID. Files in a newer
+// format can't be read, and unknown chunks are returned for the caller to
+// ignore.
+func ReadFile(r io.Reader) (map[string][]byte, error) {
+	var header [6]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("Reading save state header: %v", err)
+	}
+	if string(header[:4]) != Magic {
+		return nil, errors.New("Not a save state file")
+	}
+	version := binary.LittleEndian.Uint16(header[4:])
+	if version > FormatVersion {
+		return nil, fmt.Errorf("Save state format %d is newer than the supported format %d", version, FormatVersion)
+	}
+
+	chunks := map[string][]byte{}
+	for {
+		var chunkHeader [8]byte
+		_, err := io.ReadFull(r, chunkHeader[:])
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Reading save state chunk: %v", err)
+		}
+		id := string(chunkHeader[:4])
+		length := binary.LittleEndian.Uint32(chunkHeader[4:])
+		if length > maxChunk {
+			return nil, fmt.Errorf("Save state chunk %q is %d bytes, more than the %d allowed", id, length, maxChunk)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("Reading save state chunk %q: %v", id, err)
+		}
+		chunks[id] = data
+	}
+	return chunks, nil
+}
+
+// maxChunk limits how much ReadFile allocates for a corrupt chunk
+const maxChunk = 64 << 20
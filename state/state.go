@@ -0,0 +1,199 @@
+// Package state serializes emulator state for save states.
+//
+// Each component saves itself as a section that starts with its own version
+// number, through a Stream that both writes and reads, so that one function
+// describes the layout for both directions. When a component's state
+// changes, it bumps its version and checks Version() when loading, so that
+// states from older releases still load. A save state file is a set of
+// these sections in chunks, as written by WriteFile.
+package state
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A Stream writes or reads the state of one component
+type Stream struct {
+	w       io.Writer
+	r       io.Reader
+	version uint8
+	err     error
+	buf     [8]byte
+}
+
+// Save writes a section at the given version, with its fields written by f
+func Save(w io.Writer, version uint8, f func(s *Stream)) error {
+	s := &Stream{w: w, version: version}
+	s.Uint8(&version)
+	f(s)
+	return s.err
+}
+
+// Load reads a section written by Save, with its fields read by f. Sections
+// newer than latest can't be read.
+func Load(r io.Reader, latest uint8, f func(s *Stream)) error {
+	s := &Stream{r: r}
+	s.Uint8(&s.version)
+	if s.err != nil {
+		return s.err
+	}
+	if s.version > latest {
+		return fmt.Errorf("State version %d is newer than the supported version %d", s.version, latest)
+	}
+	f(s)
+	return s.err
+}
+
+// Loading returns whether the stream is reading state
+func (s *Stream) Loading() bool {
+	return s.r != nil
+}
+
+// Version returns the version of the section being read or written
+func (s *Stream) Version() uint8 {
+	return s.version
+}
+
+// Err returns the first error writing or reading
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Fail stops the stream with an error, as when the state read is invalid
+func (s *Stream) Fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// field writes or reads n bytes of s.buf
+func (s *Stream) field(n int) bool {
+	if s.err != nil {
+		return false
+	}
+	if s.r != nil {
+		_, s.err = io.ReadFull(s.r, s.buf[:n])
+	} else {
+		_, s.err = s.w.Write(s.buf[:n])
+	}
+	if s.err == io.EOF {
+		s.err = io.ErrUnexpectedEOF
+	}
+	return s.err == nil
+}
+
+// Uint8 writes or reads a byte
+func (s *Stream) Uint8(v *uint8) {
+	s.buf[0] = *v
+	if s.field(1) {
+		*v = s.buf[0]
+	}
+}
+
+// Uint16 writes or reads a 16-bit value
+func (s *Stream) Uint16(v *uint16) {
+	binary.LittleEndian.PutUint16(s.buf[:], *v)
+	if s.field(2) {
+		*v = binary.LittleEndian.Uint16(s.buf[:])
+	}
+}
+
+// Uint32 writes or reads a 32-bit value
+func (s *Stream) Uint32(v *uint32) {
+	binary.LittleEndian.PutUint32(s.buf[:], *v)
+	if s.field(4) {
+		*v = binary.LittleEndian.Uint32(s.buf[:])
+	}
+}
+
+// Uint64 writes or reads a 64-bit value
+func (s *Stream) Uint64(v *uint64) {
+	binary.LittleEndian.PutUint64(s.buf[:], *v)
+	if s.field(8) {
+		*v = binary.LittleEndian.Uint64(s.buf[:])
+	}
+}
+
+// Int writes or reads an int, as 64 bits
+func (s *Stream) Int(v *int) {
+	value := uint64(int64(*v))
+	s.Uint64(&value)
+	*v = int(int64(value))
+}
+
+// Bool writes or reads a flag
+func (s *Stream) Bool(v *bool) {
+	var value uint8
+	if *v {
+		value = 1
+	}
+	s.Uint8(&value)
+	*v = value != 0
+}
+
+// Float32 writes or reads a floating point value
+func (s *Stream) Float32(v *float32) {
+	value := math.Float32bits(*v)
+	s.Uint32(&value)
+	*v = math.Float32frombits(value)
+}
+
+// Float64 writes or reads a double precision floating point value
+func (s *Stream) Float64(v *float64) {
+	value := math.Float64bits(*v)
+	s.Uint64(&value)
+	*v = math.Float64frombits(value)
+}
+
+// Ints writes or reads each of a slice of ints
+func (s *Stream) Ints(v []int) {
+	for i := range v {
+		s.Int(&v[i])
+	}
+}
+
+// Bytes writes or reads memory whose size is fixed by the cartridge, like
+// RAM. The size is saved with it, and must match when it is read back.
+func (s *Stream) Bytes(v []byte) {
+	size := uint32(len(v))
+	s.Uint32(&size)
+	if s.err == nil && int(size) != len(v) {
+		s.err = fmt.Errorf("State has %d bytes of memory where %d were expected", size, len(v))
+	}
+	s.raw(v)
+}
+
+// Slice writes or reads memory of any size up to 16MB, allocating it when
+// read
+func (s *Stream) Slice(v *[]byte) {
+	size := uint32(len(*v))
+	s.Uint32(&size)
+	if s.err == nil && size > maxSlice {
+		s.err = fmt.Errorf("State has %d bytes of memory, more than the %d allowed", size, maxSlice)
+	}
+	if s.err == nil && s.r != nil && int(size) != len(*v) {
+		*v = make([]byte, size)
+	}
+	s.raw(*v)
+}
+
+// maxSlice limits how much Slice allocates for a corrupt state
+const maxSlice = 16 << 20
+
+// raw writes or reads memory with no size
+func (s *Stream) raw(v []byte) {
+	if s.err != nil {
+		return
+	}
+	if s.r != nil {
+		_, s.err = io.ReadFull(s.r, v)
+		if s.err == io.EOF {
+			s.err = io.ErrUnexpectedEOF
+		}
+	} else {
+		_, s.err = s.w.Write(v)
+	}
+}